@@ -56,6 +56,8 @@ func (a *lcAdmin) printHelp() {
 	fmt.Printf("    Get information on prospector state and running harvesters\n")
 	fmt.Printf("  publisher [status | endpoints [id]]\n")
 	fmt.Printf("    Get information on connectivity and endpoints\n")
+	fmt.Printf("  spooler [status]\n")
+	fmt.Printf("    Get information on events waiting to be published\n")
 	fmt.Printf("  reload\n")
 	fmt.Printf("    Signals Log Courier to reload its configuration\n")
 	fmt.Printf("  version\n")
@@ -23,6 +23,13 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
+)
+
+// The DNS lookup functions, which can be replaced so tests do not require DNS
+var (
+	lookupSRV = net.LookupSRV
+	lookupIP  = net.LookupIP
 )
 
 // Pool looks up server addresses and manages a pool of IPs
@@ -30,10 +37,18 @@ type Pool struct {
 	server         string
 	rfc2782        bool
 	rfc2782Service string
-	hostIsIP       bool
 	host           string
 	desc           string
-	addresses      []*net.TCPAddr
+	addresses      []*poolAddress
+	next           int
+}
+
+// poolAddress is a single address within the pool along with the hostname it
+// was looked up from
+type poolAddress struct {
+	addr *net.TCPAddr
+	host string
+	desc string
 }
 
 // NewPool creates a new Pool instance for a server
@@ -54,38 +69,44 @@ func (p *Pool) SetRfc2782(enabled bool, service string) {
 // in the pool. In other words, if the last call to Next returned the last entry
 // or has never been called
 func (p *Pool) IsLast() bool {
-	return p.addresses == nil
+	return p.next == 0
 }
 
 // Next returns the next available IP address from the pool
-// Each time all IPs have been returned, the server is looked up again if
-// necessary and the IP addresses are returned again in order.
+// The server is looked up again on every call, so that DNS changes are picked
+// up by the very next connection attempt, and the IP addresses are returned in
+// order, starting again from the first once all have been returned.
 func (p *Pool) Next() (*net.TCPAddr, error) {
-	// Have we exhausted the address list we had? Look up the addresses again
-	// TODO: Should we expire the list to ensure old entries are not reused after
-	// many many hours/days?
-	if p.addresses == nil {
-		p.addresses = make([]*net.TCPAddr, 0)
-		if err := p.populateAddresses(); err != nil {
-			p.addresses = nil
-			return nil, err
-		}
+	p.addresses = make([]*poolAddress, 0)
+	if err := p.populateAddresses(); err != nil {
+		p.addresses = nil
+		p.next = 0
+		return nil, err
 	}
 
-	next := p.addresses[0]
-	if len(p.addresses) > 1 {
-		p.addresses = p.addresses[1:]
-	} else {
-		p.addresses = nil
+	// Continue from the address returned last time if it is still present, as
+	// the order of SRV targets of equal priority can change between lookups
+	index := p.next
+	for i, address := range p.addresses {
+		if address.desc == p.desc {
+			index = i + 1
+			break
+		}
+	}
+	if index >= len(p.addresses) {
+		index = 0
 	}
 
-	if p.hostIsIP {
-		p.desc = fmt.Sprintf("%s", next)
-	} else {
-		p.desc = fmt.Sprintf("%s (%s)", next, p.host)
+	next := p.addresses[index]
+	p.next = index + 1
+	if p.next >= len(p.addresses) {
+		p.next = 0
 	}
 
-	return next, nil
+	p.host = next.host
+	p.desc = next.desc
+
+	return next.addr, nil
 }
 
 // Server returns the server configuration entry the address pool was associated
@@ -95,7 +116,8 @@ func (p *Pool) Server() string {
 }
 
 // Host returns the DNS hostname for the last returned address. This can be used
-// for server name verification such as with TLS
+// for server name verification such as with TLS. For SRV records this is the
+// target hostname from the record
 func (p *Pool) Host() string {
 	return p.host
 }
@@ -103,23 +125,40 @@ func (p *Pool) Host() string {
 // Desc returns a friendly description of the last returned server.
 // Example for an IP: 127.0.0.1
 //                Hostname: localhost (127.0.0.1)
-// TODO: Improve Desc result for SRV records to include the SRV record
+//                SRV record: 127.0.0.1:5043 (target.example.com) from example.com
 func (p *Pool) Desc() string {
 	return p.desc
 }
 
+// srvName returns the SRV record name if the server is an SRV record entry,
+// which is one beginning with "@" or "srv:"
+func (p *Pool) srvName() (string, bool) {
+	if len(p.server) > 0 && p.server[0] == '@' {
+		return p.server[1:], true
+	}
+
+	if strings.HasPrefix(p.server, "srv:") {
+		return p.server[4:], true
+	}
+
+	return "", false
+}
+
 // populateAddresses performs the lookups necessary to obtain the pool of IP
 // addresses for the associated server
 func (p *Pool) populateAddresses() error {
-	// @hostname means SRV record where the host and port are in the record
-	if len(p.server) > 0 && p.server[0] == '@' {
-		srvs, err := p.processSrv(p.server[1:])
+	// @hostname or srv:hostname means SRV record where the host and port are in
+	// the record
+	if name, isSrv := p.srvName(); isSrv {
+		srvs, err := p.processSrv(name)
 		if err != nil {
 			return err
 		}
 
+		// Targets are sorted by priority and randomised by weight by LookupSRV
 		for _, srv := range srvs {
-			if _, err := p.populateLookup(srv.Target, int(srv.Port)); err != nil {
+			target := strings.TrimSuffix(srv.Target, ".")
+			if err := p.populateLookup(target, int(srv.Port), name); err != nil {
 				return err
 			}
 		}
@@ -127,11 +166,14 @@ func (p *Pool) populateAddresses() error {
 		return nil
 	}
 
-	// Standard host:port declaration
-	var portStr string
+	// Standard host:port declaration, where IPv6 addresses are in brackets
+	var host, portStr string
 	var port uint64
 	var err error
-	if p.host, portStr, err = net.SplitHostPort(p.server); err != nil {
+	if host, portStr, err = net.SplitHostPort(p.server); err != nil {
+		if strings.Count(p.server, ":") > 1 && !strings.HasPrefix(p.server, "[") {
+			return fmt.Errorf("Invalid hostport given, IPv6 addresses must be enclosed in square brackets such as [::1]:5043: %s", p.server)
+		}
 		return fmt.Errorf("Invalid hostport given: %s", p.server)
 	}
 
@@ -139,64 +181,85 @@ func (p *Pool) populateAddresses() error {
 		return fmt.Errorf("Invalid port given: %s", portStr)
 	}
 
-	if p.hostIsIP, err = p.populateLookup(p.host, int(port)); err != nil {
-		return err
-	}
-
-	return nil
+	return p.populateLookup(host, int(port), "")
 }
 
 // processSrv looks up SRV records based on the SRV settings
-// TODO: processSrv sets Host() to the SRV record name, not the target hostname,
-//       which would potentially break certificate name verification
 func (p *Pool) processSrv(server string) ([]*net.SRV, error) {
 	var service, protocol string
 
-	p.host = server
-	p.hostIsIP = false
-
 	if p.rfc2782 {
 		service, protocol = p.rfc2782Service, "tcp"
 	} else {
 		service, protocol = "", ""
 	}
 
-	_, srvs, err := net.LookupSRV(service, protocol, p.host)
+	_, srvs, err := lookupSRV(service, protocol, server)
 	if err != nil {
-		return nil, fmt.Errorf("DNS SRV lookup failure \"%s\": %s", p.host, err)
+		return nil, fmt.Errorf("DNS SRV lookup failure \"%s\": %s", server, err)
 	} else if len(srvs) == 0 {
-		return nil, fmt.Errorf("DNS SRV lookup failure \"%s\": No targets found", p.host)
+		return nil, fmt.Errorf("DNS SRV lookup failure \"%s\": No targets found", server)
 	}
 
 	return srvs, nil
 }
 
-// populateLookup detects IP addresses and looks up DNS A records
-func (p *Pool) populateLookup(host string, port int) (bool, error) {
+// populateLookup detects IP addresses and looks up DNS A and AAAA records,
+// adding the results to the pool. If the host was a target of an SRV record,
+// srv should be the record name
+func (p *Pool) populateLookup(host string, port int, srv string) error {
 	if ip := net.ParseIP(host); ip != nil {
 		// IP address
-		p.addresses = append(p.addresses, &net.TCPAddr{
+		addr := &net.TCPAddr{
 			IP:   ip,
 			Port: port,
+		}
+
+		p.addresses = append(p.addresses, &poolAddress{
+			addr: addr,
+			host: host,
+			desc: p.describe(addr, host, srv, true),
 		})
 
-		return true, nil
+		return nil
 	}
 
 	// Lookup the hostname in DNS
-	ips, err := net.LookupIP(host)
+	ips, err := lookupIP(host)
 	if err != nil {
-		return false, fmt.Errorf("DNS lookup failure \"%s\": %s", host, err)
+		return fmt.Errorf("DNS lookup failure \"%s\": %s", host, err)
 	} else if len(ips) == 0 {
-		return false, fmt.Errorf("DNS lookup failure \"%s\": No addresses found", host)
+		return fmt.Errorf("DNS lookup failure \"%s\": No addresses found", host)
 	}
 
 	for _, ip := range ips {
-		p.addresses = append(p.addresses, &net.TCPAddr{
+		addr := &net.TCPAddr{
 			IP:   ip,
 			Port: port,
+		}
+
+		p.addresses = append(p.addresses, &poolAddress{
+			addr: addr,
+			host: host,
+			desc: p.describe(addr, host, srv, false),
 		})
 	}
 
-	return false, nil
+	return nil
+}
+
+// describe returns the description of an address for Desc
+func (p *Pool) describe(addr *net.TCPAddr, host string, srv string, hostIsIP bool) string {
+	var desc string
+	if hostIsIP {
+		desc = fmt.Sprintf("%s", addr)
+	} else {
+		desc = fmt.Sprintf("%s (%s)", addr, host)
+	}
+
+	if srv != "" {
+		desc = fmt.Sprintf("%s from %s", desc, srv)
+	}
+
+	return desc
 }
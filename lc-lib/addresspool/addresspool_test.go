@@ -18,6 +18,7 @@
 package addresspool
 
 import (
+  "net"
   "testing"
 )
 
@@ -161,3 +162,96 @@ func TestPoolIsLast(t *testing.T) {
   // Hit 42 servers without hitting last
   t.Error("Address pool IsLast did not return correctly")
 }
+
+func TestPoolIPv6(t *testing.T) {
+  pool := NewPool("[::1]:1234")
+  addr, err := pool.Next()
+
+  if err != nil {
+    t.Error("Address pool did not parse IPv6 correctly: ", err)
+  } else if addr == nil {
+    t.Error("Address pool returned nil addr")
+  } else if pool.Host() != "::1" {
+    t.Error("Address pool did not return correct host: ", pool.Host())
+  } else if pool.Desc() != "[::1]:1234" {
+    t.Error("Address pool did not return correct desc: ", pool.Desc())
+  } else if addr.String() != "[::1]:1234" {
+    t.Error("Address pool did not return correct addr: ", addr.String())
+  }
+}
+
+func TestPoolIPv6Unbracketed(t *testing.T) {
+  pool := NewPool("::1:1234")
+  _, err := pool.Next()
+
+  // Should have failed
+  if err == nil {
+    t.Logf("Address pool did not return failure correctly")
+    t.FailNow()
+  }
+}
+
+func TestPoolSrvPrefix(t *testing.T) {
+  defer func() {
+    lookupSRV, lookupIP = net.LookupSRV, net.LookupIP
+  }()
+
+  var lookedUp []string
+  lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+    lookedUp = append(lookedUp, name)
+    return "", []*net.SRV{&net.SRV{Target: "target.example.com.", Port: 5043}}, nil
+  }
+  lookupIP = func(host string) ([]net.IP, error) {
+    lookedUp = append(lookedUp, host)
+    return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+  }
+
+  pool := NewPool("srv:_logstash._tcp.example.com")
+  addr, err := pool.Next()
+
+  if err != nil {
+    t.Error("Address pool did not parse SRV correctly: ", err)
+  } else if addr == nil {
+    t.Error("Address pool returned nil addr")
+  } else if len(lookedUp) != 2 || lookedUp[0] != "_logstash._tcp.example.com" || lookedUp[1] != "target.example.com" {
+    t.Error("Address pool did not look up the SRV record and target: ", lookedUp)
+  } else if pool.Host() != "target.example.com" {
+    t.Error("Address pool did not return the target host: ", pool.Host())
+  } else if pool.Desc() != "127.0.0.1:5043 (target.example.com) from _logstash._tcp.example.com" {
+    t.Error("Address pool did not return correct desc: ", pool.Desc())
+  } else if addr.String() != "127.0.0.1:5043" {
+    t.Error("Address pool did not return correct addr: ", addr.String())
+  }
+}
+
+func TestPoolLookupEveryAttempt(t *testing.T) {
+  defer func() {
+    lookupSRV, lookupIP = net.LookupSRV, net.LookupIP
+  }()
+
+  lookups := 0
+  ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)}
+  lookupIP = func(host string) ([]net.IP, error) {
+    lookups++
+    return ips, nil
+  }
+
+  pool := NewPool("example.com:5043")
+  if addr, err := pool.Next(); err != nil || addr.String() != "127.0.0.1:5043" || pool.IsLast() {
+    t.Fatal("Address pool did not return the first address: ", addr, err)
+  }
+
+  // A change to the records is picked up by the very next attempt
+  ips = []net.IP{net.IPv4(127, 0, 0, 3), net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)}
+  if addr, err := pool.Next(); err != nil || addr.String() != "127.0.0.2:5043" || !pool.IsLast() {
+    t.Fatal("Address pool did not continue from the previous address: ", addr, err)
+  }
+
+  if addr, err := pool.Next(); err != nil || addr.String() != "127.0.0.3:5043" {
+    t.Fatal("Address pool did not return the new address: ", addr, err)
+  }
+
+  if lookups != 3 {
+    t.Error("Address pool did not look up the server on every attempt: ", lookups)
+  }
+}
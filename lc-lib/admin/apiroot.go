@@ -24,7 +24,8 @@ import (
 
 type apiRoot struct {
 	APINode
-	debug APINavigatable
+	debug   APINavigatable
+	metrics *apiMetrics
 }
 
 func (r *apiRoot) Get(path string) (APINavigatable, error) {
@@ -33,12 +34,18 @@ func (r *apiRoot) Get(path string) (APINavigatable, error) {
 		return r.debug, nil
 	}
 
+	// Metrics are rendered separately and not part of the status tree
+	if path == "metrics" {
+		return r.metrics, nil
+	}
+
 	return r.APINode.Get(path)
 }
 
-func newAPIRoot(reloadFunc func() error) *apiRoot {
+func newAPIRoot(host string, reloadFunc func() error) *apiRoot {
 	root := &apiRoot{
-		debug: NewAPIDataEntry(&apiDebug{}),
+		debug:   NewAPIDataEntry(&apiDebug{}),
+		metrics: newAPIMetrics(host),
 	}
 
 	root.SetEntry("version", NewAPIDataEntry(APIString(core.LogCourierVersion)))
@@ -61,6 +61,12 @@ func (c *Config) SetEntry(path string, entry APINavigatable) {
 	c.apiRoot.(*apiRoot).SetEntry(path, entry)
 }
 
+// SetMetrics sets a source of metrics for the metrics endpoint, replacing any
+// existing source with the same name
+func (c *Config) SetMetrics(name string, source MetricsFunc) {
+	c.apiRoot.(*apiRoot).metrics.setSource(name, source)
+}
+
 func init() {
 	config.RegisterConfigSection("admin", func() config.Section {
 		c := &Config{}
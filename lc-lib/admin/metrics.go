@@ -0,0 +1,235 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricType is the Prometheus type of a metric family
+type MetricType string
+
+const (
+	// MetricCounter is a value that only ever increases
+	MetricCounter MetricType = "counter"
+
+	// MetricGauge is a value that can increase and decrease
+	MetricGauge MetricType = "gauge"
+)
+
+// MetricsFunc is called for each metrics request and should add the current
+// values of its metrics to the given collection
+type MetricsFunc func(m *Metrics)
+
+type metricSample struct {
+	labels []string
+	value  float64
+}
+
+type metricFamily struct {
+	help       string
+	metricType MetricType
+	samples    []metricSample
+}
+
+// Metrics is a collection of metric families gathered during a single metrics
+// request, which renders in the Prometheus text exposition format
+type Metrics struct {
+	host     string
+	families map[string]*metricFamily
+}
+
+func newMetrics(host string) *Metrics {
+	return &Metrics{
+		host:     host,
+		families: make(map[string]*metricFamily),
+	}
+}
+
+// Counter adds a sample to a counter metric family
+// Labels are given as pairs of label name and value
+func (m *Metrics) Counter(name string, help string, value float64, labels ...string) {
+	m.add(name, help, MetricCounter, value, labels)
+}
+
+// Gauge adds a sample to a gauge metric family
+// Labels are given as pairs of label name and value
+func (m *Metrics) Gauge(name string, help string, value float64, labels ...string) {
+	m.add(name, help, MetricGauge, value, labels)
+}
+
+func (m *Metrics) add(name string, help string, metricType MetricType, value float64, labels []string) {
+	family, ok := m.families[name]
+	if !ok {
+		family = &metricFamily{help: help, metricType: metricType}
+		m.families[name] = family
+	}
+
+	family.samples = append(family.samples, metricSample{
+		labels: append([]string{"host", m.host}, labels...),
+		value:  value,
+	})
+}
+
+// Bytes returns the metrics in the Prometheus text exposition format
+func (m *Metrics) Bytes() []byte {
+	var result bytes.Buffer
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := m.families[name]
+
+		result.WriteString("# HELP ")
+		result.WriteString(name)
+		result.WriteString(" ")
+		result.WriteString(escapeMetricHelp(family.help))
+		result.WriteString("\n# TYPE ")
+		result.WriteString(name)
+		result.WriteString(" ")
+		result.WriteString(string(family.metricType))
+		result.WriteString("\n")
+
+		for _, sample := range family.samples {
+			result.WriteString(name)
+			result.WriteString("{")
+			for i := 0; i+1 < len(sample.labels); i += 2 {
+				if i != 0 {
+					result.WriteString(",")
+				}
+				result.WriteString(sample.labels[i])
+				result.WriteString("=\"")
+				result.WriteString(escapeMetricLabel(sample.labels[i+1]))
+				result.WriteString("\"")
+			}
+			result.WriteString("} ")
+			result.WriteString(formatMetricValue(sample.value))
+			result.WriteString("\n")
+		}
+	}
+
+	return result.Bytes()
+}
+
+// escapeMetricHelp escapes a HELP string for the text exposition format
+func escapeMetricHelp(help string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(help)
+}
+
+// escapeMetricLabel escapes a label value for the text exposition format
+func escapeMetricLabel(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
+
+// formatMetricValue formats a sample value, using the special values the text
+// exposition format expects for infinity and NaN
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// apiMetrics is an entry that gathers metrics from all registered sources
+type apiMetrics struct {
+	mutex   sync.RWMutex
+	host    string
+	sources map[string]MetricsFunc
+}
+
+func newAPIMetrics(host string) *apiMetrics {
+	return &apiMetrics{
+		host:    host,
+		sources: make(map[string]MetricsFunc),
+	}
+}
+
+// setHost updates the host label attached to all metrics
+func (a *apiMetrics) setHost(host string) {
+	a.mutex.Lock()
+	a.host = host
+	a.mutex.Unlock()
+}
+
+// setSource registers a source of metrics with the given name, replacing any
+// existing source with the same name
+func (a *apiMetrics) setSource(name string, source MetricsFunc) {
+	a.mutex.Lock()
+	a.sources[name] = source
+	a.mutex.Unlock()
+}
+
+// Gather calls all registered sources and returns the metrics they provided
+func (a *apiMetrics) Gather() *Metrics {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	metrics := newMetrics(a.host)
+
+	names := make([]string, 0, len(a.sources))
+	for name := range a.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		a.sources[name](metrics)
+	}
+
+	return metrics
+}
+
+// MarshalJSON returns the metrics in JSON form
+func (a *apiMetrics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(a.Gather().Bytes()))
+}
+
+// HumanReadable returns the metrics in the Prometheus text exposition format
+func (a *apiMetrics) HumanReadable(indent string) ([]byte, error) {
+	return a.Gather().Bytes(), nil
+}
+
+// Get always returns nil for apiMetrics
+func (a *apiMetrics) Get(path string) (APINavigatable, error) {
+	return nil, nil
+}
+
+// Call always returns ErrNotImplemented for apiMetrics
+func (a *apiMetrics) Call(url.Values) (string, error) {
+	return "", ErrNotImplemented
+}
+
+// Update does nothing for apiMetrics as metrics are gathered on demand
+func (a *apiMetrics) Update() error {
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package admin
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMetricsBytes(t *testing.T) {
+	source := newAPIMetrics("test\"host")
+	source.setSource("b", func(m *Metrics) {
+		m.Counter("test_events_total", "Events\nprocessed", 10, "network", "0")
+		m.Counter("test_events_total", "Events\nprocessed", 2.5, "network", "1")
+	})
+	source.setSource("a", func(m *Metrics) {
+		m.Gauge("test_age_seconds", "Age", math.NaN())
+	})
+
+	expected := "# HELP test_age_seconds Age\n" +
+		"# TYPE test_age_seconds gauge\n" +
+		"test_age_seconds{host=\"test\\\"host\"} NaN\n" +
+		"# HELP test_events_total Events\\nprocessed\n" +
+		"# TYPE test_events_total counter\n" +
+		"test_events_total{host=\"test\\\"host\",network=\"0\"} 10\n" +
+		"test_events_total{host=\"test\\\"host\",network=\"1\"} 2.5\n"
+
+	if result := string(source.Gather().Bytes()); result != expected {
+		t.Fatalf("Unexpected metrics output:\n%s", result)
+	}
+}
+
+func TestMetricsHost(t *testing.T) {
+	source := newAPIMetrics("old")
+	source.setSource("a", func(m *Metrics) {
+		m.Gauge("test_value", "Value", 1)
+	})
+	source.setHost("new")
+
+	expected := "# HELP test_value Value\n" +
+		"# TYPE test_value gauge\n" +
+		"test_value{host=\"new\"} 1\n"
+
+	if result := string(source.Gather().Bytes()); result != expected {
+		t.Fatalf("Unexpected metrics output:\n%s", result)
+	}
+}
@@ -45,7 +45,7 @@ func NewServer(pipeline *core.Pipeline, config *config.Config, reloadFunc func()
 		config: config.Get("admin").(*Config),
 	}
 
-	ret.config.apiRoot = newAPIRoot(reloadFunc)
+	ret.config.apiRoot = newAPIRoot(config.General.Host, reloadFunc)
 
 	listener, err := ret.listen(ret.config)
 	if err != nil {
@@ -115,6 +115,8 @@ ListenerLoop:
 				shutdownStarted = true
 			}
 		case config := <-l.OnConfig():
+			l.config.apiRoot.(*apiRoot).metrics.setHost(config.General.Host)
+
 			// We can't yet disable admin during a reload
 			aconfig := config.Get("admin").(*Config)
 			if aconfig.Enabled {
@@ -228,7 +230,10 @@ func (l *Server) handleRequest(w http.ResponseWriter, r *http.Request, root APIN
 	var contentType string
 	var response []byte
 
-	if r.URL.Query().Get("w") == "pretty" {
+	if metrics, ok := root.(*apiMetrics); ok {
+		contentType = "text/plain; version=0.0.4"
+		response = metrics.Gather().Bytes()
+	} else if r.URL.Query().Get("w") == "pretty" {
 		contentType = "text/plain"
 		response, err = root.HumanReadable("")
 	} else {
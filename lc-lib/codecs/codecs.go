@@ -18,13 +18,14 @@ package codecs
 
 import (
 	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 // Codec is the generic interface that all codecs implement
 type Codec interface {
 	Teardown() int64
 	Reset()
-	Event(int64, int64, string)
+	Event(int64, int64, core.Event)
 	Meter()
 	APIEncodable() admin.APIEncodable
 }
@@ -32,7 +33,15 @@ type Codec interface {
 // CallbackFunc is a callback function that a codec will call for each of its
 // "output" events. It could be called at any time by any routine (not
 // necessarily the routine providing the "input" events.)
-type CallbackFunc func(int64, int64, string)
+// The event always contains the line data in its "message" field unless a
+// codec, such as the json codec, has replaced it, in which case the codec sets
+// MessageReplacedKey
+type CallbackFunc func(int64, int64, core.Event)
+
+// MessageReplacedKey is set in an event by a codec that replaces the line data,
+// so the harvester knows any "message" field is not its own. The harvester
+// removes it before the event is shipped
+const MessageReplacedKey = "@message_replaced"
 
 // codecFactory is the interface that all codec factories implement. The codec
 // factory should store the codec's configuration and, when NewCodec is called,
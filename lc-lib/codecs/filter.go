@@ -21,12 +21,14 @@ import (
 
 	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 // CodecFilterFactory holds the configuration for a filter codec
 type CodecFilterFactory struct {
 	Patterns []string `config:"patterns"`
 	Match    string   `config:"match"`
+	Negate   bool     `config:"negate"`
 
 	patterns        PatternCollection
 	requiredMatches int
@@ -82,16 +84,18 @@ func (c *CodecFilter) Teardown() int64 {
 // Reset restores the codec to a blank state so it can be reused on a new file
 // stream
 func (c *CodecFilter) Reset() {
+	c.lastOffset = 0
 }
 
 // Event is called by a Harvester when a new line event occurs on a file.
 // Filtering takes place and only accepted lines are shipped to the callback
-func (c *CodecFilter) Event(startOffset int64, endOffset int64, text string) {
-	// Only flush the event if it matches
-	matched := c.config.patterns.Match(text)
+func (c *CodecFilter) Event(startOffset int64, endOffset int64, event core.Event) {
+	// Only flush the event if it matches, or if it does not match when negated
+	message, _ := event["message"].(string)
+	matched := c.config.patterns.Match(message) != c.config.Negate
 
 	if matched {
-		c.callbackFunc(startOffset, endOffset, text)
+		c.callbackFunc(startOffset, endOffset, event)
 	} else {
 		c.filteredLines++
 	}
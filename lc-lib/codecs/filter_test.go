@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 var filterLines []string
@@ -20,8 +21,8 @@ func createFilterCodec(unused map[string]interface{}, callback CallbackFunc, t *
 	return NewCodec(factory, callback, 0)
 }
 
-func checkFilter(startOffset int64, endOffset int64, text string) {
-	filterLines = append(filterLines, text)
+func checkFilter(startOffset int64, endOffset int64, event core.Event) {
+	filterLines = append(filterLines, event["message"].(string))
 }
 
 func TestFilter(t *testing.T) {
@@ -32,10 +33,10 @@ func TestFilter(t *testing.T) {
 	}, checkFilter, t)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
 
 	if len(filterLines) != 1 {
 		t.Error("Wrong line count received")
@@ -57,10 +58,10 @@ func TestFilterNegate(t *testing.T) {
 	}, checkFilter, t)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
 
 	if len(filterLines) != 3 {
 		t.Error("Wrong line count received")
@@ -86,10 +87,10 @@ func TestFilterMultiple(t *testing.T) {
 	}, checkFilter, t)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
 
 	if len(filterLines) != 2 {
 		t.Error("Wrong line count received")
@@ -114,10 +115,10 @@ func TestFilterMultipleAll(t *testing.T) {
 	}, checkFilter, t)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line DEBUG another line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line DEBUG another line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
 
 	if len(filterLines) != 1 {
 		t.Error("Wrong line count received")
@@ -130,3 +131,42 @@ func TestFilterMultipleAll(t *testing.T) {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
+
+func TestFilterNegateOption(t *testing.T) {
+	filterLines = make([]string, 0, 1)
+
+	codec := createFilterCodec(map[string]interface{}{
+		"patterns": []string{"^DEBUG "},
+		"negate":   true,
+	}, checkFilter, t)
+
+	// Send some data
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
+
+	if len(filterLines) != 2 {
+		t.Error("Wrong line count received")
+	} else if filterLines[0] != "NEXT line" {
+		t.Errorf("Wrong line[0] received: %s", filterLines[0])
+	} else if filterLines[1] != "ANOTHER line" {
+		t.Errorf("Wrong line[1] received: %s", filterLines[1])
+	}
+
+	offset := codec.Teardown()
+	if offset != 7 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestFilterInvalidPattern(t *testing.T) {
+	config := config.NewConfig()
+
+	_, err := NewFilterCodecFactory(config, "", map[string]interface{}{
+		"patterns": []string{"^DEBUG ("},
+	}, "filter")
+	if err == nil {
+		t.Error("Invalid pattern did not cause an error")
+	}
+}
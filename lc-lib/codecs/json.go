@@ -0,0 +1,153 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	// codecJSONParseFailureTag is added to events that could not be decoded
+	codecJSONParseFailureTag = "_jsonparsefailure"
+)
+
+// CodecJSONFactory holds the configuration, it is responsible for generating
+// instances as required when new log files are opened
+type CodecJSONFactory struct {
+}
+
+// CodecJSON is an instance of the json codec, in use by a single harvester
+type CodecJSON struct {
+	lastOffset   int64
+	callbackFunc CallbackFunc
+	failedLines  uint64
+	meterFailed  uint64
+}
+
+// NewJSONCodecFactory creates a new factory structure from the configuration
+// data in the configuration file.
+func NewJSONCodecFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string) (interface{}, error) {
+	if err := config.ReportUnusedConfig(unUsed, configPath); err != nil {
+		return nil, err
+	}
+	return &CodecJSONFactory{}, nil
+}
+
+// NewCodec creates a new codec instance starting at the given offset
+func (f *CodecJSONFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return &CodecJSON{
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+	}
+}
+
+// Teardown shuts down the codec and returns the last offset sent
+func (c *CodecJSON) Teardown() int64 {
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecJSON) Reset() {
+	c.lastOffset = 0
+}
+
+// Event is called for every log event. The message is decoded as a JSON object
+// and its keys merged into the event in place of the message. If the message is
+// not a valid JSON object it is left as-is and the event is tagged
+func (c *CodecJSON) Event(startOffset int64, endOffset int64, event core.Event) {
+	c.lastOffset = endOffset
+
+	message, ok := event["message"].(string)
+	if !ok {
+		c.callbackFunc(startOffset, endOffset, event)
+		return
+	}
+
+	decoded, err := c.decode(message)
+	if err != nil {
+		c.failedLines++
+		event.AddTag(codecJSONParseFailureTag)
+		c.callbackFunc(startOffset, endOffset, event)
+		return
+	}
+
+	delete(event, "message")
+	event[MessageReplacedKey] = true
+	for k, v := range decoded {
+		if k == "tags" {
+			// Merge any tags already added to the event
+			if existing, ok := event["tags"]; ok {
+				event["tags"] = v
+				event.MergeTags(existing)
+				continue
+			}
+		}
+
+		event[k] = v
+	}
+
+	c.callbackFunc(startOffset, endOffset, event)
+}
+
+// decode decodes a message that should contain a single JSON object
+// Numbers are decoded as json.Number so that large integers remain intact
+func (c *CodecJSON) decode(message string) (map[string]interface{}, error) {
+	var decoded map[string]interface{}
+
+	decoder := json.NewDecoder(strings.NewReader(message))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+
+	if decoded == nil {
+		return nil, errors.New("not a JSON object")
+	}
+
+	// Ensure there is nothing else following the object
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON object")
+	}
+
+	return decoded, nil
+}
+
+// Meter is called by the harvester periodically to allow the codec to calculate
+// statistics if necessary
+func (c *CodecJSON) Meter() {
+	c.meterFailed = c.failedLines
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecJSON) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("failed_lines", admin.APINumber(c.meterFailed))
+	return api
+}
+
+// Register the codec with Log Courier
+func init() {
+	config.RegisterCodec("json", NewJSONCodecFactory)
+}
@@ -0,0 +1,122 @@
+package codecs
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+var jsonEvents []core.Event
+
+func createJSONCodec(t *testing.T) Codec {
+	config := config.NewConfig()
+
+	factory, err := NewJSONCodecFactory(config, "", map[string]interface{}{}, "json")
+	if err != nil {
+		t.Logf("Failed to create json codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, checkJSON, 0)
+}
+
+func checkJSON(startOffset int64, endOffset int64, event core.Event) {
+	jsonEvents = append(jsonEvents, event)
+}
+
+func hasTag(event core.Event, tag string) bool {
+	switch tags := event["tags"].(type) {
+	case []string:
+		for _, v := range tags {
+			if v == tag {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range tags {
+			if v == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestJSON(t *testing.T) {
+	jsonEvents = nil
+
+	codec := createJSONCodec(t)
+
+	codec.Event(0, 1, core.Event{"message": `{"level": "info", "message": "Test", "request": {"id": 12345678901234567890}}`})
+
+	if len(jsonEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(jsonEvents))
+	}
+
+	event := jsonEvents[0]
+	if event["level"] != "info" {
+		t.Errorf("Wrong level received: %v", event["level"])
+	}
+	if event["message"] != "Test" {
+		t.Errorf("Wrong message received: %v", event["message"])
+	}
+
+	request, ok := event["request"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Nested object was not preserved: %v", event["request"])
+	}
+	if request["id"] != json.Number("12345678901234567890") {
+		t.Errorf("Wrong nested value received: %v", request["id"])
+	}
+
+	if hasTag(event, codecJSONParseFailureTag) {
+		t.Error("Parse failure tag set on valid JSON")
+	}
+
+	offset := codec.Teardown()
+	if offset != 1 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestJSONParseFailure(t *testing.T) {
+	jsonEvents = nil
+
+	codec := createJSONCodec(t)
+
+	codec.Event(0, 1, core.Event{"message": `{"level": "info"`})
+	codec.Event(2, 3, core.Event{"message": `["not", "an", "object"]`})
+	codec.Event(4, 5, core.Event{"message": `{"level": "info"} trailing`})
+	codec.Event(6, 7, core.Event{"message": `null`})
+
+	if len(jsonEvents) != 4 {
+		t.Fatalf("Wrong event count received: %d", len(jsonEvents))
+	}
+
+	for i, event := range jsonEvents {
+		if _, ok := event["message"].(string); !ok {
+			t.Errorf("Raw message not retained for event %d", i)
+		}
+		if !hasTag(event, codecJSONParseFailureTag) {
+			t.Errorf("Parse failure tag missing for event %d", i)
+		}
+	}
+}
+
+func TestJSONTags(t *testing.T) {
+	jsonEvents = nil
+
+	codec := createJSONCodec(t)
+
+	codec.Event(0, 1, core.Event{"message": `{"tags": ["app"]}`, "tags": []string{"previous"}})
+
+	if len(jsonEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(jsonEvents))
+	}
+
+	if !hasTag(jsonEvents[0], "app") || !hasTag(jsonEvents[0], "previous") {
+		t.Errorf("Tags were not merged: %v", jsonEvents[0]["tags"])
+	}
+}
@@ -17,6 +17,7 @@
 package codecs
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -24,6 +25,7 @@ import (
 
 	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 const (
@@ -35,12 +37,16 @@ const (
 type CodecMultilineFactory struct {
 	Patterns          []string      `config:"patterns"`
 	Match             string        `config:"match"`
+	PreviousPatterns  []string      `config:"previous patterns"`
+	PreviousMatch     string        `config:"previous match"`
 	What              string        `config:"what"`
 	PreviousTimeout   time.Duration `config:"previous timeout"`
+	MaxWait           time.Duration `config:"max wait"`
 	MaxMultilineBytes int64         `config:"max multiline bytes"`
 
-	patterns PatternCollection
-	what     int
+	patterns         PatternCollection
+	previousPatterns PatternCollection
+	what             int
 }
 
 // CodecMultiline is an instance of a multiline codec that is used by the
@@ -50,15 +56,17 @@ type CodecMultiline struct {
 	lastOffset   int64
 	callbackFunc CallbackFunc
 
-	endOffset     int64
-	startOffset   int64
-	buffer        []string
-	bufferLines   int64
-	bufferLen     int64
-	timerLock     sync.Mutex
-	timerStop     chan interface{}
-	timerWait     sync.WaitGroup
-	timerDeadline time.Time
+	endOffset    int64
+	startOffset  int64
+	buffer       []string
+	bufferEvent  core.Event
+	bufferLines  int64
+	bufferLen    int64
+	bufferStart  time.Time
+	timerLock    sync.Mutex
+	timerStop    chan interface{}
+	timerWait    sync.WaitGroup
+	lastLineTime time.Time
 
 	meterLines int64
 	meterBytes int64
@@ -75,10 +83,6 @@ func NewMultilineCodecFactory(config *config.Config, configPath string, unused m
 		return nil, err
 	}
 
-	if err = result.patterns.Set(result.Patterns, result.Match); err != nil {
-		return nil, err
-	}
-
 	if result.What == "" || result.What == "previous" {
 		result.what = codecMultilineWhatPrevious
 	} else if result.What == "next" {
@@ -87,6 +91,24 @@ func NewMultilineCodecFactory(config *config.Config, configPath string, unused m
 		return nil, fmt.Errorf("Unknown \"what\" value for multiline codec, '%s'.", result.What)
 	}
 
+	// Patterns may be omitted when previous patterns alone decide whether a line
+	// belongs to the buffered event
+	if len(result.Patterns) != 0 || len(result.PreviousPatterns) == 0 {
+		if err = result.patterns.Set(result.Patterns, result.Match); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(result.PreviousPatterns) != 0 {
+		if result.what != codecMultilineWhatPrevious {
+			return nil, errors.New("\"previous patterns\" can only be used for multiline codec when \"what\" is \"previous\".")
+		}
+
+		if err = result.previousPatterns.Set(result.PreviousPatterns, result.PreviousMatch); err != nil {
+			return nil, err
+		}
+	}
+
 	if result.MaxMultilineBytes == 0 {
 		result.MaxMultilineBytes = config.General.SpoolMaxBytes
 	}
@@ -110,33 +132,43 @@ func (f *CodecMultilineFactory) NewCodec(callbackFunc CallbackFunc, offset int64
 		callbackFunc: callbackFunc,
 	}
 
-	// Start the "previous timeout" routine that will auto flush at deadline
-	if f.PreviousTimeout != 0 {
+	// Start the "previous timeout" and "max wait" routine that will auto flush
+	// at deadline
+	if (f.what == codecMultilineWhatPrevious && f.PreviousTimeout != 0) || f.MaxWait != 0 {
 		c.timerStop = make(chan interface{})
 		c.timerWait.Add(1)
 
-		c.timerDeadline = time.Now().Add(f.PreviousTimeout)
-
 		go c.deadlineRoutine()
 	}
 	return c
 }
 
-// Teardown ends the codec and returns the last offset shipped to the callback
+// Teardown ends the codec, flushing any buffered lines as a final event, and
+// returns the last offset shipped to the callback
 func (c *CodecMultiline) Teardown() int64 {
-	if c.config.PreviousTimeout != 0 {
+	if c.timerStop != nil {
 		close(c.timerStop)
 		c.timerWait.Wait()
 	}
 
+	c.flush()
+
 	return c.lastOffset
 }
 
 // Reset restores the codec to a blank state so it can be reused on a new file
 // stream
 func (c *CodecMultiline) Reset() {
+	if c.timerStop != nil {
+		c.timerLock.Lock()
+		defer c.timerLock.Unlock()
+	}
+
 	c.lastOffset = 0
+	c.startOffset = 0
+	c.endOffset = 0
 	c.buffer = nil
+	c.bufferEvent = nil
 	c.bufferLen = 0
 	c.bufferLines = 0
 }
@@ -144,7 +176,7 @@ func (c *CodecMultiline) Reset() {
 // Event is called by a Harvester when a new line event occurs on a file.
 // Multiline processing takes place and when a complete multiline event is found
 // as described by the configuration it is shipped to the callback
-func (c *CodecMultiline) Event(startOffset int64, endOffset int64, text string) {
+func (c *CodecMultiline) Event(startOffset int64, endOffset int64, event core.Event) {
 	// TODO(driskell): If we are using previous and we match on the very first line read,
 	// then this is because we've started in the middle of a multiline event (the first line
 	// should never match) - so we could potentially offer an option to discard this.
@@ -153,22 +185,26 @@ func (c *CodecMultiline) Event(startOffset int64, endOffset int64, text string)
 	// odd incomplete data. It would be a signal from the user, "I will worry about the buffering
 	// issues my programs may have - you just make sure to write each event either completely or
 	// partially, always with the FIRST line correct (which could be the important one)."
-	matched := c.config.patterns.Match(text)
+	text, _ := event["message"].(string)
 
-	if c.config.what == codecMultilineWhatPrevious {
-		if c.config.PreviousTimeout != 0 {
-			// Prevent a flush happening while we're modifying the stored data
-			c.timerLock.Lock()
-		}
-		if !matched {
-			c.flush()
-		}
+	if c.timerStop != nil {
+		// Prevent a flush happening while we're modifying the stored data
+		c.timerLock.Lock()
+		defer c.timerLock.Unlock()
+	}
+
+	matched := c.match(text)
+
+	if c.config.what == codecMultilineWhatPrevious && !matched {
+		c.flush()
 	}
 
 	textLen := int64(len(text))
 
 	if len(c.buffer) == 0 {
 		c.startOffset = startOffset
+		c.bufferEvent = event
+		c.bufferStart = time.Now()
 	}
 
 	// Check we don't exceed the max multiline bytes
@@ -188,6 +224,8 @@ func (c *CodecMultiline) Event(startOffset int64, endOffset int64, text string)
 
 		// Append the remaining data to the buffer
 		c.startOffset = c.endOffset
+		c.bufferEvent = event
+		c.bufferStart = time.Now()
 		text = text[cut:]
 		textLen -= cut
 
@@ -201,17 +239,32 @@ func (c *CodecMultiline) Event(startOffset int64, endOffset int64, text string)
 	c.bufferLines++
 	c.bufferLen += textLen
 
-	if c.config.what == codecMultilineWhatPrevious {
-		if c.config.PreviousTimeout != 0 {
-			// Reset the timer and unlock
-			c.timerDeadline = time.Now().Add(c.config.PreviousTimeout)
-			c.timerLock.Unlock()
-		}
-	} else if c.config.what == codecMultilineWhatNext && !matched {
+	c.lastLineTime = time.Now()
+
+	if c.config.what == codecMultilineWhatNext && !matched {
 		c.flush()
 	}
 }
 
+// match returns true if the line matches the patterns, meaning it belongs with
+// the previous line if "what" is "previous", or the next line if "next"
+// When previous patterns are set the previously buffered line must also match
+// them, so with an empty buffer there is nothing for the line to belong to
+func (c *CodecMultiline) match(text string) bool {
+	if len(c.config.Patterns) != 0 && !c.config.patterns.Match(text) {
+		return false
+	}
+
+	if len(c.config.PreviousPatterns) != 0 {
+		if len(c.buffer) == 0 {
+			return false
+		}
+		return c.config.previousPatterns.Match(c.buffer[len(c.buffer)-1])
+	}
+
+	return true
+}
+
 // flush is called internally when a multiline event is ready.
 // It combines the lines collected and passes the new event to the callback
 func (c *CodecMultiline) flush() {
@@ -219,15 +272,22 @@ func (c *CodecMultiline) flush() {
 		return
 	}
 
-	text := strings.Join(c.buffer, "\n")
+	// The combined event takes its fields from the first line in the buffer,
+	// copied as the same line event may be split across multiple flushes
+	event := make(core.Event, len(c.bufferEvent))
+	for k, v := range c.bufferEvent {
+		event[k] = v
+	}
+	event["message"] = strings.Join(c.buffer, "\n")
 
 	// Set last offset - this is returned in Teardown so if we're mid multiline and crash, we start this multiline again
 	c.lastOffset = c.endOffset
 	c.buffer = nil
+	c.bufferEvent = nil
 	c.bufferLen = 0
 	c.bufferLines = 0
 
-	c.callbackFunc(c.startOffset, c.endOffset, text)
+	c.callbackFunc(c.startOffset, c.endOffset, event)
 }
 
 // Meter is called by the Harvester to request accounting
@@ -244,8 +304,43 @@ func (c *CodecMultiline) APIEncodable() admin.APIEncodable {
 	return api
 }
 
+// flushDeadline returns the time at which the buffered lines should be
+// flushed, which is the earliest of the "previous timeout" and "max wait"
+// deadlines that apply
+func (c *CodecMultiline) flushDeadline() time.Time {
+	var deadline time.Time
+
+	if c.config.what == codecMultilineWhatPrevious && c.config.PreviousTimeout != 0 {
+		deadline = c.lastLineTime.Add(c.config.PreviousTimeout)
+	}
+
+	if c.config.MaxWait != 0 {
+		maxWaitDeadline := c.bufferStart.Add(c.config.MaxWait)
+		if deadline.IsZero() || maxWaitDeadline.Before(deadline) {
+			deadline = maxWaitDeadline
+		}
+	}
+
+	return deadline
+}
+
+// idleInterval returns how long to wait before checking again when there are
+// no buffered lines. A deadline can never be sooner than this after a new line
+// is received, so this ensures a deadline is never missed
+func (c *CodecMultiline) idleInterval() time.Duration {
+	interval := c.config.MaxWait
+
+	if c.config.what == codecMultilineWhatPrevious && c.config.PreviousTimeout != 0 {
+		if interval == 0 || c.config.PreviousTimeout < interval {
+			interval = c.config.PreviousTimeout
+		}
+	}
+
+	return interval
+}
+
 func (c *CodecMultiline) deadlineRoutine() {
-	timer := time.NewTimer(0)
+	timer := time.NewTimer(c.idleInterval())
 
 DeadlineLoop:
 	for {
@@ -258,16 +353,20 @@ DeadlineLoop:
 		case now := <-timer.C:
 			c.timerLock.Lock()
 
-			// Have we reached the target time?
-			if !now.After(c.timerDeadline) {
-				// Deadline moved, update the timer
-				timer.Reset(c.timerDeadline.Sub(now))
-				c.timerLock.Unlock()
-				continue
+			if len(c.buffer) != 0 {
+				// Have we reached the target time?
+				deadline := c.flushDeadline()
+				if now.Before(deadline) {
+					// Deadline moved, update the timer
+					timer.Reset(deadline.Sub(now))
+					c.timerLock.Unlock()
+					continue
+				}
+
+				c.flush()
 			}
 
-			c.flush()
-			timer.Reset(c.config.PreviousTimeout)
+			timer.Reset(c.idleInterval())
 			c.timerLock.Unlock()
 		}
 	}
@@ -7,6 +7,7 @@ import (
 	"unicode"
 
 	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 func createMultilineCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
@@ -54,7 +55,7 @@ func (c *checkMultiline) incorrectLineCount(lines int, message string) {
 	c.t.Errorf("Expected: %d", len(c.expect))
 }
 
-func (c *checkMultiline) EventCallback(startOffset int64, endOffset int64, text string) {
+func (c *checkMultiline) EventCallback(startOffset int64, endOffset int64, event core.Event) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -77,6 +78,7 @@ func (c *checkMultiline) EventCallback(startOffset int64, endOffset int64, text
 		c.t.Errorf("Expected: %d", c.expect[c.lines].end)
 	}
 
+	text := event["message"].(string)
 	if text != c.expect[c.lines].text {
 		c.t.Error("Text incorrect for line: ", line)
 		c.t.Errorf("Got:      [%s]", c.formatPrintable(text))
@@ -103,6 +105,7 @@ func TestMultilinePrevious(t *testing.T) {
 	check := &checkMultiline{
 		expect: []checkMultilineExpect{
 			{0, 5, "DEBUG First line\nNEXT line\nANOTHER line"},
+			{6, 7, "DEBUG Next line"},
 		},
 		t: t,
 	}
@@ -117,14 +120,18 @@ func TestMultilinePrevious(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
+
+	check.CheckCurrentCount(1, "Incorrect line count received before teardown")
+
+	offset := codec.Teardown()
 
 	check.CheckFinalCount()
 
-	if offset := codec.Teardown(); offset != 5 {
+	if offset != 7 {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
@@ -133,6 +140,7 @@ func TestMultilinePreviousNegate(t *testing.T) {
 	check := &checkMultiline{
 		expect: []checkMultilineExpect{
 			{0, 5, "DEBUG First line\nNEXT line\nANOTHER line"},
+			{6, 7, "DEBUG Next line"},
 		},
 		t: t,
 	}
@@ -147,14 +155,18 @@ func TestMultilinePreviousNegate(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
+
+	check.CheckCurrentCount(1, "Incorrect line count received before teardown")
+
+	offset := codec.Teardown()
 
 	check.CheckFinalCount()
 
-	if offset := codec.Teardown(); offset != 5 {
+	if offset != 7 {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
@@ -179,10 +191,10 @@ func TestMultilinePreviousTimeout(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
 
 	// Allow a second
 	time.Sleep(time.Second)
@@ -200,10 +212,56 @@ func TestMultilinePreviousTimeout(t *testing.T) {
 	}
 }
 
+func TestMultilineMaxWait(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 5, "DEBUG First line\nNEXT line\nANOTHER line"},
+			{6, 7, "DEBUG Next line"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"patterns": []string{"^(DEBUG|NEXT|ANOTHER) "},
+			"what":     "next",
+			"max wait": "2s",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// Send some data, every line continues into the next
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+
+	// Allow a second
+	time.Sleep(time.Second)
+
+	check.CheckCurrentCount(0, "Max wait triggered too early")
+
+	// Allow 2 more seconds
+	time.Sleep(2 * time.Second)
+
+	check.CheckCurrentCount(1, "Max wait did not trigger")
+
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
+
+	offset := codec.Teardown()
+
+	check.CheckFinalCount()
+
+	if offset != 7 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
 func TestMultilineNext(t *testing.T) {
 	check := &checkMultiline{
 		expect: []checkMultilineExpect{
 			{0, 5, "DEBUG First line\nNEXT line\nANOTHER line"},
+			{6, 7, "DEBUG Next line"},
 		},
 		t: t,
 	}
@@ -218,15 +276,18 @@ func TestMultilineNext(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
 
-	check.CheckFinalCount()
+	check.CheckCurrentCount(1, "Incorrect line count received before teardown")
 
 	offset := codec.Teardown()
-	if offset != 5 {
+
+	check.CheckFinalCount()
+
+	if offset != 7 {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
@@ -235,6 +296,7 @@ func TestMultilineNextNegate(t *testing.T) {
 	check := &checkMultiline{
 		expect: []checkMultilineExpect{
 			{0, 5, "DEBUG First line\nNEXT line\nANOTHER line"},
+			{6, 7, "DEBUG Next line"},
 		},
 		t: t,
 	}
@@ -249,15 +311,18 @@ func TestMultilineNextNegate(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
 
-	check.CheckFinalCount()
+	check.CheckCurrentCount(1, "Incorrect line count received before teardown")
 
 	offset := codec.Teardown()
-	if offset != 5 {
+
+	check.CheckFinalCount()
+
+	if offset != 7 {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
@@ -267,6 +332,7 @@ func TestMultilineMaxBytes(t *testing.T) {
 		expect: []checkMultilineExpect{
 			{0, 32, "DEBUG First line\nsecond line\nthi"},
 			{32, 39, "rd line"},
+			{40, 55, "DEBUG Next line"},
 		},
 		t: t,
 	}
@@ -281,15 +347,18 @@ func TestMultilineMaxBytes(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 16, "DEBUG First line")
-	codec.Event(17, 28, "second line")
-	codec.Event(29, 39, "third line")
-	codec.Event(40, 55, "DEBUG Next line")
+	codec.Event(0, 16, core.Event{"message": "DEBUG First line"})
+	codec.Event(17, 28, core.Event{"message": "second line"})
+	codec.Event(29, 39, core.Event{"message": "third line"})
+	codec.Event(40, 55, core.Event{"message": "DEBUG Next line"})
 
-	check.CheckFinalCount()
+	check.CheckCurrentCount(2, "Incorrect line count received before teardown")
 
 	offset := codec.Teardown()
-	if offset != 39 {
+
+	check.CheckFinalCount()
+
+	if offset != 55 {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
@@ -301,6 +370,7 @@ func TestMultilineMaxBytesOverflow(t *testing.T) {
 			{10, 20, "abcdefg\n12"},
 			{20, 30, "34567890ab"},
 			{30, 39, "\nc1234567"},
+			{40, 45, "START"},
 		},
 		t: t,
 	}
@@ -318,15 +388,18 @@ func TestMultilineMaxBytesOverflow(t *testing.T) {
 	// Also ensure we can split a single long line multiple times (issue #188)
 	// Lastly, ensure we flush immediately if we receive max multiline bytes
 	// rather than carrying over a full buffer and then crashing (issue #118)
-	codec.Event(0, 17, "START67890abcdefg")
-	codec.Event(18, 30, "1234567890ab")
-	codec.Event(31, 39, "c1234567")
-	codec.Event(40, 45, "START")
+	codec.Event(0, 17, core.Event{"message": "START67890abcdefg"})
+	codec.Event(18, 30, core.Event{"message": "1234567890ab"})
+	codec.Event(31, 39, core.Event{"message": "c1234567"})
+	codec.Event(40, 45, core.Event{"message": "START"})
 
-	check.CheckFinalCount()
+	check.CheckCurrentCount(4, "Incorrect line count received before teardown")
 
 	offset := codec.Teardown()
-	if offset != 39 {
+
+	check.CheckFinalCount()
+
+	if offset != 45 {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
@@ -335,6 +408,7 @@ func TestMultilineReset(t *testing.T) {
 	check := &checkMultiline{
 		expect: []checkMultilineExpect{
 			{4, 7, "DEBUG Next line\nANOTHER line"},
+			{8, 9, "DEBUG Last line"},
 		},
 		t: t,
 	}
@@ -349,17 +423,20 @@ func TestMultilineReset(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
 	codec.Reset()
-	codec.Event(4, 5, "DEBUG Next line")
-	codec.Event(6, 7, "ANOTHER line")
-	codec.Event(8, 9, "DEBUG Last line")
+	codec.Event(4, 5, core.Event{"message": "DEBUG Next line"})
+	codec.Event(6, 7, core.Event{"message": "ANOTHER line"})
+	codec.Event(8, 9, core.Event{"message": "DEBUG Last line"})
 
-	check.CheckFinalCount()
+	check.CheckCurrentCount(1, "Incorrect line count received before teardown")
 
 	offset := codec.Teardown()
-	if offset != 7 {
+
+	check.CheckFinalCount()
+
+	if offset != 9 {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
@@ -368,6 +445,7 @@ func TestMultilineMultiplePattern(t *testing.T) {
 	check := &checkMultiline{
 		expect: []checkMultilineExpect{
 			{0, 5, "DEBUG First line\nNEXT line\nANOTHER line"},
+			{6, 7, "DEBUG Next line"},
 		},
 		t: t,
 	}
@@ -382,14 +460,18 @@ func TestMultilineMultiplePattern(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
+
+	check.CheckCurrentCount(1, "Incorrect line count received before teardown")
+
+	offset := codec.Teardown()
 
 	check.CheckFinalCount()
 
-	if offset := codec.Teardown(); offset != 5 {
+	if offset != 7 {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
@@ -398,6 +480,7 @@ func TestMultilineMultiplePatternAll(t *testing.T) {
 	check := &checkMultiline{
 		expect: []checkMultilineExpect{
 			{0, 5, "DEBUG First line\nNEXT line\nANOTHER line"},
+			{6, 7, "DEBUG Next line"},
 		},
 		t: t,
 	}
@@ -413,14 +496,115 @@ func TestMultilineMultiplePatternAll(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "NEXT line"})
+	codec.Event(4, 5, core.Event{"message": "ANOTHER line"})
+	codec.Event(6, 7, core.Event{"message": "DEBUG Next line"})
+
+	check.CheckCurrentCount(1, "Incorrect line count received before teardown")
+
+	offset := codec.Teardown()
+
+	check.CheckFinalCount()
+
+	if offset != 7 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestMultilinePreviousPatterns(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 3, "HEADER first\nbody line"},
+			{4, 5, "HEADER second"},
+			{6, 9, "HEADER third\nbody line"},
+			{10, 11, "another line"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"patterns":          []string{"!^HEADER "},
+			"previous patterns": []string{"^HEADER "},
+			"what":              "previous",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// Only a single line after each header belongs with it, and consecutive
+	// headers are separate events
+	codec.Event(0, 1, core.Event{"message": "HEADER first"})
+	codec.Event(2, 3, core.Event{"message": "body line"})
+	codec.Event(4, 5, core.Event{"message": "HEADER second"})
+	codec.Event(6, 7, core.Event{"message": "HEADER third"})
+	codec.Event(8, 9, core.Event{"message": "body line"})
+	codec.Event(10, 11, core.Event{"message": "another line"})
+
+	check.CheckCurrentCount(3, "Incorrect line count received before teardown")
+
+	offset := codec.Teardown()
 
 	check.CheckFinalCount()
 
-	if offset := codec.Teardown(); offset != 5 {
+	if offset != 11 {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
+
+func TestMultilinePreviousPatternsEmptyBuffer(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 1, "body line"},
+			{2, 3, "HEADER first"},
+			{4, 5, "body line"},
+			{6, 7, "HEADER second"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"previous patterns": []string{"^HEADER "},
+			"what":              "previous",
+			"previous timeout":  "100ms",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// With nothing buffered there is no previous line to match, so the line
+	// starts a new event
+	codec.Event(0, 1, core.Event{"message": "body line"})
+	codec.Event(2, 3, core.Event{"message": "HEADER first"})
+
+	check.CheckCurrentCount(1, "Incorrect line count received before timeout")
+
+	// Wait for the previous timeout to flush and empty the buffer, after which
+	// a line that would have belonged with the header starts a new event
+	time.Sleep(time.Second)
+
+	check.CheckCurrentCount(2, "Timeout did not flush")
+
+	codec.Event(4, 5, core.Event{"message": "body line"})
+	codec.Event(6, 7, core.Event{"message": "HEADER second"})
+
+	offset := codec.Teardown()
+
+	check.CheckFinalCount()
+
+	if offset != 7 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestMultilinePreviousPatternsNext(t *testing.T) {
+	_, err := NewMultilineCodecFactory(config.NewConfig(), "", map[string]interface{}{
+		"previous patterns": []string{"^HEADER "},
+		"what":              "next",
+	}, "multiline")
+	if err == nil {
+		t.Error("Factory accepted previous patterns with what of next")
+	}
+}
@@ -19,6 +19,7 @@ package codecs
 import (
 	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 // CodecPlainFactory holds the configuration, it is responsible for generating
@@ -64,14 +65,15 @@ func (c *CodecPlain) Teardown() int64 {
 // Reset is called when a log file is truncated, and it should cause the codec
 // to reset itself as if it was only just created
 func (c *CodecPlain) Reset() {
+	c.lastOffset = 0
 }
 
 // Event is called for every log event, the resulting log event(s) to be
 // transmitted should be passed through the codec callback when ready
-func (c *CodecPlain) Event(startOffset int64, endOffset int64, text string) {
+func (c *CodecPlain) Event(startOffset int64, endOffset int64, event core.Event) {
 	c.lastOffset = endOffset
 
-	c.callbackFunc(startOffset, endOffset, text)
+	c.callbackFunc(startOffset, endOffset, event)
 }
 
 // Meter is called by the harvester periodically to allow the codec to calculate
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// CodecTaggerPattern holds the configuration for a single tagger pattern and
+// the tags to add when it matches
+type CodecTaggerPattern struct {
+	Pattern string   `config:"pattern"`
+	AddTag  []string `config:"add tag"`
+
+	patterns PatternCollection
+}
+
+// CodecTaggerFactory holds the configuration for a tagger codec
+type CodecTaggerFactory struct {
+	Patterns []CodecTaggerPattern `config:"patterns"`
+}
+
+// CodecTagger is an instance of a tagger codec that is used by the Harvester
+// for tagging
+type CodecTagger struct {
+	config       *CodecTaggerFactory
+	lastOffset   int64
+	taggedLines  uint64
+	callbackFunc CallbackFunc
+	meterTagged  uint64
+}
+
+// NewTaggerCodecFactory creates a new TaggerCodecFactory for a codec definition
+// in the configuration file. This factory can be used to create instances of a
+// tagger codec for use by harvesters
+func NewTaggerCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecTaggerFactory{}
+	if err = config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if len(result.Patterns) == 0 {
+		return nil, errors.New("Tagger codec patterns must be specified.")
+	}
+
+	for i := range result.Patterns {
+		pattern := &result.Patterns[i]
+		if pattern.Pattern == "" {
+			return nil, fmt.Errorf("Tagger codec pattern %d must specify a pattern.", i)
+		}
+		if len(pattern.AddTag) == 0 {
+			return nil, fmt.Errorf("Tagger codec pattern %d must specify at least one tag to add.", i)
+		}
+		if err = pattern.patterns.Set([]string{pattern.Pattern}, "any"); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecTaggerFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return &CodecTagger{
+		config:       f,
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+	}
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback
+func (c *CodecTagger) Teardown() int64 {
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecTagger) Reset() {
+	c.lastOffset = 0
+}
+
+// Event is called by a Harvester when a new line event occurs on a file.
+// The tags of every matching pattern are added and all lines are shipped to
+// the callback
+func (c *CodecTagger) Event(startOffset int64, endOffset int64, event core.Event) {
+	message, _ := event["message"].(string)
+
+	tagged := false
+	for i := range c.config.Patterns {
+		pattern := &c.config.Patterns[i]
+		if !pattern.patterns.Match(message) {
+			continue
+		}
+
+		for _, tag := range pattern.AddTag {
+			event.AddTag(tag)
+		}
+		tagged = true
+	}
+
+	if tagged {
+		c.taggedLines++
+	}
+
+	c.lastOffset = endOffset
+
+	c.callbackFunc(startOffset, endOffset, event)
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecTagger) Meter() {
+	c.meterTagged = c.taggedLines
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecTagger) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("tagged_lines", admin.APINumber(c.meterTagged))
+	return api
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("tagger", NewTaggerCodecFactory)
+}
@@ -0,0 +1,75 @@
+package codecs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+var taggerEvents []core.Event
+
+func createTaggerCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	config := config.NewConfig()
+
+	factory, err := NewTaggerCodecFactory(config, "", unused, "tagger")
+	if err != nil {
+		t.Logf("Failed to create tagger codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+func checkTagger(startOffset int64, endOffset int64, event core.Event) {
+	taggerEvents = append(taggerEvents, event)
+}
+
+func TestTagger(t *testing.T) {
+	taggerEvents = make([]core.Event, 0, 3)
+
+	codec := createTaggerCodec(map[string]interface{}{
+		"patterns": []interface{}{
+			map[string]interface{}{"pattern": "ERROR", "add tag": []string{"alertable"}},
+			map[string]interface{}{"pattern": "database", "add tag": []string{"alertable", "database"}},
+			map[string]interface{}{"pattern": "!DEBUG", "add tag": []string{"visible"}},
+		},
+	}, checkTagger, t)
+
+	// Send some data
+	codec.Event(0, 1, core.Event{"message": "DEBUG First line"})
+	codec.Event(2, 3, core.Event{"message": "ERROR database line"})
+	codec.Event(4, 5, core.Event{"message": "ERROR another line", "tags": []string{"existing", "alertable"}})
+
+	if len(taggerEvents) != 3 {
+		t.Fatalf("Wrong line count received: %d", len(taggerEvents))
+	}
+	if _, ok := taggerEvents[0]["tags"]; ok {
+		t.Errorf("Wrong tags on line[0]: %v", taggerEvents[0]["tags"])
+	}
+	if tags := taggerEvents[1]["tags"]; !reflect.DeepEqual(tags, []string{"alertable", "database", "visible"}) {
+		t.Errorf("Wrong tags on line[1]: %v", tags)
+	}
+	if tags := taggerEvents[2]["tags"]; !reflect.DeepEqual(tags, []string{"existing", "alertable", "visible"}) {
+		t.Errorf("Wrong tags on line[2]: %v", tags)
+	}
+
+	offset := codec.Teardown()
+	if offset != 5 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestTaggerInvalid(t *testing.T) {
+	for _, patterns := range []interface{}{
+		[]interface{}{},
+		[]interface{}{map[string]interface{}{"add tag": []string{"alertable"}}},
+		[]interface{}{map[string]interface{}{"pattern": "ERROR"}},
+		[]interface{}{map[string]interface{}{"pattern": "(", "add tag": []string{"alertable"}}},
+	} {
+		if _, err := NewTaggerCodecFactory(config.NewConfig(), "", map[string]interface{}{"patterns": patterns}, "tagger"); err == nil {
+			t.Errorf("Tagger codec created with invalid patterns: %v", patterns)
+		}
+	}
+}
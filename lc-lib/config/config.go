@@ -25,6 +25,7 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"time"
 
 	"github.com/driskell/log-courier/lc-lib/addresspool"
@@ -41,32 +42,49 @@ var (
 )
 
 const (
+	defaultGeneralDeadTime           time.Duration = 1 * time.Hour
 	defaultGeneralHost               string        = "localhost.localdomain"
+	defaultGeneralLogFormat          string        = "text"
 	defaultGeneralLogLevel           logging.Level = logging.INFO
 	defaultGeneralLogStdout          bool          = true
 	defaultGeneralLogSyslog          bool          = false
 	defaultGeneralLineBufferBytes    int64         = 16384
 	defaultGeneralMaxLineBytes       int64         = 1048576
+	defaultGeneralMessageField       string        = "message"
 	defaultGeneralProspectInterval   time.Duration = 10 * time.Second
+	defaultGeneralShutdownTimeout    time.Duration = 10 * time.Second
 	defaultGeneralSpoolMaxBytes      int64         = 10485760
 	defaultGeneralSpoolSize          int64         = 1024
 	defaultGeneralSpoolTimeout       time.Duration = 5 * time.Second
 	defaultNetworkBackoff            time.Duration = 5 * time.Second
 	defaultNetworkBackoffMax         time.Duration = 300 * time.Second
+	defaultNetworkKeepalive          time.Duration = 900 * time.Second
 	defaultNetworkMaxPendingPayloads int64         = 10
 	defaultNetworkMethod             string        = "random"
 	defaultNetworkRfc2782Service     string        = "courier"
 	defaultNetworkRfc2782Srv         bool          = true
 	defaultNetworkTimeout            time.Duration = 15 * time.Second
 	defaultNetworkTransport          string        = "tls"
+	defaultFileMaxRecursionDepth     int64         = 16
+	defaultFileStartPosition         string        = "end"
 	defaultStreamAddHostField        bool          = true
 	defaultStreamAddOffsetField      bool          = true
 	defaultStreamAddPathField        bool          = true
 	defaultStreamAddTimezoneField    bool          = false
 	defaultStreamCodec               string        = "plain"
-	defaultStreamDeadTime            time.Duration = 1 * time.Hour
+	defaultStreamCompression         string        = "none"
+	defaultStreamMaxLineAction       string        = "split"
+	defaultStreamSampleRate          float64       = 1
 )
 
+// timestampFormats are the named formats accepted by the timestamp layout
+// option, with the pattern used to find each when no pattern is given
+var timestampFormats = map[string]struct{ layout, pattern string }{
+	"RFC3339": {time.RFC3339, `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})`},
+	"apache":  {"02/Jan/2006:15:04:05 -0700", `\[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]`},
+	"syslog":  {time.Stamp, `^(\w{3} [ \d]\d \d{2}:\d{2}:\d{2})`},
+}
+
 // Section is implemented by external config structures that will be
 // registered with the config package
 type Section interface {
@@ -82,30 +100,44 @@ var registeredSectionCreators = make(map[string]SectionCreator)
 
 // General holds the general configuration
 type General struct {
-	GlobalFields     map[string]interface{} `config:"global fields"`
-	Host             string                 `config:"host"`
-	LineBufferBytes  int64                  `config:"line buffer bytes"`
-	LogFile          string                 `config:"log file"`
-	LogLevel         logging.Level          `config:"log level"`
-	LogStdout        bool                   `config:"log stdout"`
-	LogSyslog        bool                   `config:"log syslog"`
-	MaxLineBytes     int64                  `config:"max line bytes"`
-	PersistDir       string                 `config:"persist directory"`
-	ProspectInterval time.Duration          `config:"prospect interval"`
-	SpoolSize        int64                  `config:"spool size"`
-	SpoolMaxBytes    int64                  `config:"spool max bytes"`
-	SpoolTimeout     time.Duration          `config:"spool timeout"`
+	CommitHookRequired  bool                   `config:"commit hook required"`
+	DeadTime            time.Duration          `config:"dead time"`
+	ECSCompatibility    bool                   `config:"ecs compatibility"`
+	GlobalFields        map[string]interface{} `config:"global fields"`
+	HeartbeatInterval   time.Duration          `config:"heartbeat interval"`
+	Host                string                 `config:"host"`
+	IncludeOffset       bool                   `config:"include offset"`
+	LineBufferBytes     int64                  `config:"line buffer bytes"`
+	LogFile             string                 `config:"log file"`
+	LogFormat           string                 `config:"log format"`
+	LogLevel            logging.Level          `config:"log level"`
+	LogStdout           bool                   `config:"log stdout"`
+	LogSyslog           bool                   `config:"log syslog"`
+	MaxActiveHarvesters int64                  `config:"max active harvesters"`
+	MaxLineBytes        int64                  `config:"max line bytes"`
+	MessageField        string                 `config:"message field"`
+	PersistDir          string                 `config:"persist directory"`
+	ProspectInterval    time.Duration          `config:"prospect interval"`
+	ShutdownTimeout     time.Duration          `config:"shutdown timeout"`
+	SpoolSize           int64                  `config:"spool size"`
+	SpoolMaxBytes       int64                  `config:"spool max bytes"`
+	SpoolTimeout        time.Duration          `config:"spool timeout"`
+	Tags                []string               `config:"tags"`
 }
 
 // InitDefaults initialises default values for the general configuration
 func (gc *General) InitDefaults() {
+	gc.DeadTime = defaultGeneralDeadTime
 	gc.LineBufferBytes = defaultGeneralLineBufferBytes
+	gc.LogFormat = defaultGeneralLogFormat
 	gc.LogLevel = defaultGeneralLogLevel
 	gc.LogStdout = defaultGeneralLogStdout
 	gc.LogSyslog = defaultGeneralLogSyslog
 	gc.MaxLineBytes = defaultGeneralMaxLineBytes
+	gc.MessageField = defaultGeneralMessageField
 	gc.PersistDir = DefaultGeneralPersistDir
 	gc.ProspectInterval = defaultGeneralProspectInterval
+	gc.ShutdownTimeout = defaultGeneralShutdownTimeout
 	gc.SpoolSize = defaultGeneralSpoolSize
 	gc.SpoolMaxBytes = defaultGeneralSpoolMaxBytes
 	gc.SpoolTimeout = defaultGeneralSpoolTimeout
@@ -119,13 +151,17 @@ type Network struct {
 
 	Backoff            time.Duration `config:"failure backoff"`
 	BackoffMax         time.Duration `config:"failure backoff max"`
+	ConnectTimeout     time.Duration `config:"connect timeout"`
+	Keepalive          time.Duration `config:"keepalive"`
 	MaxPendingPayloads int64         `config:"max pending payloads"`
 	Method             string        `config:"method"`
 	Rfc2782Service     string        `config:"rfc 2782 service"`
 	Rfc2782Srv         bool          `config:"rfc 2782 srv"`
 	Servers            []string      `config:"servers"`
+	Socket             string        `config:"socket"`
 	Timeout            time.Duration `config:"timeout"`
 	Transport          string        `config:"transport"`
+	WriteTimeout       time.Duration `config:"write timeout"`
 
 	Unused map[string]interface{}
 }
@@ -134,6 +170,7 @@ type Network struct {
 func (nc *Network) InitDefaults() {
 	nc.Backoff = defaultNetworkBackoff
 	nc.BackoffMax = defaultNetworkBackoffMax
+	nc.Keepalive = defaultNetworkKeepalive
 	nc.MaxPendingPayloads = defaultNetworkMaxPendingPayloads
 	nc.Method = defaultNetworkMethod
 	nc.Rfc2782Service = defaultNetworkRfc2782Service
@@ -157,9 +194,22 @@ type Stream struct {
 	AddOffsetField   bool                   `config:"add offset field"`
 	AddPathField     bool                   `config:"add path field"`
 	AddTimezoneField bool                   `config:"add timezone field"`
+	Codec            interface{}            `config:"codec"`
 	Codecs           []CodecStub            `config:"codecs"`
+	Compression      string                 `config:"compression"`
 	DeadTime         time.Duration          `config:"dead time"`
 	Fields           map[string]interface{} `config:"fields"`
+	MaxLineAction    string                 `config:"max line action"`
+	MaxLineBytes     int64                  `config:"max line bytes"`
+	MessageField     string                 `config:"message field"`
+	RateLimit        int64                  `config:"rate limit"`
+	ReadOnce         bool                   `config:"read once"`
+	SampleRate       float64                `config:"sample rate"`
+	SkipToPattern    string                 `config:"skip to pattern"`
+	Timestamp        Timestamp              `config:"timestamp"`
+
+	// SkipToRegexp is the compiled SkipToPattern, or nil if there is none
+	SkipToRegexp *regexp.Regexp
 }
 
 // InitDefaults initialises the default configuration for a log stream
@@ -168,14 +218,44 @@ func (sc *Stream) InitDefaults() {
 	sc.AddOffsetField = defaultStreamAddOffsetField
 	sc.AddPathField = defaultStreamAddPathField
 	sc.AddTimezoneField = defaultStreamAddTimezoneField
-	sc.DeadTime = defaultStreamDeadTime
+	sc.Compression = defaultStreamCompression
+	sc.MaxLineAction = defaultStreamMaxLineAction
+	sc.SampleRate = defaultStreamSampleRate
+	// NOTE: A zero DeadTime means inherit from the general configuration
+	// NOTE: A zero MaxLineBytes means inherit from the general configuration
+	// NOTE: An empty MessageField means inherit from the general configuration
+}
+
+// Timestamp holds the configuration for parsing the timestamp of each event from
+// the first line of its message
+type Timestamp struct {
+	Layout  string `config:"layout"`
+	Pattern string `config:"pattern"`
+
+	// GoLayout is the Go time layout the timestamp is parsed with, which is
+	// Layout unless it is one of the named formats
+	GoLayout string
+	// Regexp is the compiled Pattern, or the pattern of the named format if
+	// there is none, or nil if timestamps are not parsed
+	Regexp *regexp.Regexp
 }
 
 // File holds the configuration for a set of paths that share the same stream
 // configuration
 type File struct {
-	Paths  []string `config:"paths"`
-	Stream `config:",embed"`
+	Exclude           []string `config:"exclude"`
+	MaxRecursionDepth int64    `config:"max recursion depth"`
+	Paths             []string `config:"paths"`
+	Rotation          []string `config:"rotation"`
+	StartPosition     string   `config:"start position"`
+	Stream            `config:",embed"`
+}
+
+// InitDefaults initialises the default configuration for a file group
+func (fc *File) InitDefaults() {
+	// NOTE: The embedded Stream initialises its own defaults
+	fc.MaxRecursionDepth = defaultFileMaxRecursionDepth
+	fc.StartPosition = defaultFileStartPosition
 }
 
 // Config holds all the configuration for Log Courier
@@ -185,6 +265,11 @@ type Config struct {
 	Includes []string `config:"includes"`
 	Network  Network  `config:"network"`
 	Stdin    Stream   `config:"stdin"`
+	// All network configurations, the first of which is always Network
+	Networks []*Network
+	// ReadStdin is set if a file group has the path "-", in which case its
+	// stream configuration is moved to Stdin and it is removed from Files
+	ReadStdin bool
 	// Dynamic sections
 	// TODO: All top level sections to use this
 	Sections map[string]Section `config:",dynamic"`
@@ -230,6 +315,18 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		return
 	}
 
+	// The network section can be an array of networks, each of which will
+	// receive a copy of all events, so extract and populate it separately
+	var rawNetworks []interface{}
+	if networks, ok := rawConfig["network"].([]interface{}); ok {
+		rawNetworks = networks
+		delete(rawConfig, "network")
+	}
+
+	// The stdin section can not be combined with a file group for stdin, so
+	// note whether it was given before it is populated
+	_, hasStdin := rawConfig["stdin"]
+
 	// Populate configuration - reporting errors on spelling mistakes etc.
 	if err = c.PopulateConfig(c, rawConfig, "/"); err != nil {
 		return
@@ -269,6 +366,26 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		return
 	}
 
+	if c.General.HeartbeatInterval < 0 {
+		err = fmt.Errorf("/general/heartbeat interval can not be negative")
+		return
+	}
+
+	if c.General.LogFormat != "text" && c.General.LogFormat != "json" {
+		err = fmt.Errorf("The log format (/general/log format) is not recognised: %s", c.General.LogFormat)
+		return
+	}
+
+	if c.General.ShutdownTimeout < 0 {
+		err = fmt.Errorf("/general/shutdown timeout can not be negative")
+		return
+	}
+
+	if c.General.MaxActiveHarvesters < 0 {
+		err = fmt.Errorf("/general/max active harvesters can not be negative")
+		return
+	}
+
 	if c.General.LineBufferBytes < 1 {
 		err = fmt.Errorf("/general/line buffer bytes must be greater than 1")
 		return
@@ -280,6 +397,11 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		return
 	}
 
+	if c.General.MessageField == "" {
+		err = fmt.Errorf("/general/message field must not be empty")
+		return
+	}
+
 	if c.General.Host == "" {
 		ret, hostErr := os.Hostname()
 		if hostErr == nil {
@@ -296,48 +418,92 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		return
 	}
 
-	// TODO: Network method factory in publisher
-	if c.Network.Method == "" {
-		c.Network.Method = defaultNetworkMethod
-	}
-	if c.Network.Method != "random" && c.Network.Method != "failover" && c.Network.Method != "loadbalance" {
-		err = fmt.Errorf("The network method (/network/method) is not recognised: %s", c.Network.Method)
-		return
-	}
-
-	if len(c.Network.Servers) == 0 {
-		err = fmt.Errorf("No network servers were specified (/network/servers)")
-		return
-	}
+	if rawNetworks == nil {
+		c.Networks = []*Network{&c.Network}
+		if err = c.initNetworkConfig("/network/", &c.Network, initFactories); err != nil {
+			return
+		}
+	} else {
+		if len(rawNetworks) == 0 {
+			err = fmt.Errorf("No network configurations were specified (/network)")
+			return
+		}
 
-	servers := make(map[string]bool)
-	c.Network.AddressPools = make([]*addresspool.Pool, len(c.Network.Servers))
-	for n, server := range c.Network.Servers {
-		if _, exists := servers[server]; exists {
-			err = fmt.Errorf("The list of network servers (/network/servers) must be unique: %s appears multiple times", server)
+		var networks []Network
+		if err = c.populateSlice(reflect.ValueOf(&networks).Elem(), reflect.ValueOf(rawNetworks), "/network"); err != nil {
 			return
 		}
-		servers[server] = true
-		c.Network.AddressPools[n] = addresspool.NewPool(server)
-	}
 
-	if initFactories {
-		if registrarFunc, ok := registeredTransports[c.Network.Transport]; ok {
-			if c.Network.Factory, err = registrarFunc(c, "/network/", c.Network.Unused, c.Network.Transport); err != nil {
+		// The first network is always available as Network
+		c.Network = networks[0]
+		c.Networks = make([]*Network, len(networks))
+		c.Networks[0] = &c.Network
+		for n := 1; n < len(networks); n++ {
+			c.Networks[n] = &networks[n]
+		}
+
+		for n, network := range c.Networks {
+			if err = c.initNetworkConfig(fmt.Sprintf("/network[%d]/", n), network, initFactories); err != nil {
 				return
 			}
-		} else {
-			err = fmt.Errorf("Unrecognised transport '%s'", c.Network.Transport)
-			return
 		}
 	}
 
+	stdinFiles := -1
 	for k := range c.Files {
 		if len(c.Files[k].Paths) == 0 {
 			err = fmt.Errorf("No paths specified for /files[%d]/", k)
 			return
 		}
 
+		for _, path := range c.Files[k].Paths {
+			if path == "-" {
+				stdinFiles = k
+				break
+			}
+		}
+
+		if stdinFiles != -1 && (len(c.Files[k].Paths) != 1 || len(c.Files) != 1) {
+			err = fmt.Errorf("The stdin path \"-\" in /files[%d]/paths can not be used with other paths or file groups", stdinFiles)
+			return
+		}
+
+		if stdinFiles != -1 && hasStdin {
+			err = fmt.Errorf("The stdin path \"-\" in /files[%d]/paths can not be used with a /stdin section", stdinFiles)
+			return
+		}
+
+		if c.Files[k].StartPosition != "beginning" && c.Files[k].StartPosition != "end" {
+			err = fmt.Errorf("The start position (/files[%d]/start position) is not recognised: %s", k, c.Files[k].StartPosition)
+			return
+		}
+
+		if c.Files[k].MaxRecursionDepth < 0 {
+			err = fmt.Errorf("/files[%d]/max recursion depth can not be negative", k)
+			return
+		}
+
+		for _, path := range c.Files[k].Paths {
+			if _, err = filepath.Match(path, ""); err != nil {
+				err = fmt.Errorf("Invalid path '%s' in /files[%d]/paths: %s", path, k, err)
+				return
+			}
+		}
+
+		for _, exclude := range c.Files[k].Exclude {
+			if _, err = filepath.Match(exclude, ""); err != nil {
+				err = fmt.Errorf("Invalid pattern '%s' in /files[%d]/exclude: %s", exclude, k, err)
+				return
+			}
+		}
+
+		for _, rotation := range c.Files[k].Rotation {
+			if _, err = filepath.Match(rotation, ""); err != nil {
+				err = fmt.Errorf("Invalid pattern '%s' in /files[%d]/rotation: %s", rotation, k, err)
+				return
+			}
+		}
+
 		if err = c.initStreamConfig(fmt.Sprintf("/files[%d]", k), &c.Files[k].Stream, initFactories); err != nil {
 			return
 		}
@@ -347,6 +513,13 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		return
 	}
 
+	// The file group for stdin provides the stdin configuration
+	if stdinFiles != -1 {
+		c.Stdin = c.Files[stdinFiles].Stream
+		c.Files = nil
+		c.ReadStdin = true
+	}
+
 	// Validate the registered configurables
 	for _, section := range c.Sections {
 		if err = section.Validate(); err != nil {
@@ -357,9 +530,127 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 	return
 }
 
+// initNetworkConfig validates a network configuration and creates the
+// transport factory the publisher will require
+func (c *Config) initNetworkConfig(path string, network *Network, initFactories bool) (err error) {
+	// TODO: Network method factory in publisher
+	if network.Method == "" {
+		network.Method = defaultNetworkMethod
+	}
+	if network.Method != "random" && network.Method != "failover" && network.Method != "loadbalance" {
+		return fmt.Errorf("The network method (%smethod) is not recognised: %s", path, network.Method)
+	}
+
+	if network.Keepalive < 0 {
+		return fmt.Errorf("Option %skeepalive must not be negative", path)
+	}
+
+	// The connect and write timeouts default to the general network timeout
+	if network.ConnectTimeout < 0 {
+		return fmt.Errorf("Option %sconnect timeout must not be negative", path)
+	} else if network.ConnectTimeout == 0 {
+		network.ConnectTimeout = network.Timeout
+	}
+	if network.WriteTimeout < 0 {
+		return fmt.Errorf("Option %swrite timeout must not be negative", path)
+	} else if network.WriteTimeout == 0 {
+		network.WriteTimeout = network.Timeout
+	}
+
+	// A socket path takes the place of the servers list, for transports that
+	// connect to a local socket
+	if network.Socket != "" {
+		if len(network.Servers) != 0 {
+			return fmt.Errorf("Option %ssocket can not be combined with %sservers", path, path)
+		}
+		network.Servers = []string{network.Socket}
+	}
+
+	if len(network.Servers) == 0 {
+		return fmt.Errorf("No network servers were specified (%sservers)", path)
+	}
+
+	servers := make(map[string]bool)
+	network.AddressPools = make([]*addresspool.Pool, len(network.Servers))
+	for n, server := range network.Servers {
+		if _, exists := servers[server]; exists {
+			return fmt.Errorf("The list of network servers (%sservers) must be unique: %s appears multiple times", path, server)
+		}
+		servers[server] = true
+		network.AddressPools[n] = addresspool.NewPool(server)
+	}
+
+	if !initFactories {
+		return nil
+	}
+
+	if registrarFunc, ok := registeredTransports[network.Transport]; ok {
+		if network.Factory, err = registrarFunc(c, network, path, network.Unused, network.Transport); err != nil {
+			return
+		}
+	} else {
+		return fmt.Errorf("Unrecognised transport '%s'", network.Transport)
+	}
+
+	return nil
+}
+
 // initStreamConfig initialises a stream configuration by creating the necessary
 // codec factories the harvesters will require
 func (c *Config) initStreamConfig(path string, streamConfig *Stream, initFactories bool) (err error) {
+	if streamConfig.Compression != "none" && streamConfig.Compression != "gzip" {
+		return fmt.Errorf("The compression type (%s/compression) is not recognised: %s", path, streamConfig.Compression)
+	}
+
+	if streamConfig.DeadTime == 0 {
+		streamConfig.DeadTime = c.General.DeadTime
+	}
+
+	if streamConfig.MaxLineAction != "split" && streamConfig.MaxLineAction != "truncate" {
+		return fmt.Errorf("The max line action (%s/max line action) is not recognised: %s", path, streamConfig.MaxLineAction)
+	}
+
+	if streamConfig.MaxLineBytes < 0 {
+		return fmt.Errorf("%s/max line bytes can not be negative", path)
+	} else if streamConfig.MaxLineBytes == 0 {
+		streamConfig.MaxLineBytes = c.General.MaxLineBytes
+	} else if streamConfig.MaxLineBytes > c.General.SpoolMaxBytes {
+		return fmt.Errorf("%s/max line bytes can not be greater than /general/spool max bytes", path)
+	}
+
+	if streamConfig.MessageField == "" {
+		streamConfig.MessageField = c.General.MessageField
+	}
+
+	if streamConfig.RateLimit < 0 {
+		return fmt.Errorf("%s/rate limit must be 0 or greater", path)
+	}
+
+	if streamConfig.SampleRate < 0 || streamConfig.SampleRate > 1 {
+		return fmt.Errorf("%s/sample rate must be between 0 and 1", path)
+	}
+
+	if streamConfig.SkipToPattern != "" {
+		if streamConfig.SkipToRegexp, err = regexp.Compile(streamConfig.SkipToPattern); err != nil {
+			return fmt.Errorf("Option %s/skip to pattern is not a valid pattern: %s", path, err)
+		}
+	}
+
+	if err = c.initStreamTimestamp(path, &streamConfig.Timestamp); err != nil {
+		return
+	}
+
+	if err = c.initStreamCodec(path, streamConfig); err != nil {
+		return
+	}
+
+	// Ensure all Fields are map[string]interface{}
+	if err = c.fixMapKeys(path+"/fields", streamConfig.Fields); err != nil {
+		return
+	}
+
+	// TODO: EDGE CASE: Event transmit length is uint32, if fields length is rediculous we will fail
+
 	if !initFactories {
 		// Currently only codec factory is initialised, so skip if we're not doing that
 		return nil
@@ -380,13 +671,64 @@ func (c *Config) initStreamConfig(path string, streamConfig *Stream, initFactori
 		}
 	}
 
-	// Ensure all Fields are map[string]interface{}
-	if err = c.fixMapKeys(path+"/fields", streamConfig.Fields); err != nil {
-		return
+	return nil
+}
+
+// initStreamTimestamp resolves the layout of the timestamp option and compiles
+// its pattern, using the pattern of a named format if none was given
+func (c *Config) initStreamTimestamp(path string, timestamp *Timestamp) (err error) {
+	if timestamp.Layout == "" {
+		if timestamp.Pattern != "" {
+			return fmt.Errorf("Option %s/timestamp/layout is required when a pattern is given", path)
+		}
+		return nil
 	}
 
-	// TODO: EDGE CASE: Event transmit length is uint32, if fields length is rediculous we will fail
+	pattern := timestamp.Pattern
+	if format, ok := timestampFormats[timestamp.Layout]; ok {
+		timestamp.GoLayout = format.layout
+		if pattern == "" {
+			pattern = format.pattern
+		}
+	} else {
+		if pattern == "" {
+			return fmt.Errorf("Option %s/timestamp/pattern is required when the layout is not one of the named formats", path)
+		}
+		timestamp.GoLayout = timestamp.Layout
+	}
+
+	if timestamp.Regexp, err = regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("Option %s/timestamp/pattern is not a valid pattern: %s", path, err)
+	}
+
+	return nil
+}
+
+// initStreamCodec converts the "codec" option, which can be a single codec or an
+// array of codecs to chain together, into the "codecs" array
+func (c *Config) initStreamCodec(path string, streamConfig *Stream) error {
+	if streamConfig.Codec == nil {
+		return nil
+	}
+
+	if len(streamConfig.Codecs) != 0 {
+		return fmt.Errorf("Option %s/codec can not be combined with %s/codecs", path, path)
+	}
 
+	vCodec := reflect.ValueOf(streamConfig.Codec)
+	switch vCodec.Kind() {
+	case reflect.Map:
+		vCodec = reflect.ValueOf([]interface{}{streamConfig.Codec})
+	case reflect.Slice:
+	default:
+		return fmt.Errorf("Option %s/codec must be a hash or an array", path)
+	}
+
+	if err := c.populateSlice(reflect.ValueOf(&streamConfig.Codecs).Elem(), vCodec, path+"/codec/"); err != nil {
+		return err
+	}
+
+	streamConfig.Codec = nil
 	return nil
 }
 
@@ -407,19 +749,12 @@ func (c *Config) Get(name string) interface{} {
 // concrete strings.
 func (c *Config) fixMapKeys(path string, value map[string]interface{}) error {
 	for k, v := range value {
-		switch vt := v.(type) {
-		case map[string]interface{}:
-			if err := c.fixMapKeys(path+"/"+k, vt); err != nil {
-				return err
-			}
-		case map[interface{}]interface{}:
-			fixedValue, err := c.fixMapInterfaceKeys(path+"/"+k, vt)
-			if err != nil {
-				return err
-			}
-
-			value[k] = fixedValue
+		fixedValue, err := c.fixValue(path+"/"+k, v)
+		if err != nil {
+			return err
 		}
+
+		value[k] = fixedValue
 	}
 
 	return nil
@@ -434,26 +769,40 @@ func (c *Config) fixMapInterfaceKeys(path string, value map[interface{}]interfac
 			return nil, fmt.Errorf("Invalid non-string key at %s", path)
 		}
 
-		switch vt := v.(type) {
-		case map[string]interface{}:
-			if err := c.fixMapKeys(path+"/"+ks, vt); err != nil {
-				return nil, err
-			}
+		fixedValue, err := c.fixValue(path+"/"+ks, v)
+		if err != nil {
+			return nil, err
+		}
+
+		fixedMap[ks] = fixedValue
+	}
 
-			fixedMap[ks] = vt
-		case map[interface{}]interface{}:
-			fixedValue, err := c.fixMapInterfaceKeys(path+"/"+ks, vt)
+	return fixedMap, nil
+}
+
+// fixValue fixes the keys of the given value if it is a map, or of any maps
+// within it if it is an array. Other values, such as numbers and booleans, are
+// returned unchanged so they keep their type
+func (c *Config) fixValue(path string, value interface{}) (interface{}, error) {
+	switch vt := value.(type) {
+	case map[string]interface{}:
+		if err := c.fixMapKeys(path, vt); err != nil {
+			return nil, err
+		}
+	case map[interface{}]interface{}:
+		return c.fixMapInterfaceKeys(path, vt)
+	case []interface{}:
+		for i, item := range vt {
+			fixedItem, err := c.fixValue(fmt.Sprintf("%s[%d]", path, i), item)
 			if err != nil {
 				return nil, err
 			}
 
-			fixedMap[ks] = fixedValue
-		default:
-			fixedMap[ks] = vt
+			vt[i] = fixedItem
 		}
 	}
 
-	return fixedMap, nil
+	return value, nil
 }
 
 // RegisterConfigSection registers a new Section creator which will be used to
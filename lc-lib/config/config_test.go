@@ -0,0 +1,693 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tryLoadTestConfig(t *testing.T, name string, content string) (*Config, error) {
+	dir, err := ioutil.TempDir("", "lctest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write configuration: %s", err)
+	}
+
+	config := NewConfig()
+	return config, config.Load(path, false)
+}
+
+func loadTestConfig(t *testing.T, name string, content string) *Config {
+	config, err := tryLoadTestConfig(t, name, content)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %s", err)
+	}
+
+	return config
+}
+
+func checkFieldsEncoding(t *testing.T, fields map[string]interface{}) {
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatalf("Failed to encode fields: %s", err)
+	}
+
+	expected := `{"name":"test","nested":{"level":{"enabled":false}},"priority":5,"production":true,"ratio":0.5,"values":[1,"two",{"three":3}]}`
+	if string(encoded) != expected {
+		t.Errorf("Fields encoded incorrectly\nGot:      %s\nExpected: %s", encoded, expected)
+	}
+}
+
+func TestFieldsTypesJSON(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [
+			{
+				"paths": [ "/var/log/test.log" ],
+				"fields": {
+					"name": "test",
+					"priority": 5,
+					"ratio": 0.5,
+					"production": true,
+					"values": [ 1, "two", { "three": 3 } ],
+					"nested": { "level": { "enabled": false } }
+				}
+			}
+		]
+	}`)
+
+	checkFieldsEncoding(t, config.Files[0].Fields)
+}
+
+func TestFieldsTypesYAML(t *testing.T) {
+	config := loadTestConfig(t, "test.yaml", `
+general:
+  persist directory: /var/lib/log-courier
+network:
+  servers: [ "127.0.0.1:12345" ]
+files:
+  - paths: [ "/var/log/test.log" ]
+    fields:
+      name: test
+      priority: 5
+      ratio: 0.5
+      production: true
+      values:
+        - 1
+        - two
+        - three: 3
+      nested:
+        level:
+          enabled: false
+`)
+
+	checkFieldsEncoding(t, config.Files[0].Fields)
+}
+
+func setTestEnv() {
+	os.Setenv("LCTEST_SERVER", "192.168.0.1:5043")
+	os.Setenv("LCTEST_PRIORITY", "5")
+	os.Setenv("LCTEST_EMPTY", "")
+	os.Unsetenv("LCTEST_UNSET")
+}
+
+func checkEnvConfig(t *testing.T, config *Config) {
+	if len(config.Network.Servers) != 1 || config.Network.Servers[0] != "192.168.0.1:5043" {
+		t.Errorf("Server was not substituted: %v", config.Network.Servers)
+	}
+
+	encoded, err := json.Marshal(config.Files[0].Fields)
+	if err != nil {
+		t.Fatalf("Failed to encode fields: %s", err)
+	}
+
+	expected := `{"empty":"fallback","escaped":"${LCTEST_SERVER}","host":"default-host","priority":5}`
+	if string(encoded) != expected {
+		t.Errorf("Fields substituted incorrectly\nGot:      %s\nExpected: %s", encoded, expected)
+	}
+}
+
+func TestEnvSubstitutionJSON(t *testing.T) {
+	setTestEnv()
+
+	config := loadTestConfig(t, "test.json", `{
+		# References in comments such as ${LCTEST_UNSET} are ignored
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "${LCTEST_SERVER}" ] },
+		"files": [
+			{
+				"paths": [ "/var/log/test.log" ],
+				"fields": {
+					"host": "${LCTEST_UNSET:-default-host}",
+					"empty": "${LCTEST_EMPTY:-fallback}",
+					"priority": ${LCTEST_PRIORITY},
+					"escaped": "$${LCTEST_SERVER}"
+				}
+			}
+		]
+	}`)
+
+	checkEnvConfig(t, config)
+}
+
+func TestEnvSubstitutionYAML(t *testing.T) {
+	setTestEnv()
+
+	config := loadTestConfig(t, "test.yaml", `
+general:
+  persist directory: /var/lib/log-courier
+network:
+  servers: [ "${LCTEST_SERVER}" ]
+files:
+  - paths: [ "/var/log/test.log" ]
+    fields:
+      host: ${LCTEST_UNSET:-default-host}
+      empty: ${LCTEST_EMPTY:-fallback}
+      priority: ${LCTEST_PRIORITY}
+      escaped: $${LCTEST_SERVER}
+`)
+
+	checkEnvConfig(t, config)
+}
+
+func TestEnvSubstitutionUnset(t *testing.T) {
+	setTestEnv()
+
+	_, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "${LCTEST_UNSET}" ] }
+	}`)
+	if err == nil {
+		t.Fatal("Configuration loaded with an unset environment variable")
+	}
+	if !strings.Contains(err.Error(), "LCTEST_UNSET") || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Error does not name the variable and line: %s", err)
+	}
+}
+
+func TestEnvSubstitutionSyntaxError(t *testing.T) {
+	os.Setenv("LCTEST_SECRET", "a-long-secret-value")
+
+	_, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "${LCTEST_SECRET}" } }
+	}`)
+	if err == nil {
+		t.Fatal("Configuration loaded with a syntax error")
+	}
+
+	// The line is shown as written, with the position of the error within it
+	lines := strings.Split(err.Error(), "\n")
+	if strings.Contains(err.Error(), "a-long-secret-value") || len(lines) != 3 || !strings.Contains(lines[1], "${LCTEST_SECRET}") {
+		t.Fatalf("Error does not show the line as written: %s", err)
+	}
+	if len(lines[2])-1 != strings.Index(lines[1], "} }") {
+		t.Errorf("Error does not show the position of the error: %s", err)
+	}
+}
+
+func setTestEnvSpecial() {
+	os.Setenv("LCTEST_SPECIAL", "quote \" backslash \\ newline \n end")
+	os.Setenv("LCTEST_INJECT", "x\", \"injected\": \"y")
+	os.Setenv("LCTEST_YAML", "value\ninjected: true")
+}
+
+func checkEnvSpecialConfig(t *testing.T, config *Config) {
+	expected := map[string]interface{}{
+		"special": "quote \" backslash \\ newline \n end",
+		"inject":  "x\", \"injected\": \"y",
+		"yaml":    "value\ninjected: true",
+	}
+	for k, v := range expected {
+		if config.Files[0].Fields[k] != v {
+			t.Errorf("Field %s substituted incorrectly: %q (expected %q)", k, config.Files[0].Fields[k], v)
+		}
+	}
+	if _, ok := config.Files[0].Fields["injected"]; ok || len(config.Files[0].Fields) != len(expected) {
+		t.Errorf("Substitution altered the structure: %v", config.Files[0].Fields)
+	}
+}
+
+func TestEnvSubstitutionQuotedJSON(t *testing.T) {
+	setTestEnvSpecial()
+
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [
+			{
+				"paths": [ "/var/log/test.log" ],
+				"fields": {
+					"special": "${LCTEST_SPECIAL}",
+					"inject": ${LCTEST_INJECT},
+					"yaml": "${LCTEST_YAML}"
+				}
+			}
+		]
+	}`)
+
+	checkEnvSpecialConfig(t, config)
+}
+
+func TestEnvSubstitutionQuotedYAML(t *testing.T) {
+	setTestEnvSpecial()
+
+	config := loadTestConfig(t, "test.yaml", `
+general:
+  persist directory: /var/lib/log-courier
+network:
+  servers: [ "127.0.0.1:12345" ]
+files:
+  - paths: [ "/var/log/test.log" ]
+    fields:
+      special: "${LCTEST_SPECIAL}"
+      inject: ${LCTEST_INJECT}
+      yaml: ${LCTEST_YAML}
+`)
+
+	checkEnvSpecialConfig(t, config)
+}
+
+func TestEnvSubstitutionCommentYAML(t *testing.T) {
+	setTestEnv()
+
+	config := loadTestConfig(t, "test.yaml", `
+# References in comments such as ${LCTEST_UNSET} are ignored
+general:
+  persist directory: /var/lib/log-courier # Or ${LCTEST_UNSET}
+network:
+  servers: [ "${LCTEST_SERVER}" ]
+`)
+
+	if len(config.Network.Servers) != 1 || config.Network.Servers[0] != "192.168.0.1:5043" {
+		t.Errorf("Server was not substituted: %v", config.Network.Servers)
+	}
+}
+
+func TestEnvSubstitutionUnsetYAML(t *testing.T) {
+	setTestEnv()
+
+	_, err := tryLoadTestConfig(t, "test.yaml", `
+general:
+  persist directory: /var/lib/log-courier
+network:
+  servers: [ "${LCTEST_UNSET}" ]
+`)
+	if err == nil {
+		t.Fatal("Configuration loaded with an unset environment variable")
+	}
+	if !strings.Contains(err.Error(), "LCTEST_UNSET") || !strings.Contains(err.Error(), "/network/servers[0]") {
+		t.Errorf("Error does not name the variable and path: %s", err)
+	}
+}
+
+func TestEnvSubstitutionInvalid(t *testing.T) {
+	for _, content := range []string{`{ "general": "${}" }`, `{ "general": "${1ABC}" }`, `{ "general": "${LCTEST_SERVER" }`} {
+		if _, err := tryLoadTestConfig(t, "test.json", content); err == nil {
+			t.Errorf("Configuration loaded with an invalid reference: %s", content)
+		}
+	}
+}
+
+func TestInvalidPathPattern(t *testing.T) {
+	_, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/[test.log" ] } ]
+	}`)
+	if err == nil {
+		t.Fatal("Configuration loaded with an invalid path pattern")
+	}
+	if !strings.Contains(err.Error(), "/files[0]/paths") {
+		t.Errorf("Error does not name the option: %s", err)
+	}
+}
+
+func TestInvalidExcludePattern(t *testing.T) {
+	_, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/*.log" ], "exclude": [ "*.gz", "[test.log" ] } ]
+	}`)
+	if err == nil {
+		t.Fatal("Configuration loaded with an invalid exclude pattern")
+	}
+	if !strings.Contains(err.Error(), "/files[0]/exclude") || !strings.Contains(err.Error(), "[test.log") {
+		t.Errorf("Error does not name the option and pattern: %s", err)
+	}
+}
+
+func TestStdinPath(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "-" ], "fields": { "type": "piped" } } ]
+	}`)
+
+	if !config.ReadStdin {
+		t.Error("Stdin path did not enable reading from stdin")
+	}
+	if len(config.Files) != 0 {
+		t.Errorf("Stdin file group was not removed: %v", config.Files)
+	}
+	if config.Stdin.Fields["type"] != "piped" {
+		t.Errorf("Stdin file group configuration was not used: %v", config.Stdin.Fields)
+	}
+}
+
+func TestStdinPathCombined(t *testing.T) {
+	_, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "-" ] }, { "paths": [ "/var/log/test.log" ] } ]
+	}`)
+	if err == nil {
+		t.Error("Configuration loaded with the stdin path and another file group")
+	}
+}
+
+func TestStdinPathSection(t *testing.T) {
+	_, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "-" ] } ],
+		"stdin": { "fields": { "type": "piped" } }
+	}`)
+	if err == nil {
+		t.Fatal("Configuration loaded with the stdin path and a stdin section")
+	}
+	if !strings.Contains(err.Error(), "/stdin") {
+		t.Errorf("Error does not name the stdin section: %s", err)
+	}
+}
+
+func TestMessageField(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier", "message field": "log" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/first.log" ] }, { "paths": [ "/var/log/second.log" ], "message field": "line" } ]
+	}`)
+
+	if config.Files[0].MessageField != "log" {
+		t.Errorf("File group did not inherit the general message field: %s", config.Files[0].MessageField)
+	}
+	if config.Files[1].MessageField != "line" {
+		t.Errorf("File group message field was not used: %s", config.Files[1].MessageField)
+	}
+	if config.Stdin.MessageField != "log" {
+		t.Errorf("Stdin did not inherit the general message field: %s", config.Stdin.MessageField)
+	}
+}
+
+func TestMessageFieldDefault(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/test.log" ] } ]
+	}`)
+
+	if config.Files[0].MessageField != "message" {
+		t.Errorf("Unexpected default message field: %s", config.Files[0].MessageField)
+	}
+}
+
+func TestCodecSingle(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/test.log" ], "codec": { "name": "filter", "patterns": [ "^DEBUG" ] } } ]
+	}`)
+
+	codecs := config.Files[0].Codecs
+	if len(codecs) != 1 || codecs[0].Name != "filter" {
+		t.Fatalf("Single codec was not converted: %v", codecs)
+	}
+	if _, ok := codecs[0].Unused["patterns"]; !ok {
+		t.Errorf("Codec options were not retained: %v", codecs[0].Unused)
+	}
+}
+
+func TestCodecChain(t *testing.T) {
+	config := loadTestConfig(t, "test.yaml", `
+general:
+  persist directory: /var/lib/log-courier
+network:
+  servers: [ "127.0.0.1:12345" ]
+files:
+  - paths: [ /var/log/test.log ]
+    codec:
+      - name: filter
+        patterns: [ "^DEBUG" ]
+      - name: multiline
+        patterns: [ "^[[:space:]]" ]
+`)
+
+	codecs := config.Files[0].Codecs
+	if len(codecs) != 2 || codecs[0].Name != "filter" || codecs[1].Name != "multiline" {
+		t.Fatalf("Codec chain was not converted in order: %v", codecs)
+	}
+}
+
+func TestCodecCombined(t *testing.T) {
+	_, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/test.log" ], "codec": { "name": "plain" }, "codecs": [ { "name": "plain" } ] } ]
+	}`)
+	if err == nil {
+		t.Fatal("Configuration loaded with both codec and codecs")
+	}
+	if !strings.Contains(err.Error(), "/files[0]/codec") {
+		t.Errorf("Error does not name the option: %s", err)
+	}
+}
+
+func TestMaxLineBytes(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier", "max line bytes": 4096 },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/first.log" ] }, { "paths": [ "/var/log/second.log" ], "max line bytes": 1024, "max line action": "truncate" } ]
+	}`)
+
+	if config.Files[0].MaxLineBytes != 4096 || config.Files[0].MaxLineAction != "split" {
+		t.Errorf("File group did not inherit the general max line bytes: %d (%s)", config.Files[0].MaxLineBytes, config.Files[0].MaxLineAction)
+	}
+	if config.Files[1].MaxLineBytes != 1024 || config.Files[1].MaxLineAction != "truncate" {
+		t.Errorf("File group max line bytes was not used: %d (%s)", config.Files[1].MaxLineBytes, config.Files[1].MaxLineAction)
+	}
+}
+
+func TestMaxLineBytesInvalid(t *testing.T) {
+	for _, files := range []string{
+		`{ "paths": [ "/var/log/test.log" ], "max line bytes": 20971520 }`,
+		`{ "paths": [ "/var/log/test.log" ], "max line action": "drop" }`,
+	} {
+		if _, err := tryLoadTestConfig(t, "test.json", `{
+			"general": { "persist directory": "/var/lib/log-courier" },
+			"network": { "servers": [ "127.0.0.1:12345" ] },
+			"files": [ `+files+` ]
+		}`); err == nil {
+			t.Errorf("Configuration loaded with an invalid file group: %s", files)
+		}
+	}
+}
+
+func TestStartPosition(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [
+			{ "paths": [ "/var/log/test.log" ] },
+			{ "paths": [ "/var/log/other.log" ], "start position": "beginning" }
+		]
+	}`)
+	if config.Files[0].StartPosition != "end" || config.Files[1].StartPosition != "beginning" {
+		t.Errorf("Unexpected start positions: %s, %s", config.Files[0].StartPosition, config.Files[1].StartPosition)
+	}
+
+	_, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/test.log" ], "start position": "middle" } ]
+	}`)
+	if err == nil || !strings.Contains(err.Error(), "/files[0]/start position") || !strings.Contains(err.Error(), "middle") {
+		t.Errorf("Unexpected error for invalid start position: %v", err)
+	}
+}
+
+func TestMultipleNetworks(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": [
+			{ "servers": [ "127.0.0.1:12345" ] },
+			{ "servers": [ "127.0.0.1:12346" ], "timeout": "30s" }
+		]
+	}`)
+
+	if len(config.Networks) != 2 || config.Networks[0] != &config.Network {
+		t.Fatalf("Networks were not loaded: %v", config.Networks)
+	}
+	if config.Networks[1].Servers[0] != "127.0.0.1:12346" || config.Networks[1].Timeout != 30*time.Second {
+		t.Errorf("Second network was not loaded: %v", config.Networks[1])
+	}
+	if config.Networks[0].Timeout != 15*time.Second {
+		t.Errorf("First network did not receive the default timeout: %v", config.Networks[0].Timeout)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [
+			{ "paths": [ "/var/log/first.log" ] },
+			{ "paths": [ "/var/log/second.log" ], "timestamp": { "layout": "apache" } },
+			{ "paths": [ "/var/log/third.log" ], "timestamp": { "layout": "2006-01-02 15:04:05", "pattern": "^(\\S+ \\S+)" } }
+		]
+	}`)
+
+	if config.Files[0].Timestamp.Regexp != nil {
+		t.Errorf("Timestamp parsing was enabled without a layout")
+	}
+	if timestamp := config.Files[1].Timestamp; timestamp.Regexp == nil || timestamp.GoLayout != "02/Jan/2006:15:04:05 -0700" {
+		t.Errorf("Named timestamp format was not resolved: %v", timestamp)
+	}
+	if timestamp := config.Files[2].Timestamp; timestamp.Regexp == nil || timestamp.Regexp.String() != "^(\\S+ \\S+)" || timestamp.GoLayout != "2006-01-02 15:04:05" {
+		t.Errorf("Custom timestamp layout was not loaded: %v", timestamp)
+	}
+
+	for _, timestamp := range []string{
+		`{ "pattern": "^(\\S+)" }`,
+		`{ "layout": "2006-01-02" }`,
+		`{ "layout": "syslog", "pattern": "(" }`,
+	} {
+		if _, err := tryLoadTestConfig(t, "test.json", `{
+			"general": { "persist directory": "/var/lib/log-courier" },
+			"network": { "servers": [ "127.0.0.1:12345" ] },
+			"files": [ { "paths": [ "/var/log/test.log" ], "timestamp": `+timestamp+` } ]
+		}`); err == nil || !strings.Contains(err.Error(), "/files[0]/timestamp/") {
+			t.Errorf("Unexpected error for invalid timestamp %s: %v", timestamp, err)
+		}
+	}
+}
+
+func TestTimestampFormats(t *testing.T) {
+	for name, line := range map[string]string{
+		"RFC3339": `{"time":"2017-02-18T10:00:01.123+01:00","msg":"started"}`,
+		"apache":  `127.0.0.1 - - [18/Feb/2017:10:00:01 +0100] "GET / HTTP/1.1" 200 12`,
+		"syslog":  `Feb 18 10:00:01 host app[123]: started`,
+	} {
+		format := timestampFormats[name]
+		match := regexp.MustCompile(format.pattern).FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("Pattern for %s did not match: %s", name, line)
+			continue
+		}
+		value := match[len(match)-1]
+		if _, err := time.Parse(format.layout, value); err != nil {
+			t.Errorf("Layout for %s did not parse %s: %s", name, value, err)
+		}
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/first.log" ] }, { "paths": [ "/var/log/second.log" ], "sample rate": 0.1 } ]
+	}`)
+
+	if config.Files[0].SampleRate != 1 {
+		t.Errorf("Sample rate did not default to keeping all events: %f", config.Files[0].SampleRate)
+	}
+	if config.Files[1].SampleRate != 0.1 {
+		t.Errorf("Sample rate was not loaded: %f", config.Files[1].SampleRate)
+	}
+
+	if _, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/test.log" ], "sample rate": 1.5 } ]
+	}`); err == nil || !strings.Contains(err.Error(), "/files[0]/sample rate") {
+		t.Errorf("Unexpected error for invalid sample rate: %v", err)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ], "keepalive": "60s" }
+	}`)
+
+	if config.Network.Keepalive != 60*time.Second {
+		t.Errorf("Keepalive was not loaded: %v", config.Network.Keepalive)
+	}
+
+	// Zero disables the keepalive ping
+	config = loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ], "keepalive": 0 }
+	}`)
+
+	if config.Network.Keepalive != 0 {
+		t.Errorf("Zero keepalive was not loaded: %v", config.Network.Keepalive)
+	}
+
+	if _, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ], "keepalive": "-1s" }
+	}`); err == nil || !strings.Contains(err.Error(), "/network/keepalive") {
+		t.Errorf("Unexpected error for negative keepalive: %v", err)
+	}
+}
+
+func TestNetworkTimeouts(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ], "timeout": "20s", "write timeout": "5s" }
+	}`)
+
+	if config.Network.ConnectTimeout != 20*time.Second {
+		t.Errorf("Connect timeout did not default to the timeout: %v", config.Network.ConnectTimeout)
+	}
+	if config.Network.WriteTimeout != 5*time.Second {
+		t.Errorf("Write timeout was not loaded: %v", config.Network.WriteTimeout)
+	}
+
+	if _, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ], "connect timeout": "-1s" }
+	}`); err == nil || !strings.Contains(err.Error(), "/network/connect timeout") {
+		t.Errorf("Unexpected error for negative connect timeout: %v", err)
+	}
+}
+
+func TestSkipToPattern(t *testing.T) {
+	config := loadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/first.log" ] }, { "paths": [ "/var/log/second.log" ], "skip to pattern": "^[0-9]{4}-" } ]
+	}`)
+
+	if config.Files[0].SkipToRegexp != nil {
+		t.Errorf("Skip to pattern was set when not specified: %s", config.Files[0].SkipToRegexp)
+	}
+	if config.Files[1].SkipToRegexp == nil || !config.Files[1].SkipToRegexp.MatchString("2017-01-01 line") {
+		t.Errorf("Skip to pattern was not compiled: %v", config.Files[1].SkipToRegexp)
+	}
+
+	if _, err := tryLoadTestConfig(t, "test.json", `{
+		"general": { "persist directory": "/var/lib/log-courier" },
+		"network": { "servers": [ "127.0.0.1:12345" ] },
+		"files": [ { "paths": [ "/var/log/test.log" ], "skip to pattern": "(" } ]
+	}`); err == nil || !strings.Contains(err.Error(), "/files[0]/skip to pattern") {
+		t.Errorf("Unexpected error for invalid skip to pattern: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// expandEnv replaces all ${VAR} and ${VAR:-default} references within the
+// given data with the value of the named environment variable. The default is
+// used if the variable is unset or empty, and $${ can be used to write a
+// literal ${. Each value is passed through escape, along with whether the
+// reference is within a double quoted string, so that it can be written safely
+// into the data. Errors describe where the reference is using location
+func expandEnv(data []byte, escape func(value string, quoted bool) string, location func(offset int) string) ([]byte, error) {
+	if bytes.Index(data, []byte("${")) == -1 {
+		return data, nil
+	}
+
+	expanded := new(bytes.Buffer)
+	expanded.Grow(len(data))
+
+	quoted := false
+	for p := 0; p < len(data); p++ {
+		if data[p] == '"' {
+			quoted = !quoted
+		} else if data[p] == '\\' && quoted && p+1 < len(data) {
+			expanded.WriteByte(data[p])
+			p++
+			expanded.WriteByte(data[p])
+			continue
+		}
+
+		if data[p] != '$' || p+1 >= len(data) {
+			expanded.WriteByte(data[p])
+			continue
+		}
+
+		if data[p+1] == '$' && p+2 < len(data) && data[p+2] == '{' {
+			// Escaped reference
+			expanded.WriteString("${")
+			p += 2
+			continue
+		}
+
+		if data[p+1] != '{' {
+			expanded.WriteByte(data[p])
+			continue
+		}
+
+		end := bytes.IndexByte(data[p+2:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("Unterminated environment variable reference %s", location(p))
+		}
+
+		reference := string(data[p+2 : p+2+end])
+		name, defaultValue, hasDefault := reference, "", false
+		for i := 0; i < len(reference)-1; i++ {
+			if reference[i] == ':' && reference[i+1] == '-' {
+				name, defaultValue, hasDefault = reference[:i], reference[i+2:], true
+				break
+			}
+		}
+
+		if !isEnvName(name) {
+			return nil, fmt.Errorf("Invalid environment variable reference %s: ${%s}", location(p), reference)
+		}
+
+		value, isSet := os.LookupEnv(name)
+		if !isSet || (hasDefault && value == "") {
+			if !hasDefault {
+				return nil, fmt.Errorf("Environment variable %s referenced %s is not set and has no default", name, location(p))
+			}
+			value = defaultValue
+		}
+
+		expanded.WriteString(escape(value, quoted))
+		p += 2 + end
+	}
+
+	return expanded.Bytes(), nil
+}
+
+// expandEnvJSON replaces environment variable references within JSON data.
+// Values within strings are escaped, and values elsewhere are written as is
+// only if they are a JSON number or boolean, and as a string otherwise, so
+// that a value can never alter the structure of the data
+func expandEnvJSON(data []byte) ([]byte, error) {
+	escape := func(value string, quoted bool) string {
+		if !quoted {
+			var literal interface{}
+			if err := json.Unmarshal([]byte(value), &literal); err == nil {
+				switch literal.(type) {
+				case float64, bool:
+					return value
+				}
+			}
+		}
+
+		// Encoding a string can not fail
+		encoded, _ := json.Marshal(value)
+		if quoted {
+			return string(encoded[1 : len(encoded)-1])
+		}
+		return string(encoded)
+	}
+
+	location := func(offset int) string {
+		return fmt.Sprintf("on line %d", bytes.Count(data[:offset], []byte("\n"))+1)
+	}
+
+	return expandEnv(data, escape, location)
+}
+
+// expandEnvYAML replaces environment variable references within the string
+// values of parsed YAML data, which must be a pointer. A value consisting of
+// only a reference becomes a number or boolean if the value of the variable
+// is one, in the same way as if it had been written into the file
+func expandEnvYAML(rawConfig interface{}) error {
+	vRawConfig := reflect.ValueOf(rawConfig).Elem()
+	expanded, err := expandEnvValue(vRawConfig.Interface(), "")
+	if err != nil {
+		return err
+	}
+
+	vRawConfig.Set(reflect.ValueOf(expanded))
+	return nil
+}
+
+// expandEnvValue replaces environment variable references within the string
+// values of the given parsed value, returning the new value
+func expandEnvValue(value interface{}, path string) (interface{}, error) {
+	var err error
+
+	switch typed := value.(type) {
+	case string:
+		return expandEnvString(typed, path)
+	case map[string]interface{}:
+		for k, v := range typed {
+			if typed[k], err = expandEnvValue(v, fmt.Sprintf("%s/%s", path, k)); err != nil {
+				return nil, err
+			}
+		}
+	case map[interface{}]interface{}:
+		for k, v := range typed {
+			if typed[k], err = expandEnvValue(v, fmt.Sprintf("%s/%v", path, k)); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for k, v := range typed {
+			if typed[k], err = expandEnvValue(v, fmt.Sprintf("%s[%d]", path, k)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return value, nil
+}
+
+// expandEnvString replaces environment variable references within a string
+// value from parsed YAML data
+func expandEnvString(value string, path string) (interface{}, error) {
+	escape := func(value string, quoted bool) string {
+		return value
+	}
+
+	location := func(offset int) string {
+		return fmt.Sprintf("at %s", path)
+	}
+
+	expanded, err := expandEnv([]byte(value), escape, location)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(value, "${") && strings.IndexByte(value, '}') == len(value)-1 {
+		var literal interface{}
+		if err := yaml.Unmarshal(expanded, &literal); err == nil {
+			switch literal.(type) {
+			case int, int64, uint64, float64, bool:
+				return literal, nil
+			}
+		}
+	}
+
+	return string(expanded), nil
+}
+
+// isEnvName returns true if the given string is a valid environment variable
+// name, consisting of letters, digits and underscores and not starting with a
+// digit
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, c := range name {
+		if c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') {
+			continue
+		}
+		if i != 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
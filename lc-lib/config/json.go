@@ -49,7 +49,7 @@ func (c *Config) loadJSONFile(path string, rawConfig interface{}) (err error) {
 		return
 	}
 	if stat.Size() > (10 << 20) {
-		err = fmt.Errorf("Config file too large (%s)", stat.Size())
+		err = fmt.Errorf("Config file too large (%d)", stat.Size())
 		return
 	}
 
@@ -135,9 +135,16 @@ func (c *Config) loadJSONFile(path string, rawConfig interface{}) (err error) {
 		return
 	}
 
+	// Substitute environment variables, after comments are stripped so that
+	// references within comments are ignored
+	var expanded []byte
+	if expanded, err = expandEnvJSON(stripped.Bytes()); err != nil {
+		return
+	}
+
 	// Pull the entire structure into rawConfig
-	if err = json.Unmarshal(stripped.Bytes(), rawConfig); err != nil {
-		err = c.parseJSONSyntaxError(stripped.Bytes(), err)
+	if err = json.Unmarshal(expanded, rawConfig); err != nil {
+		err = c.parseJSONSyntaxError(stripped.Bytes(), expanded, err)
 		return
 	}
 
@@ -145,23 +152,30 @@ func (c *Config) loadJSONFile(path string, rawConfig interface{}) (err error) {
 }
 
 // parseSyntaxError parses a JSON Unmarshal error into a pretty error message
-// when given the original JSON data and the received error
-func (c *Config) parseJSONSyntaxError(js []byte, err error) error {
+// when given the original JSON data and the received error. The position is
+// found within the expanded data that was parsed, but the line shown is from
+// the data before environment variables were substituted so that their values
+// are never revealed. Substitution never adds lines so line numbers are equal
+func (c *Config) parseJSONSyntaxError(original []byte, js []byte, err error) error {
 	jsonErr, ok := err.(*json.SyntaxError)
 	if !ok {
 		return err
 	}
 
 	start := bytes.LastIndex(js[:jsonErr.Offset], []byte("\n")) + 1
-	end := bytes.Index(js[start:], []byte("\n"))
-	if end >= 0 {
-		end += start
-	} else {
-		end = len(js)
-	}
 
 	line, pos := bytes.Count(js[:start], []byte("\n")), int(jsonErr.Offset)-start-1
 
+	originalLine := original
+	for i := 0; i < line; i++ {
+		originalLine = originalLine[bytes.IndexByte(originalLine, '\n')+1:]
+	}
+	if end := bytes.IndexByte(originalLine, '\n'); end >= 0 {
+		originalLine = originalLine[:end]
+	}
+
+	pos = originalColumn(originalLine, pos)
+
 	var posStr string
 	if pos > 0 {
 		posStr = strings.Repeat(" ", pos)
@@ -169,5 +183,27 @@ func (c *Config) parseJSONSyntaxError(js []byte, err error) error {
 		posStr = ""
 	}
 
-	return fmt.Errorf("json: %s on line %d\n%s\n%s^", err, line, js[start:end], posStr)
+	return fmt.Errorf("json: %s on line %d\n%s\n%s^", err, line, originalLine, posStr)
+}
+
+// originalColumn maps a column within a line after environment variables are
+// substituted to the column within the line before, which is the start of the
+// reference if the column is within a substituted value
+func originalColumn(line []byte, column int) int {
+	lastExpanded := 0
+	for p := 1; p <= len(line); p++ {
+		// Expansion fails part way through a reference
+		prefix, err := expandEnvJSON(line[:p])
+		if err != nil {
+			continue
+		}
+
+		if len(prefix) > column {
+			return lastExpanded
+		}
+
+		lastExpanded = p
+	}
+
+	return len(line)
 }
@@ -125,6 +125,10 @@ FieldLoop:
 		if unUsed.IsNil() {
 			unUsed.Set(reflect.MakeMap(unUsed.Type()))
 		}
+		if !vRawConfig.IsValid() {
+			// Only setting defaults, so there is nothing unused
+			return
+		}
 		for _, vKey := range vRawConfig.MapKeys() {
 			// If the key is wrapped in interface{}, unwrap it
 			if vKey.Type().Kind() == reflect.Interface {
@@ -276,6 +280,16 @@ func (c *Config) populateValue(vField reflect.Value, vValue reflect.Value, confi
 		return
 	}
 
+	if vField.Kind() == reflect.Float64 {
+		if vValue.Kind() == reflect.Int {
+			vField.SetFloat(float64(vValue.Int()))
+		} else {
+			err = fmt.Errorf("Option %s%s is not a valid number", configPath, tag)
+		}
+
+		return
+	}
+
 	if vField.Kind() == reflect.Int64 || vField.Kind() == reflect.Int {
 		var number int
 
@@ -17,8 +17,9 @@
 package config
 
 // TransportRegistrarFunc is a callback that validates the configuration for
-// a transport that was registered vua RegisterTransport
-type TransportRegistrarFunc func(*Config, string, map[string]interface{}, string) (interface{}, error)
+// a transport that was registered vua RegisterTransport, and is given the
+// network configuration the transport belongs to
+type TransportRegistrarFunc func(*Config, *Network, string, map[string]interface{}, string) (interface{}, error)
 
 var registeredTransports = make(map[string]TransportRegistrarFunc)
 
@@ -35,6 +35,12 @@ func (c *Config) loadYAMLFile(path string, rawConfig interface{}) (err error) {
 	}
 
 	// Pull the entire structure into rawConfig
-	err = yaml.Unmarshal(data, rawConfig)
+	if err = yaml.Unmarshal(data, rawConfig); err != nil {
+		return
+	}
+
+	// Substitute environment variables, after parsing so that references
+	// within comments are ignored and values can not alter the structure
+	err = expandEnvYAML(rawConfig)
 	return
 }
@@ -16,7 +16,10 @@
 
 package core
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"strings"
+)
 
 // Event holds a key-value map that represents a single log event
 type Event map[string]interface{}
@@ -33,3 +36,97 @@ type EventDescriptor struct {
 func (e Event) Encode() ([]byte, error) {
 	return json.Marshal(e)
 }
+
+// AddTag adds a tag to the event, appending it to any tags already present
+// unless it is already one of them
+// Existing tags are copied rather than appended to as they may be shared with
+// other events, such as when they come from the configuration
+func (e Event) AddTag(tag string) {
+	if e.HasTag(tag) {
+		return
+	}
+
+	switch tags := e["tags"].(type) {
+	case nil:
+		e["tags"] = []string{tag}
+	case []string:
+		newTags := make([]string, len(tags), len(tags)+1)
+		copy(newTags, tags)
+		e["tags"] = append(newTags, tag)
+	case []interface{}:
+		newTags := make([]interface{}, len(tags), len(tags)+1)
+		copy(newTags, tags)
+		e["tags"] = append(newTags, tag)
+	case string:
+		e["tags"] = []string{tags, tag}
+	default:
+		// Some other value such as a number, keep it as the first tag
+		e["tags"] = []interface{}{tags, tag}
+	}
+}
+
+// MergeTags adds each of the given tags to the event, which can be a single
+// string or an array of them
+func (e Event) MergeTags(tags interface{}) {
+	switch tags := tags.(type) {
+	case string:
+		e.AddTag(tags)
+	case []string:
+		for _, tag := range tags {
+			e.AddTag(tag)
+		}
+	case []interface{}:
+		for _, tag := range tags {
+			if tagString, ok := tag.(string); ok {
+				e.AddTag(tagString)
+			}
+		}
+	}
+}
+
+// HasTag returns true if the event has the given tag
+func (e Event) HasTag(tag string) bool {
+	switch tags := e["tags"].(type) {
+	case []string:
+		for _, existing := range tags {
+			if existing == tag {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, existing := range tags {
+			if existing == tag {
+				return true
+			}
+		}
+	case string:
+		return tags == tag
+	}
+
+	return false
+}
+
+// SetNested sets a field given by a dotted name, such as "log.file.path", by
+// creating or descending into nested objects for each part of the name
+// Any existing value along the way that is not an object is replaced, so the
+// value always lands at the given name. Existing objects are copied rather
+// than modified as they may be shared with other events, such as when they
+// come from the configuration
+func (e Event) SetNested(name string, value interface{}) {
+	parts := strings.Split(name, ".")
+
+	current := map[string]interface{}(e)
+	for _, part := range parts[:len(parts)-1] {
+		next := make(map[string]interface{})
+		if existing, ok := current[part].(map[string]interface{}); ok {
+			for k, v := range existing {
+				next[k] = v
+			}
+		}
+
+		current[part] = next
+		current = next
+	}
+
+	current[parts[len(parts)-1]] = value
+}
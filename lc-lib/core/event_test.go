@@ -0,0 +1,46 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEventAddTag(t *testing.T) {
+	for _, test := range []struct {
+		tags     interface{}
+		expected interface{}
+	}{
+		{nil, []string{"new"}},
+		{"old", []string{"old", "new"}},
+		{[]string{"old"}, []string{"old", "new"}},
+		{[]interface{}{"old"}, []interface{}{"old", "new"}},
+		{[]string{"new"}, []string{"new"}},
+		{5, []interface{}{5, "new"}},
+		{map[string]interface{}{"old": true}, []interface{}{map[string]interface{}{"old": true}, "new"}},
+	} {
+		event := Event{}
+		if test.tags != nil {
+			event["tags"] = test.tags
+		}
+		event.AddTag("new")
+		if !reflect.DeepEqual(event["tags"], test.expected) {
+			t.Errorf("Unexpected tags after adding to %v: %v (expected %v)", test.tags, event["tags"], test.expected)
+		}
+	}
+}
@@ -22,3 +22,16 @@ import "os"
 type Stream interface {
 	Info() (string, os.FileInfo)
 }
+
+// heartbeatStream is the Stream of heartbeat events
+type heartbeatStream struct {
+	name string
+}
+
+func (s *heartbeatStream) Info() (string, os.FileInfo) {
+	return s.name, nil
+}
+
+// HeartbeatStream is the Stream of heartbeat events. No registrar tracks it, so
+// acknowledging a heartbeat never changes the saved offset of a file or stdin
+var HeartbeatStream Stream = &heartbeatStream{name: "heartbeat"}
@@ -18,6 +18,8 @@ package core
 
 import (
 	"math"
+	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -36,7 +38,11 @@ type ExpBackoff struct {
 	requiresInit bool
 	defaultDelay time.Duration
 	maxDelay     time.Duration
+	jitter       float64
 	expCount     float64
+
+	mutex   sync.Mutex
+	current time.Duration
 }
 
 // NewExpBackoff creates a new ExpBackoff structure with the given default delay
@@ -49,9 +55,26 @@ func NewExpBackoff(name string, defaultDelay time.Duration, maxDelay time.Durati
 	}
 }
 
+// SetJitter sets the fraction, between 0 and 1, by which each delay is
+// randomly reduced, so that many clients failing together do not all retry at
+// the same moment
+func (e *ExpBackoff) SetJitter(jitter float64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.jitter = jitter
+}
+
 // Trigger informs the ExpBackoff that backoff needs to happen and returns the
 // next delay to use
 func (e *ExpBackoff) Trigger() time.Duration {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.current = e.nextDelay()
+	return e.current
+}
+
+func (e *ExpBackoff) nextDelay() time.Duration {
 	if e.requiresInit {
 		e.defaultDelay = DefaultDelay
 	}
@@ -80,12 +103,28 @@ func (e *ExpBackoff) Trigger() time.Duration {
 		nextDelay = e.maxDelay
 	}
 
+	if e.jitter > 0 {
+		nextDelay -= time.Duration(rand.Float64() * e.jitter * float64(nextDelay))
+		log.Debug("[%s] Backoff with jitter: %v", e.name, nextDelay)
+	}
+
 	return nextDelay
 }
 
+// Current returns the delay last returned by Trigger, or 0 if the backoff has
+// been reset since
+func (e *ExpBackoff) Current() time.Duration {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.current
+}
+
 // Reset resets the exponential backoff to default values
 func (e *ExpBackoff) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 	e.expCount = 0.
+	e.current = 0
 }
 
 // CalculateSpeed returns a running average for a speed using variable time
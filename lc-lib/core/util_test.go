@@ -0,0 +1,72 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpBackoff(t *testing.T) {
+	backoff := NewExpBackoff("Test", time.Second, 5*time.Second)
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if result := backoff.Trigger(); result != delay {
+			t.Fatalf("Trigger %d returned %v, expected %v", i, result, delay)
+		}
+		if result := backoff.Current(); result != delay {
+			t.Fatalf("Current after trigger %d returned %v, expected %v", i, result, delay)
+		}
+	}
+
+	backoff.Reset()
+	if result := backoff.Current(); result != 0 {
+		t.Fatalf("Current after reset returned %v, expected 0", result)
+	}
+	if result := backoff.Trigger(); result != time.Second {
+		t.Fatalf("Trigger after reset returned %v, expected %v", result, time.Second)
+	}
+}
+
+func TestExpBackoffZeroDelay(t *testing.T) {
+	backoff := NewExpBackoff("Test", 0, 5*time.Second)
+
+	if result := backoff.Trigger(); result != 0 {
+		t.Fatalf("First trigger returned %v, expected 0", result)
+	}
+	if result := backoff.Trigger(); result != 2*DefaultDelay {
+		t.Fatalf("Second trigger returned %v, expected %v", result, 2*DefaultDelay)
+	}
+}
+
+func TestExpBackoffJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		backoff := NewExpBackoff("Test", 10*time.Second, 20*time.Second)
+		backoff.SetJitter(0.5)
+
+		if result := backoff.Trigger(); result < 5*time.Second || result > 10*time.Second {
+			t.Fatalf("Trigger with jitter returned %v, expected between 5s and 10s", result)
+		}
+		if result := backoff.Trigger(); result < 10*time.Second || result > 20*time.Second {
+			t.Fatalf("Second trigger with jitter returned %v, expected between 10s and 20s", result)
+		}
+		if result := backoff.Trigger(); result < 10*time.Second || result > 20*time.Second {
+			t.Fatalf("Trigger at max with jitter returned %v, expected between 10s and 20s", result)
+		}
+	}
+}
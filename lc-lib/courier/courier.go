@@ -0,0 +1,332 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package courier
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/harvester"
+	"github.com/driskell/log-courier/lc-lib/prospector"
+	"github.com/driskell/log-courier/lc-lib/publisher"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+	"github.com/driskell/log-courier/lc-lib/spooler"
+)
+
+import _ "github.com/driskell/log-courier/lc-lib/codecs"
+import _ "github.com/driskell/log-courier/lc-lib/transports/gelf"
+import _ "github.com/driskell/log-courier/lc-lib/transports/tcp"
+
+const (
+	// eventChanSize is how many events are held for the embedding application
+	// before further events are discarded
+	eventChanSize = 16
+)
+
+// EventType identifies the kind of an Event
+type EventType int
+
+const (
+	// EventStarted is sent once the pipeline is running
+	EventStarted EventType = iota
+	// EventReloaded is sent when a new configuration has been applied
+	EventReloaded
+	// EventFinished is sent when reading from stdin has completed and all of its
+	// events have been acknowledged, after which the Courier stops by itself.
+	// Err is set if reading ended due to an error
+	EventFinished
+	// EventStopped is sent once shutdown has completed, immediately before the
+	// events channel is closed
+	EventStopped
+)
+
+// Event is a status or error event from a Courier
+type Event struct {
+	Type EventType
+	Err  error
+}
+
+// Courier runs the Log Courier pipeline of prospector, spooler and publisher
+// for a loaded configuration, so that it can be embedded within another
+// application as well as run by the log-courier binary
+type Courier struct {
+	// FromBeginning causes files found on the first run, with no previous state,
+	// to be read from the beginning instead of the end. Set before Start
+	FromBeginning bool
+
+	// ReloadFunc is called when a configuration reload is requested through the
+	// admin REST interface, and should load the configuration and pass it to
+	// Reload. If nil the request fails. Set before Start
+	ReloadFunc func() error
+
+	mutex     sync.Mutex
+	config    *config.Config
+	pipeline  *core.Pipeline
+	harvester *harvester.Harvester
+	spooler   *spooler.Spooler
+	registrar registrar.Registrator
+	started   bool
+	stopped   bool
+
+	eventChan chan *Event
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+	doneChan  chan struct{}
+}
+
+// New creates a Courier for the given configuration, which must have been
+// loaded with its factories initialised
+func New(cfg *config.Config) (*Courier, error) {
+	if cfg == nil {
+		return nil, errors.New("A configuration is required")
+	}
+
+	if len(cfg.Networks) == 0 {
+		return nil, errors.New("The configuration has not been loaded")
+	}
+
+	for _, network := range cfg.Networks {
+		if network.Factory == nil {
+			return nil, errors.New("The configuration must be loaded with its factories initialised")
+		}
+	}
+
+	return &Courier{
+		config:    cfg,
+		pipeline:  core.NewPipeline(),
+		eventChan: make(chan *Event, eventChanSize),
+		stopChan:  make(chan struct{}),
+		doneChan:  make(chan struct{}),
+	}, nil
+}
+
+// Config returns the configuration currently in use
+func (c *Courier) Config() *config.Config {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.config
+}
+
+// Events returns the channel on which status and error events are sent. Events
+// are discarded if the channel is full, and it is closed once the Courier has
+// stopped
+func (c *Courier) Events() <-chan *Event {
+	return c.eventChan
+}
+
+// Start constructs and starts the pipeline. If it fails the Courier can not be
+// started again
+func (c *Courier) Start() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.started {
+		return errors.New("Log Courier has already been started")
+	}
+	c.started = true
+
+	log.Info("Log Courier version %s pipeline starting", core.LogCourierVersion)
+
+	// If reading from stdin, skip admin, and set up a null registrar
+	if c.config.ReadStdin {
+		c.registrar = newStdinRegistrar(c.pipeline)
+	} else {
+		// Admin must be created before the other segments as they register their
+		// API entries with it during construction
+		if c.config.Get("admin").(*admin.Config).Enabled {
+			if _, err := admin.NewServer(c.pipeline, c.config, c.adminReload); err != nil {
+				c.teardown()
+				return err
+			}
+		}
+
+		c.registrar = registrar.NewRegistrar(c.pipeline, &c.config.General)
+	}
+
+	// Fan out to a publisher for each network if there is more than one
+	var publisherImp publisher.Connector
+	if len(c.config.Networks) == 1 {
+		publisherImp = publisher.NewPublisher(c.pipeline, c.config, 0, c.registrar)
+	} else {
+		publisherImp = publisher.NewFanout(c.pipeline, c.config, c.registrar)
+	}
+
+	c.spooler = spooler.NewSpooler(c.pipeline, c.config, publisherImp)
+
+	// If reading from stdin, don't start prospector, directly start a harvester
+	var harvesterWait <-chan *harvester.FinishStatus
+	if c.config.ReadStdin {
+		c.harvester = harvester.NewHarvester(nil, c.config, &c.config.Stdin, 0)
+		c.harvester.Start(c.spooler.Connect())
+		harvesterWait = c.harvester.OnFinish()
+	} else {
+		// Platform specific inputs may need to intercept the loading of previous
+		// state from the registrar
+		registrarImp := c.startPlatformInputs(c.registrar, c.spooler)
+
+		if _, err := prospector.NewProspector(c.pipeline, c.config, c.FromBeginning, registrarImp, c.spooler); err != nil {
+			c.teardown()
+			return err
+		}
+	}
+
+	// Start the pipeline
+	c.pipeline.Start()
+
+	log.Notice("Pipeline ready")
+
+	c.sendEvent(&Event{Type: EventStarted})
+
+	go c.run(harvesterWait)
+
+	return nil
+}
+
+// teardown shuts down the segments already registered on the pipeline when
+// Start fails partway through, leaving the courier stopped
+func (c *Courier) teardown() {
+	c.stopped = true
+
+	c.pipeline.Start()
+	c.pipeline.Shutdown()
+	c.pipeline.Wait()
+
+	close(c.eventChan)
+	close(c.doneChan)
+}
+
+// adminReload handles a configuration reload request from the admin REST
+// interface
+func (c *Courier) adminReload() error {
+	if c.ReloadFunc == nil {
+		return errors.New("Configuration reload is not available")
+	}
+
+	return c.ReloadFunc()
+}
+
+// Reload validates the given configuration and passes it to all running
+// routines in the pipeline that are subscribed to it, so they may update their
+// runtime configuration. The previous configuration remains in use if it fails
+func (c *Courier) Reload(cfg *config.Config) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.started || c.stopped {
+		return errors.New("Log Courier is not running")
+	}
+
+	// Publishers are created at startup for each network, so the number of
+	// networks can not change
+	if len(cfg.Networks) != len(c.config.Networks) {
+		return fmt.Errorf("The number of network configurations can not be changed by a configuration reload")
+	}
+
+	// The stdin harvester or the prospector is chosen at startup
+	if cfg.ReadStdin != c.config.ReadStdin {
+		return fmt.Errorf("The stdin path \"-\" can not be added or removed by a configuration reload")
+	}
+
+	log.Notice("Configuration reload successful")
+
+	c.config = cfg
+	c.pipeline.SendConfig(cfg)
+
+	c.sendEvent(&Event{Type: EventReloaded})
+
+	return nil
+}
+
+// Stop initiates a clean shutdown, waiting for the pipeline to finish. It is
+// safe to call more than once, and returns immediately if never started
+func (c *Courier) Stop() {
+	c.mutex.Lock()
+	started := c.started
+	c.mutex.Unlock()
+
+	if !started {
+		return
+	}
+
+	c.stopOnce.Do(func() {
+		close(c.stopChan)
+	})
+
+	<-c.doneChan
+}
+
+// run waits for a stop request, or for reading from stdin to complete, and
+// then shuts down the pipeline
+func (c *Courier) run(harvesterWait <-chan *harvester.FinishStatus) {
+	select {
+	case <-c.stopChan:
+	case finished := <-harvesterWait:
+		if finished.Error != nil {
+			log.Notice("An error occurred reading from stdin at offset %d: %s", finished.LastReadOffset, finished.Error)
+		} else {
+			log.Notice("Finished reading from stdin at offset %d", finished.LastReadOffset)
+		}
+		c.harvester = nil
+
+		// Flush the spooler
+		c.spooler.Flush()
+
+		// Wait for StdinRegistrar to receive ACK for the last event we sent,
+		// which is behind the last event offset if the last lines were filtered
+		// or left out of the sample
+		c.registrar.(*StdinRegistrar).Wait(finished.LastSentOffset)
+
+		c.sendEvent(&Event{Type: EventFinished, Err: finished.Error})
+	}
+
+	c.shutdown()
+
+	c.sendEvent(&Event{Type: EventStopped})
+	close(c.eventChan)
+	close(c.doneChan)
+}
+
+// shutdown stops the stdin harvester if it is still running and shuts down the
+// pipeline, waiting for it to drain
+func (c *Courier) shutdown() {
+	c.mutex.Lock()
+	c.stopped = true
+	c.mutex.Unlock()
+
+	log.Notice("Initiating shutdown")
+
+	if c.harvester != nil {
+		c.harvester.Stop()
+		finished := <-c.harvester.OnFinish()
+		log.Notice("Aborted reading from stdin at offset %d", finished.LastReadOffset)
+	}
+
+	c.pipeline.Shutdown()
+	c.pipeline.Wait()
+}
+
+// sendEvent sends an event to the embedding application without blocking
+func (c *Courier) sendEvent(event *Event) {
+	select {
+	case c.eventChan <- event:
+	default:
+	}
+}
@@ -0,0 +1,30 @@
+// +build !windows
+
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package courier
+
+import (
+	"github.com/driskell/log-courier/lc-lib/registrar"
+	"github.com/driskell/log-courier/lc-lib/spooler"
+)
+
+// startPlatformInputs starts any platform specific inputs, of which there are
+// none on this platform, returning the registrar the prospector should use
+func (c *Courier) startPlatformInputs(registrarImp registrar.Registrator, spoolerImp *spooler.Spooler) registrar.Registrator {
+	return registrarImp
+}
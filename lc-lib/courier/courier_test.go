@@ -0,0 +1,232 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package courier
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+func loadTestConfig(t *testing.T, dir string, network string) *config.Config {
+	return loadTestConfigSections(t, dir, fmt.Sprintf(`"network": %s`, network))
+}
+
+func loadTestConfigSections(t *testing.T, dir string, sections string) *config.Config {
+	path := filepath.Join(dir, "test.json")
+	content := fmt.Sprintf(`{
+		"general": { "persist directory": %q },
+		%s,
+		"files": [ { "paths": [ %q ] } ]
+	}`, dir, sections, filepath.Join(dir, "*.log"))
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write configuration: %s", err)
+	}
+
+	cfg := config.NewConfig()
+	if err := cfg.Load(path, true); err != nil {
+		t.Fatalf("Failed to load configuration: %s", err)
+	}
+
+	return cfg
+}
+
+func testNetwork(server string) string {
+	return fmt.Sprintf(`{ "transport": "tcp", "servers": [ %q ] }`, server)
+}
+
+func createTestCourier(t *testing.T) (*Courier, string, string, func()) {
+	dir, err := ioutil.TempDir("", "logcouriertest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	server := listener.Addr().String()
+	courier, err := New(loadTestConfig(t, dir, testNetwork(server)))
+	if err != nil {
+		listener.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	return courier, dir, server, func() {
+		courier.Stop()
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func waitCourierEvent(t *testing.T, eventChan <-chan *Event, eventType EventType) {
+	select {
+	case event := <-eventChan:
+		if event == nil || event.Type != eventType {
+			t.Fatalf("Unexpected event: %v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for event %d", eventType)
+	}
+}
+
+func TestCourierNew(t *testing.T) {
+	if _, err := New(nil); err == nil {
+		t.Error("Courier created without a configuration")
+	}
+
+	if _, err := New(config.NewConfig()); err == nil {
+		t.Error("Courier created with a configuration that was not loaded")
+	}
+}
+
+func TestCourierStartStop(t *testing.T) {
+	courier, _, _, cleanup := createTestCourier(t)
+	defer cleanup()
+
+	// Stopping a courier that was never started does nothing
+	courier.Stop()
+
+	if err := courier.Start(); err != nil {
+		t.Fatalf("Failed to start: %s", err)
+	}
+
+	if err := courier.Start(); err == nil {
+		t.Error("Courier started twice")
+	}
+
+	eventChan := courier.Events()
+	waitCourierEvent(t, eventChan, EventStarted)
+
+	courier.Stop()
+	waitCourierEvent(t, eventChan, EventStopped)
+
+	if _, ok := <-eventChan; ok {
+		t.Error("Events channel was not closed after stopping")
+	}
+
+	// Stopping again returns immediately
+	courier.Stop()
+
+	if err := courier.Reload(courier.Config()); err == nil {
+		t.Error("Configuration reloaded after stopping")
+	}
+}
+
+func TestCourierStartAdmin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcouriertest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sections := fmt.Sprintf(`"network": %s, "admin": { "enabled": true, "listen address": "tcp:127.0.0.1:0" }`, testNetwork("127.0.0.1:1"))
+	courier, err := New(loadTestConfigSections(t, dir, sections))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if err := courier.Start(); err != nil {
+		t.Fatalf("Failed to start with admin enabled: %s", err)
+	}
+
+	eventChan := courier.Events()
+	waitCourierEvent(t, eventChan, EventStarted)
+
+	courier.Stop()
+	waitCourierEvent(t, eventChan, EventStopped)
+}
+
+func TestCourierStartFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcouriertest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A directory in place of the state file can not be loaded
+	if err := os.Mkdir(filepath.Join(dir, ".log-courier"), 0700); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+
+	courier, err := New(loadTestConfig(t, dir, testNetwork("127.0.0.1:1")))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- courier.Start()
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Courier started with an unreadable state file")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the partially started pipeline to shut down")
+	}
+
+	if _, ok := <-courier.Events(); ok {
+		t.Error("Events channel was not closed after a failed start")
+	}
+
+	// Stopping after a failed start returns immediately
+	courier.Stop()
+}
+
+func TestCourierReload(t *testing.T) {
+	courier, dir, server, cleanup := createTestCourier(t)
+	defer cleanup()
+
+	if err := courier.Start(); err != nil {
+		t.Fatalf("Failed to start: %s", err)
+	}
+
+	eventChan := courier.Events()
+	waitCourierEvent(t, eventChan, EventStarted)
+
+	newConfig := loadTestConfig(t, dir, testNetwork(server))
+	if err := courier.Reload(newConfig); err != nil {
+		t.Fatalf("Failed to reload: %s", err)
+	}
+	waitCourierEvent(t, eventChan, EventReloaded)
+
+	if courier.Config() != newConfig {
+		t.Error("Reloaded configuration was not applied")
+	}
+
+	// The number of networks is fixed at startup
+	badConfig := loadTestConfig(t, dir, "[ "+testNetwork(server)+", "+testNetwork("127.0.0.1:1")+" ]")
+	if err := courier.Reload(badConfig); err == nil {
+		t.Error("Configuration with an additional network was reloaded")
+	}
+
+	if courier.Config() != newConfig {
+		t.Error("Failed reload replaced the configuration")
+	}
+}
@@ -0,0 +1,35 @@
+// +build windows
+
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package courier
+
+import (
+	"github.com/driskell/log-courier/lc-lib/eventlog"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+	"github.com/driskell/log-courier/lc-lib/spooler"
+)
+
+// startPlatformInputs starts the event log input if any channels are
+// configured, returning the registrar the prospector should use
+func (c *Courier) startPlatformInputs(registrarImp registrar.Registrator, spoolerImp *spooler.Spooler) registrar.Registrator {
+	if len(c.config.Get("eventlog").(*eventlog.Config).Channels) == 0 {
+		return registrarImp
+	}
+
+	return eventlog.NewInput(c.pipeline, c.config, registrarImp, spoolerImp).Registrator()
+}
@@ -17,7 +17,7 @@
  * limitations under the License.
  */
 
-package main
+package courier
 
 import (
 	"github.com/driskell/log-courier/lc-lib/core"
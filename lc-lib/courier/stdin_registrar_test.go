@@ -17,11 +17,13 @@
  * limitations under the License.
  */
 
-package main
+package courier
 
 import (
+	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
 	"github.com/driskell/log-courier/lc-lib/registrar"
+	"github.com/driskell/log-courier/lc-lib/spooler"
 	"testing"
 	"time"
 )
@@ -76,3 +78,56 @@ func TestStdinRegistrarWait(t *testing.T) {
 		t.Error("Wait offset was incorrect: ", r.wait_offset)
 	}
 }
+
+type testStdinConnector struct {
+	output chan []*core.EventDescriptor
+}
+
+func (c *testStdinConnector) Connect() chan<- []*core.EventDescriptor {
+	return c.output
+}
+
+func TestStdinRegistrarHeartbeat(t *testing.T) {
+	p, r := newTestStdinRegistrar()
+
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	cfg.General.HeartbeatInterval = 100 * time.Millisecond
+	connector := &testStdinConnector{output: make(chan []*core.EventDescriptor, 10)}
+	spooler.NewSpooler(p, cfg, connector)
+
+	p.Start()
+
+	var heartbeat []*core.EventDescriptor
+	select {
+	case heartbeat = <-connector.output:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for heartbeat")
+	}
+
+	// A heartbeat acknowledged after the last stdin event must not rewind the
+	// offset being waited for
+	c := r.Connect()
+	c.Add(registrar.NewAckEvent(append(newEventSpool(13), heartbeat...)))
+	c.Send()
+
+	wait := make(chan int)
+	go func() {
+		r.Wait(13)
+		wait <- 1
+	}()
+
+	select {
+	case <-wait:
+		break
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for stdin registrar to reach end of stdin")
+	}
+
+	if r.last_offset != 13 {
+		t.Error("Last offset was incorrect: ", r.last_offset)
+	}
+
+	p.Shutdown()
+	p.Wait()
+}
@@ -17,9 +17,11 @@
 package endpoint
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/transports"
 )
 
 type apiEndpoint struct {
@@ -36,6 +38,16 @@ func (a *apiEndpoint) Update() error {
 	a.SetEntry("pendingPayloads", admin.APINumber(a.e.NumPending()))
 	a.SetEntry("publishedLines", admin.APINumber(a.e.LineCount()))
 	a.SetEntry("averageLatency", admin.APIFloat(a.e.AverageLatency()/time.Millisecond))
+	if nonce, sequence := a.e.LastAck(); nonce == nil {
+		a.SetEntry("lastAcknowledgedPayload", admin.APINull)
+		a.SetEntry("lastAcknowledgedSequence", admin.APINull)
+	} else {
+		a.SetEntry("lastAcknowledgedPayload", admin.APIString(fmt.Sprintf("%x", *nonce)))
+		a.SetEntry("lastAcknowledgedSequence", admin.APINumber(sequence))
+	}
+	if reporter, ok := a.e.transport.(transports.ReconnectReporter); ok {
+		a.SetEntry("reconnectBackoff", admin.APIFloat(reporter.ReconnectBackoff().Seconds()))
+	}
 	a.e.mutex.RUnlock()
 
 	return nil
@@ -59,6 +59,8 @@ type Endpoint struct {
 	pongPending     bool
 
 	lineCount         int64
+	lastAckNonce      *string
+	lastAckSequence   uint32
 	averageLatency    float64
 	transmissionStart time.Time
 	estDelTime        time.Time
@@ -220,6 +222,14 @@ func (e *Endpoint) LineCount() int64 {
 	return e.lineCount
 }
 
+// LastAck returns the nonce of the payload and the sequence of the last
+// acknowledgement received, which is nil if there has not yet been one
+func (e *Endpoint) LastAck() (*string, uint32) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.lastAckNonce, e.lastAckSequence
+}
+
 // processAck processes a received acknowledgement message.
 // This will pass the payload that was acked, and whether this is the first
 // acknoweldgement or a later one, to the observer
@@ -241,11 +251,14 @@ func (e *Endpoint) processAck(ack *transports.AckEvent, observer Observer) bool
 	// Process ACK
 	lineCount, complete := payload.Ack(int(ack.Sequence()))
 
+	nonce := ack.Nonce()
+
 	if complete {
 		// No more events left for this payload, remove from pending list
 		delete(e.pendingPayloads, ack.Nonce())
 
 		e.mutex.Lock()
+		e.lastAckNonce, e.lastAckSequence = &nonce, ack.Sequence()
 		e.lineCount += int64(lineCount)
 		e.numPayloads--
 
@@ -273,6 +286,7 @@ func (e *Endpoint) processAck(ack *transports.AckEvent, observer Observer) bool
 		e.backoff.Reset()
 	} else {
 		e.mutex.Lock()
+		e.lastAckNonce, e.lastAckSequence = &nonce, ack.Sequence()
 		e.lineCount += int64(lineCount)
 		e.mutex.Unlock()
 	}
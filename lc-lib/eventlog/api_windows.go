@@ -0,0 +1,289 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventlog
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	eventlogSeekRead     = 0x0002
+	eventlogForwardsRead = 0x0004
+
+	formatMessageFromHmodule    = 0x00000800
+	formatMessageArgumentArray  = 0x00002000
+	loadLibraryAsDatafile       = 0x00000002
+	maxMessageInsertionStrings  = 99
+	maxFormattedMessageLength   = 32768
+	eventLogRegistryKey         = `SYSTEM\CurrentControlSet\Services\EventLog\`
+	eventMessageFileRegistryKey = "EventMessageFile"
+
+	errorHandleEOF          syscall.Errno = 38
+	errorInsufficientBuffer syscall.Errno = 122
+)
+
+var (
+	modadvapi32                    = syscall.NewLazyDLL("advapi32.dll")
+	procOpenEventLogW              = modadvapi32.NewProc("OpenEventLogW")
+	procCloseEventLog              = modadvapi32.NewProc("CloseEventLog")
+	procReadEventLogW              = modadvapi32.NewProc("ReadEventLogW")
+	procGetOldestEventLogRecord    = modadvapi32.NewProc("GetOldestEventLogRecord")
+	procGetNumberOfEventLogRecords = modadvapi32.NewProc("GetNumberOfEventLogRecords")
+
+	modkernel32                   = syscall.NewLazyDLL("kernel32.dll")
+	procLoadLibraryExW            = modkernel32.NewProc("LoadLibraryExW")
+	procFormatMessageW            = modkernel32.NewProc("FormatMessageW")
+	procExpandEnvironmentStringsW = modkernel32.NewProc("ExpandEnvironmentStringsW")
+)
+
+// openEventLog opens the event log channel with the given name
+func openEventLog(channel string) (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(channel)
+	if err != nil {
+		return 0, err
+	}
+
+	r1, _, err := procOpenEventLogW.Call(0, uintptr(unsafe.Pointer(name)))
+	if r1 == 0 {
+		return 0, err
+	}
+
+	return syscall.Handle(r1), nil
+}
+
+// closeEventLog closes a handle returned by openEventLog
+func closeEventLog(handle syscall.Handle) {
+	procCloseEventLog.Call(uintptr(handle))
+}
+
+// eventLogRange returns the oldest record number in the event log and the
+// number of records it contains
+func eventLogRange(handle syscall.Handle) (uint32, uint32, error) {
+	var oldest, count uint32
+
+	if r1, _, err := procGetOldestEventLogRecord.Call(uintptr(handle), uintptr(unsafe.Pointer(&oldest))); r1 == 0 {
+		return 0, 0, err
+	}
+
+	if r1, _, err := procGetNumberOfEventLogRecords.Call(uintptr(handle), uintptr(unsafe.Pointer(&count))); r1 == 0 {
+		return 0, 0, err
+	}
+
+	return oldest, count, nil
+}
+
+// readEventLog reads as many records as fit in the buffer, starting with the
+// given record number. It returns the number of bytes read, which is 0 if there
+// are no more records. If the buffer is too small for the next record the
+// buffer is grown
+func readEventLog(handle syscall.Handle, recordNumber uint32, buffer *[]byte) (int, error) {
+	for {
+		var read, needed uint32
+
+		r1, _, err := procReadEventLogW.Call(
+			uintptr(handle),
+			eventlogSeekRead|eventlogForwardsRead,
+			uintptr(recordNumber),
+			uintptr(unsafe.Pointer(&(*buffer)[0])),
+			uintptr(len(*buffer)),
+			uintptr(unsafe.Pointer(&read)),
+			uintptr(unsafe.Pointer(&needed)),
+		)
+		if r1 != 0 {
+			return int(read), nil
+		}
+
+		if err == errorHandleEOF {
+			return 0, nil
+		}
+
+		if err == errorInsufficientBuffer && int(needed) > len(*buffer) {
+			*buffer = make([]byte, needed)
+			continue
+		}
+
+		return 0, err
+	}
+}
+
+// messageFormatter formats the messages of event log records using the
+// message files registered by their sources, caching the loaded modules
+type messageFormatter struct {
+	channel string
+	files   map[string][]string
+	modules map[string]syscall.Handle
+}
+
+// newMessageFormatter creates a messageFormatter for the given channel
+func newMessageFormatter(channel string) *messageFormatter {
+	return &messageFormatter{
+		channel: channel,
+		files:   make(map[string][]string),
+		modules: make(map[string]syscall.Handle),
+	}
+}
+
+// Format returns the formatted message for the record, falling back to the
+// insertion strings of the record if the message is not available
+func (f *messageFormatter) Format(record *Record) string {
+	// Pad the insertion strings so that a message referencing more than the
+	// record provides can not read beyond the argument array
+	args := make([]*uint16, maxMessageInsertionStrings)
+	empty, _ := syscall.UTF16PtrFromString("")
+	for i := range args {
+		args[i] = empty
+	}
+	for i, str := range record.Strings {
+		if i >= len(args) {
+			break
+		}
+		if ptr, err := syscall.UTF16PtrFromString(str); err == nil {
+			args[i] = ptr
+		}
+	}
+
+	buffer := make([]uint16, maxFormattedMessageLength)
+	for _, file := range f.messageFiles(record.Source) {
+		module := f.module(file)
+		if module == 0 {
+			continue
+		}
+
+		r1, _, _ := procFormatMessageW.Call(
+			formatMessageFromHmodule|formatMessageArgumentArray,
+			uintptr(module),
+			uintptr(record.EventID),
+			0,
+			uintptr(unsafe.Pointer(&buffer[0])),
+			uintptr(len(buffer)),
+			uintptr(unsafe.Pointer(&args[0])),
+		)
+		if r1 != 0 {
+			return strings.TrimRight(syscall.UTF16ToString(buffer[:r1]), "\r\n")
+		}
+	}
+
+	return record.InsertionMessage()
+}
+
+// Close frees the loaded message files
+func (f *messageFormatter) Close() {
+	for _, module := range f.modules {
+		if module != 0 {
+			syscall.FreeLibrary(module)
+		}
+	}
+	f.modules = make(map[string]syscall.Handle)
+}
+
+// messageFiles returns the message files registered for the given source
+func (f *messageFormatter) messageFiles(source string) []string {
+	if files, ok := f.files[source]; ok {
+		return files
+	}
+
+	var files []string
+	if value, err := readRegistryString(eventLogRegistryKey+f.channel+`\`+source, eventMessageFileRegistryKey); err == nil {
+		for _, file := range strings.Split(value, ";") {
+			if file = strings.TrimSpace(file); file != "" {
+				files = append(files, expandEnvironment(file))
+			}
+		}
+	} else {
+		log.Debug("No message file for event log source %s in %s: %s", source, f.channel, err)
+	}
+
+	f.files[source] = files
+	return files
+}
+
+// module returns the loaded module for the given message file, loading it if
+// necessary. It returns 0 if the module could not be loaded
+func (f *messageFormatter) module(file string) syscall.Handle {
+	if module, ok := f.modules[file]; ok {
+		return module
+	}
+
+	var module syscall.Handle
+	if name, err := syscall.UTF16PtrFromString(file); err == nil {
+		r1, _, err := procLoadLibraryExW.Call(uintptr(unsafe.Pointer(name)), 0, loadLibraryAsDatafile)
+		if r1 == 0 {
+			log.Warning("Failed to load event log message file %s: %s", file, err)
+		}
+		module = syscall.Handle(r1)
+	}
+
+	f.modules[file] = module
+	return module
+}
+
+// readRegistryString reads a string value from the local machine registry
+func readRegistryString(path string, name string) (string, error) {
+	pathp, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+
+	namep, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return "", err
+	}
+
+	var key syscall.Handle
+	if err = syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, pathp, 0, syscall.KEY_READ, &key); err != nil {
+		return "", err
+	}
+	defer syscall.RegCloseKey(key)
+
+	var valueType, length uint32
+	if err = syscall.RegQueryValueEx(key, namep, nil, &valueType, nil, &length); err != nil {
+		return "", err
+	}
+
+	if length == 0 {
+		return "", nil
+	}
+
+	buffer := make([]uint16, (length+1)/2)
+	if err = syscall.RegQueryValueEx(key, namep, nil, &valueType, (*byte)(unsafe.Pointer(&buffer[0])), &length); err != nil {
+		return "", err
+	}
+
+	return syscall.UTF16ToString(buffer), nil
+}
+
+// expandEnvironment expands %VARIABLE% references in a registry string
+func expandEnvironment(value string) string {
+	src, err := syscall.UTF16PtrFromString(value)
+	if err != nil {
+		return value
+	}
+
+	buffer := make([]uint16, syscall.MAX_PATH)
+	for {
+		r1, _, _ := procExpandEnvironmentStringsW.Call(uintptr(unsafe.Pointer(src)), uintptr(unsafe.Pointer(&buffer[0])), uintptr(len(buffer)))
+		if r1 == 0 {
+			return value
+		}
+		if int(r1) <= len(buffer) {
+			return syscall.UTF16ToString(buffer[:r1])
+		}
+		buffer = make([]uint16, r1)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventlog
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultPollInterval  time.Duration = 5 * time.Second
+	defaultStartPosition string        = "end"
+)
+
+// Config holds the configuration for reading the Windows event log
+type Config struct {
+	Channels      []string      `config:"channels"`
+	PollInterval  time.Duration `config:"poll interval"`
+	StartPosition string        `config:"start position"`
+}
+
+// InitDefaults initialises default values
+func (c *Config) InitDefaults() {
+	c.PollInterval = defaultPollInterval
+	c.StartPosition = defaultStartPosition
+}
+
+// Validate validates the config structure
+func (c *Config) Validate() (err error) {
+	channels := make(map[string]bool)
+	for _, channel := range c.Channels {
+		if channel == "" {
+			return fmt.Errorf("/eventlog/channels can not contain an empty channel name")
+		}
+		if _, exists := channels[channel]; exists {
+			return fmt.Errorf("The list of channels (/eventlog/channels) must be unique: %s appears multiple times", channel)
+		}
+		channels[channel] = true
+	}
+
+	if c.PollInterval <= 0 {
+		return fmt.Errorf("/eventlog/poll interval must be greater than 0")
+	}
+
+	if c.StartPosition != "beginning" && c.StartPosition != "end" {
+		return fmt.Errorf("The start position (/eventlog/start position) is not recognised: %s", c.StartPosition)
+	}
+
+	return nil
+}
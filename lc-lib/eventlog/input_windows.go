@@ -0,0 +1,359 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventlog
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+	"github.com/driskell/log-courier/lc-lib/spooler"
+)
+
+const (
+	// sourcePrefix prefixes the channel name to form the source saved for it
+	// in the registrar
+	sourcePrefix = "eventlog:"
+
+	// readBufferSize is the initial size of the buffer used to read records
+	readBufferSize = 65536
+)
+
+// channelStream holds the state of a single event log channel, and is the
+// stream the registrar associates with the channel's saved record number
+type channelStream struct {
+	channel   string
+	source    string
+	handle    syscall.Handle
+	formatter *messageFormatter
+
+	// next is the record number to read next, or 0 to start at the oldest
+	next  uint32
+	known bool
+}
+
+// Info returns the registrar source for the channel. There is no file
+// information for an event log channel
+func (s *channelStream) Info() (string, os.FileInfo) {
+	return s.source, nil
+}
+
+// close closes the event log handle and frees the loaded message files
+func (s *channelStream) close() {
+	if s.handle != 0 {
+		closeEventLog(s.handle)
+		s.handle = 0
+	}
+
+	if s.formatter != nil {
+		s.formatter.Close()
+		s.formatter = nil
+	}
+}
+
+// Input reads records from Windows event log channels and sends them into the
+// pipeline. The number of the last acknowledged record of each channel is saved
+// by the registrar as its offset so that reading resumes after a restart
+type Input struct {
+	core.PipelineSegment
+	core.PipelineConfigReceiver
+
+	config         *Config
+	genConfig      *config.General
+	registrar      registrar.Registrator
+	registrarSpool registrar.EventSpooler
+	output         chan<- *core.EventDescriptor
+	streams        map[string]*channelStream
+	buffer         []byte
+}
+
+// NewInput creates a new event log input for the channels in the given
+// configuration
+func NewInput(pipeline *core.Pipeline, config *config.Config, registrarImp registrar.Registrator, spoolerImp *spooler.Spooler) *Input {
+	ret := &Input{
+		config:         config.Get("eventlog").(*Config),
+		genConfig:      &config.General,
+		registrar:      registrarImp,
+		registrarSpool: registrarImp.Connect(),
+		output:         spoolerImp.Connect(),
+		streams:        make(map[string]*channelStream),
+		buffer:         make([]byte, readBufferSize),
+	}
+
+	for _, channel := range ret.config.Channels {
+		ret.streams[sourcePrefix+channel] = &channelStream{
+			channel: channel,
+			source:  sourcePrefix + channel,
+		}
+	}
+
+	pipeline.Register(ret)
+
+	return ret
+}
+
+// Registrator returns a registrar.Registrator that, when previous state is
+// loaded, passes the saved event log channels to the input and all other
+// entries to the caller's callback. It must be used in place of the original
+// registrar by the prospector
+func (i *Input) Registrator() registrar.Registrator {
+	return &inputRegistrator{
+		Registrator: i.registrar,
+		input:       i,
+	}
+}
+
+// inputRegistrator intercepts the loading of previous state from a registrar
+type inputRegistrator struct {
+	registrar.Registrator
+
+	input *Input
+}
+
+// LoadPrevious loads the previous state, handling event log channels itself
+func (r *inputRegistrator) LoadPrevious(callback registrar.LoadPreviousFunc) (bool, error) {
+	return r.Registrator.LoadPrevious(func(source string, state *registrar.FileState) (core.Stream, error) {
+		if strings.HasPrefix(source, sourcePrefix) {
+			return r.input.loadCallback(source, state), nil
+		}
+		return callback(source, state)
+	})
+}
+
+// loadCallback restores the saved record number of a channel. Channels that
+// are no longer configured keep their state so that they resume if they are
+// configured again
+func (i *Input) loadCallback(source string, state *registrar.FileState) core.Stream {
+	stream, ok := i.streams[source]
+	if !ok {
+		stream = &channelStream{
+			channel: strings.TrimPrefix(source, sourcePrefix),
+			source:  source,
+		}
+		i.streams[source] = stream
+	}
+
+	stream.known = true
+	if state.Offset > 0 {
+		stream.next = uint32(state.Offset) + 1
+	}
+
+	return stream
+}
+
+// Run polls the event log channels until shutdown
+func (i *Input) Run() {
+	defer func() {
+		i.Done()
+	}()
+
+	defer func() {
+		for _, stream := range i.streams {
+			stream.close()
+		}
+
+		i.registrarSpool.Send()
+		i.registrarSpool.Close()
+
+		log.Info("Event log input exiting")
+	}()
+
+	for {
+		for _, channel := range i.config.Channels {
+			if stream, ok := i.streams[sourcePrefix+channel]; ok {
+				if !i.poll(stream) {
+					return
+				}
+			}
+		}
+
+		i.registrarSpool.Send()
+
+		select {
+		case <-time.After(i.config.PollInterval):
+		case <-i.OnShutdown():
+			return
+		case config := <-i.OnConfig():
+			i.reloadConfig(config)
+		}
+	}
+}
+
+// reloadConfig applies a new configuration. Changes to the list of channels
+// require a restart
+func (i *Input) reloadConfig(config *config.Config) {
+	newConfig := config.Get("eventlog").(*Config)
+
+	if strings.Join(newConfig.Channels, "\x00") != strings.Join(i.config.Channels, "\x00") {
+		log.Warning("Configuration reload can not change /eventlog/channels, a restart is required")
+		newConfig.Channels = i.config.Channels
+	}
+
+	i.config = newConfig
+	i.genConfig = &config.General
+}
+
+// poll reads all new records from the channel. It returns false if shutdown
+// was requested
+func (i *Input) poll(stream *channelStream) bool {
+	if stream.handle == 0 {
+		handle, err := openEventLog(stream.channel)
+		if err != nil {
+			log.Warning("Failed to open event log %s: %s", stream.channel, err)
+			return true
+		}
+
+		stream.handle = handle
+		stream.formatter = newMessageFormatter(stream.channel)
+	}
+
+	oldest, count, err := eventLogRange(stream.handle)
+	if err != nil {
+		log.Warning("Failed to read event log %s: %s", stream.channel, err)
+		stream.close()
+		return true
+	}
+
+	newest := oldest + count - 1
+
+	if !stream.known {
+		// Treat an empty channel in the same way as starting from the beginning so
+		// that nothing written to it before the next poll is missed
+		if i.config.StartPosition == "end" && count != 0 {
+			stream.next = newest + 1
+		}
+
+		// The offset saved is the last record read, so 0 if starting at the oldest
+		var offset int64
+		if stream.next != 0 {
+			offset = int64(stream.next) - 1
+			log.Info("Started reading event log %s at record %d", stream.channel, stream.next)
+		} else {
+			log.Info("Started reading event log %s at the oldest record", stream.channel)
+		}
+
+		i.registrarSpool.Add(registrar.NewDiscoverEvent(stream, stream.source, offset, nil))
+		stream.known = true
+	}
+
+	if count == 0 {
+		return true
+	}
+
+	if stream.next == 0 {
+		stream.next = oldest
+	} else if stream.next > newest+1 {
+		log.Warning("Event log %s appears to have been cleared, reading from the oldest record", stream.channel)
+		stream.next = oldest
+	} else if stream.next < oldest {
+		log.Warning("%d records in event log %s were overwritten before they could be read", oldest-stream.next, stream.channel)
+		stream.next = oldest
+	}
+
+	for stream.next <= newest {
+		read, err := readEventLog(stream.handle, stream.next, &i.buffer)
+		if err != nil {
+			log.Warning("Failed to read event log %s: %s", stream.channel, err)
+			stream.close()
+			return true
+		}
+
+		if read == 0 {
+			break
+		}
+
+		records, err := parseRecords(i.buffer[:read])
+		if err != nil {
+			log.Warning("Failed to decode records from event log %s: %s", stream.channel, err)
+			stream.close()
+			return true
+		}
+
+		for _, record := range records {
+			if !i.send(stream, record) {
+				return false
+			}
+			stream.next = record.RecordNumber + 1
+		}
+	}
+
+	return true
+}
+
+// send encodes a record as an event and sends it into the pipeline. It returns
+// false if shutdown was requested
+func (i *Input) send(stream *channelStream, record *Record) bool {
+	event := core.Event{
+		"channel":       stream.channel,
+		"source":        record.Source,
+		"computer":      record.Computer,
+		"level":         record.Level(),
+		"event_id":      record.DisplayID(),
+		"record_number": record.RecordNumber,
+		"timestamp":     record.TimeGenerated.Format(time.RFC3339),
+	}
+
+	event[i.genConfig.MessageField] = stream.formatter.Format(record)
+
+	if !i.genConfig.ECSCompatibility {
+		event["host"] = i.genConfig.Host
+	}
+
+	for k := range i.genConfig.GlobalFields {
+		if k == "tags" {
+			event.MergeTags(i.genConfig.GlobalFields[k])
+			continue
+		}
+		event[k] = i.genConfig.GlobalFields[k]
+	}
+
+	for _, tag := range i.genConfig.Tags {
+		event.AddTag(tag)
+	}
+
+	// ECS fields are set after the global fields so that they are never
+	// overwritten
+	if i.genConfig.ECSCompatibility {
+		event["@timestamp"] = record.TimeGenerated.UTC().Format(time.RFC3339Nano)
+		event.SetNested("host.name", i.genConfig.Host)
+	}
+
+	encoded, err := event.Encode()
+	if err != nil {
+		// This should never happen - log and skip if it does
+		log.Warning("Skipping record %d in event log %s due to encoding failure: %s", record.RecordNumber, stream.channel, err)
+		return true
+	}
+
+	desc := &core.EventDescriptor{
+		Stream: stream,
+		Offset: int64(record.RecordNumber),
+		Event:  encoded,
+	}
+
+	select {
+	case <-i.OnShutdown():
+		return false
+	case i.output <- desc:
+	}
+
+	return true
+}
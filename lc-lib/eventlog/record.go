@@ -0,0 +1,163 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// recordHeaderSize is the size of the fixed EVENTLOGRECORD header that
+	// precedes the variable length data of each record
+	recordHeaderSize = 56
+
+	// Event types from the EVENTLOGRECORD EventType field
+	eventTypeSuccess      = 0x0000
+	eventTypeError        = 0x0001
+	eventTypeWarning      = 0x0002
+	eventTypeInformation  = 0x0004
+	eventTypeAuditSuccess = 0x0008
+	eventTypeAuditFailure = 0x0010
+)
+
+// Record holds the decoded fields of a single event log record
+type Record struct {
+	RecordNumber  uint32
+	TimeGenerated time.Time
+	EventID       uint32
+	EventType     uint16
+	Category      uint16
+	Source        string
+	Computer      string
+	Strings       []string
+}
+
+// Level returns the name of the record's event type
+func (r *Record) Level() string {
+	switch r.EventType {
+	case eventTypeSuccess:
+		return "success"
+	case eventTypeError:
+		return "error"
+	case eventTypeWarning:
+		return "warning"
+	case eventTypeInformation:
+		return "information"
+	case eventTypeAuditSuccess:
+		return "audit success"
+	case eventTypeAuditFailure:
+		return "audit failure"
+	}
+	return fmt.Sprintf("unknown (%d)", r.EventType)
+}
+
+// DisplayID returns the event identifier as displayed by the Event Viewer,
+// which excludes the severity and facility bits
+func (r *Record) DisplayID() uint32 {
+	return r.EventID & 0xFFFF
+}
+
+// InsertionMessage returns the insertion strings of the record joined
+// together, for use as the message when the message file of the source is not
+// available
+func (r *Record) InsertionMessage() string {
+	return strings.Join(r.Strings, " ")
+}
+
+// parseRecords decodes the EVENTLOGRECORD structures returned by a call to
+// ReadEventLog
+func parseRecords(buffer []byte) ([]*Record, error) {
+	var records []*Record
+
+	for len(buffer) != 0 {
+		if len(buffer) < recordHeaderSize {
+			return nil, errors.New("truncated event log record header")
+		}
+
+		length := binary.LittleEndian.Uint32(buffer[0:4])
+		if length < recordHeaderSize || int64(length) > int64(len(buffer)) {
+			return nil, fmt.Errorf("invalid event log record length: %d", length)
+		}
+
+		record, err := parseRecord(buffer[:length])
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+		buffer = buffer[length:]
+	}
+
+	return records, nil
+}
+
+// parseRecord decodes a single EVENTLOGRECORD structure
+func parseRecord(data []byte) (*Record, error) {
+	record := &Record{
+		RecordNumber:  binary.LittleEndian.Uint32(data[8:12]),
+		TimeGenerated: time.Unix(int64(binary.LittleEndian.Uint32(data[12:16])), 0),
+		EventID:       binary.LittleEndian.Uint32(data[20:24]),
+		EventType:     binary.LittleEndian.Uint16(data[24:26]),
+		Category:      binary.LittleEndian.Uint16(data[28:30]),
+	}
+
+	numStrings := int(binary.LittleEndian.Uint16(data[26:28]))
+	stringOffset := binary.LittleEndian.Uint32(data[36:40])
+
+	// The source and computer names immediately follow the header
+	names := readStrings(data[recordHeaderSize:], 2)
+	if len(names) != 2 {
+		return nil, fmt.Errorf("event log record %d has invalid source and computer names", record.RecordNumber)
+	}
+	record.Source, record.Computer = names[0], names[1]
+
+	if numStrings != 0 {
+		if stringOffset < recordHeaderSize || int64(stringOffset) > int64(len(data)) {
+			return nil, fmt.Errorf("event log record %d has an invalid string offset: %d", record.RecordNumber, stringOffset)
+		}
+
+		record.Strings = readStrings(data[stringOffset:], numStrings)
+		if len(record.Strings) != numStrings {
+			return nil, fmt.Errorf("event log record %d has %d strings, expected %d", record.RecordNumber, len(record.Strings), numStrings)
+		}
+	}
+
+	return record, nil
+}
+
+// readStrings reads up to count null terminated UTF-16 strings from data
+func readStrings(data []byte, count int) []string {
+	var result []string
+	var current []uint16
+
+	for i := 0; i+1 < len(data) && len(result) < count; i += 2 {
+		char := binary.LittleEndian.Uint16(data[i : i+2])
+		if char == 0 {
+			result = append(result, string(utf16.Decode(current)))
+			current = current[:0]
+			continue
+		}
+		current = append(current, char)
+	}
+
+	return result
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+func encodeTestString(buffer *bytes.Buffer, str string) {
+	for _, char := range utf16.Encode([]rune(str)) {
+		binary.Write(buffer, binary.LittleEndian, char)
+	}
+	binary.Write(buffer, binary.LittleEndian, uint16(0))
+}
+
+func createTestRecord(recordNumber uint32, eventID uint32, eventType uint16, source string, computer string, strings ...string) []byte {
+	var names, inserts bytes.Buffer
+	encodeTestString(&names, source)
+	encodeTestString(&names, computer)
+	for _, str := range strings {
+		encodeTestString(&inserts, str)
+	}
+
+	stringOffset := uint32(recordHeaderSize + names.Len())
+	length := stringOffset + uint32(inserts.Len())
+
+	var record bytes.Buffer
+	binary.Write(&record, binary.LittleEndian, []uint32{length, 0x654c664c, recordNumber, 1500000000, 1500000001, eventID})
+	binary.Write(&record, binary.LittleEndian, []uint16{eventType, uint16(len(strings)), 3, 0})
+	binary.Write(&record, binary.LittleEndian, []uint32{0, stringOffset, 0, 0, 0, 0})
+	record.Write(names.Bytes())
+	record.Write(inserts.Bytes())
+
+	return record.Bytes()
+}
+
+func TestParseRecords(t *testing.T) {
+	buffer := append(
+		createTestRecord(10, 0x40001B58, eventTypeWarning, "Service Control Manager", "HOST", "first", "second"),
+		createTestRecord(11, 7036, eventTypeInformation, "EventLog", "HOST")...,
+	)
+
+	records, err := parseRecords(buffer)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Unexpected number of records: %d", len(records))
+	}
+
+	record := records[0]
+	if record.RecordNumber != 10 {
+		t.Errorf("Unexpected record number: %d", record.RecordNumber)
+	}
+	if record.TimeGenerated.Unix() != 1500000000 {
+		t.Errorf("Unexpected time generated: %s", record.TimeGenerated)
+	}
+	if record.DisplayID() != 7000 {
+		t.Errorf("Unexpected event id: %d", record.DisplayID())
+	}
+	if record.Level() != "warning" {
+		t.Errorf("Unexpected level: %s", record.Level())
+	}
+	if record.Category != 3 {
+		t.Errorf("Unexpected category: %d", record.Category)
+	}
+	if record.Source != "Service Control Manager" || record.Computer != "HOST" {
+		t.Errorf("Unexpected source or computer: %s, %s", record.Source, record.Computer)
+	}
+	if record.InsertionMessage() != "first second" {
+		t.Errorf("Unexpected insertion message: %s", record.InsertionMessage())
+	}
+
+	record = records[1]
+	if record.RecordNumber != 11 || record.Level() != "information" || len(record.Strings) != 0 {
+		t.Errorf("Unexpected second record: %+v", record)
+	}
+}
+
+func TestParseRecordsTruncated(t *testing.T) {
+	buffer := createTestRecord(10, 7036, eventTypeError, "EventLog", "HOST", "message")
+
+	if _, err := parseRecords(buffer[:recordHeaderSize-1]); err == nil {
+		t.Error("Truncated header was parsed successfully")
+	}
+
+	if _, err := parseRecords(buffer[:len(buffer)-2]); err == nil {
+		t.Error("Truncated record was parsed successfully")
+	}
+}
@@ -20,9 +20,11 @@
 package harvester
 
 import (
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"sync"
 	"time"
@@ -38,16 +40,29 @@ var (
 	Stdin = "stdin"
 
 	errFileTruncated = errors.New("File truncation detected")
+	errLineSkipped   = errors.New("Line remainder skipped")
 	errStopRequested = errors.New("Stop requested")
 )
 
+const (
+	// idleDeadTimeFraction is the fraction of the dead time a harvester must have
+	// been waiting at the end of the file before it is considered idle
+	idleDeadTimeFraction = 10
+)
+
 // FinishStatus contains the final file state, and any errors, from the point the
 // harvester finished
 type FinishStatus struct {
 	LastEventOffset int64
 	LastReadOffset  int64
+	// LastSentOffset is the end offset of the last event sent, which is as far
+	// as acknowledgements will reach. It is behind LastEventOffset if events at
+	// the end were never sent, such as when removed by the filter codec
+	LastSentOffset int64
 	Error           error
 	LastStat        os.FileInfo
+	// Completed is true if a read once file was read through to the end
+	Completed bool
 }
 
 // Harvester reads from a file, passes lines through a codec, and sends them
@@ -59,23 +74,40 @@ type Harvester struct {
 	returnChan      chan *FinishStatus
 	stream          core.Stream
 	fileinfo        os.FileInfo
+	inode           uint64
 	path            string
 	config          *config.Config
 	streamConfig    *config.Stream
 	offset          int64
+	sentOffset      int64
 	output          chan<- *core.EventDescriptor
 	codec           codecs.Codec
 	codecChain      []codecs.Codec
 	file            *os.File
+	input           io.Reader
 	backOffTimer    *time.Timer
 	meterTimer      *time.Timer
+	limitTimer      *time.Timer
+	rateLimiter     *rateLimiter
+	sampler         *sampler
 	split           bool
+	longLine        *string
+	longLineBytes   int
+	longLineDone    bool
+	skipping        bool
+	skippedLines    uint64
+	droppedOffset   *int64
 	timezone        string
 	reader          *LineReader
 	staleOffset     int64
 	staleBytes      int64
 	lastStaleOffset int64
 	isStream        bool
+	compressed      bool
+	readOnce        bool
+	finishAtEOF     bool
+	completed       bool
+	truncated       bool
 
 	lastReadTime         time.Time
 	lastMeasurement      time.Time
@@ -90,6 +122,7 @@ type Harvester struct {
 	byteCount  uint64
 	lastEOFOff *int64
 	lastEOF    *time.Time
+	atEOF      bool
 	lastSize   int64
 	lastOffset int64
 }
@@ -102,16 +135,29 @@ func NewHarvester(stream core.Stream, config *config.Config, streamConfig *confi
 		config:       config,
 		streamConfig: streamConfig,
 		offset:       offset,
+		sentOffset:   offset,
 		timezone:     time.Now().Format("-0700 MST"),
 		lastEOF:      nil,
 		codecChain:   make([]codecs.Codec, len(streamConfig.Codecs)-1),
 		backOffTimer: time.NewTimer(0),
 		// TODO: Configurable meter timer? Use same as statCheck timer
 		meterTimer: time.NewTimer(10 * time.Second),
+		compressed: streamConfig.Compression == "gzip",
 	}
 
+	// Compressed files can not be tailed so are only read once
+	ret.readOnce = ret.compressed || streamConfig.ReadOnce
+
 	ret.backOffTimer.Stop()
 
+	if streamConfig.RateLimit > 0 {
+		ret.rateLimiter = newRateLimiter(streamConfig.RateLimit)
+		ret.limitTimer = time.NewTimer(0)
+		if !ret.limitTimer.Stop() {
+			<-ret.limitTimer.C
+		}
+	}
+
 	if stream != nil {
 		// Grab now so we can safely use them even if prospector changes them
 		ret.path, ret.fileinfo = stream.Info()
@@ -119,10 +165,15 @@ func NewHarvester(stream core.Stream, config *config.Config, streamConfig *confi
 	} else {
 		// This is stdin
 		ret.file = os.Stdin
+		ret.input = os.Stdin
 		ret.path, ret.fileinfo = Stdin, nil
 		ret.isStream = true
 	}
 
+	if streamConfig.SampleRate < 1 {
+		ret.sampler = newSampler(streamConfig.SampleRate, ret.path)
+	}
+
 	// Build the codec chain
 	var entry codecs.Codec
 	callback := ret.eventCallback
@@ -152,7 +203,9 @@ func (h *Harvester) Start(output chan<- *core.EventDescriptor) {
 		status := &FinishStatus{}
 		status.LastEventOffset, status.Error = h.harvest(output)
 		status.LastReadOffset = h.offset
+		status.LastSentOffset = h.sentOffset
 		status.LastStat = h.fileinfo
+		status.Completed = h.completed
 		h.returnChan <- status
 		close(h.returnChan)
 	}()
@@ -163,19 +216,45 @@ func (h *Harvester) Stop() {
 	close(h.stopChan)
 }
 
+// IsIdle returns true if the harvester is waiting at the end of the file and
+// has not read any new data for a while, a fraction of the dead time
+func (h *Harvester) IsIdle() bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.atEOF && time.Since(*h.lastEOF) >= h.streamConfig.DeadTime/idleDeadTimeFraction
+}
+
+// FinishAtEOF requests the harvester to stop once it has read everything that
+// has been written to the file, such as when the file is no longer found by
+// the prospector
+func (h *Harvester) FinishAtEOF() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.finishAtEOF = true
+}
+
 // OnFinish returns a channel which will receive a FinishStatus structure when
 // the harvester stops
 func (h *Harvester) OnFinish() <-chan *FinishStatus {
 	return h.returnChan
 }
 
-// codecTeardown shuts down all codecs in the order they are used
+// codecTeardown shuts down all codecs in the order they are used, so that any
+// events a codec flushes during teardown pass through the remaining codecs
 func (h *Harvester) codecTeardown() int64 {
+	offset := h.codec.Teardown()
+
 	for _, codec := range h.codecChain {
 		codec.Teardown()
 	}
 
-	return h.codec.Teardown()
+	// If we were stopped before an event could be sent, resume from that
+	// event so it is not lost
+	if h.droppedOffset != nil && *h.droppedOffset < offset {
+		offset = *h.droppedOffset
+	}
+
+	return offset
 }
 
 // harvest runs in its own routine, opening the file and starting the read loop
@@ -191,6 +270,9 @@ func (h *Harvester) harvest(output chan<- *core.EventDescriptor) (int64, error)
 	if h.isStream {
 		log.Info("Started harvester: %s", h.path)
 		h.offset = 0
+	} else if h.compressed {
+		// Offset was reached during preparation by decompressing and discarding
+		log.Info("Started harvester on compressed file at position %d: %s", h.offset, h.path)
 	} else {
 		// Get current offset in file
 		offset, err := h.file.Seek(0, os.SEEK_CUR)
@@ -208,8 +290,14 @@ func (h *Harvester) harvest(output chan<- *core.EventDescriptor) (int64, error)
 		h.offset = offset
 	}
 
+	h.sentOffset = h.offset
+
+	// When starting part way through a file we may be in the middle of a record,
+	// so discard lines until one matches the skip to pattern
+	h.skipping = h.offset != 0 && h.streamConfig.SkipToRegexp != nil
+
 	// The buffer size limits the maximum line length we can read, including terminator
-	h.reader = NewLineReader(h.file, int(h.config.General.LineBufferBytes), int(h.config.General.MaxLineBytes))
+	h.reader = NewLineReader(h.input, int(h.config.General.LineBufferBytes), int(h.streamConfig.MaxLineBytes))
 
 	// Prepare internal data
 	h.lastReadTime = time.Now()
@@ -221,19 +309,20 @@ func (h *Harvester) harvest(output chan<- *core.EventDescriptor) (int64, error)
 			if err == errStopRequested {
 				break
 			}
+			h.flushLongLine()
 			return h.codecTeardown(), err
 		}
 	}
 
 	log.Info("Harvester for %s exiting", h.path)
+	h.flushLongLine()
 	return h.codecTeardown(), nil
 }
 
 // performRead performs a single read operation
 func (h *Harvester) performRead() error {
-	text, bytesread, err := h.readline()
-
-	// Is a measurement due?
+	// Is a measurement due? This is done before reading so that a line is not
+	// discarded if it detects a truncation
 	if duration := time.Since(h.lastMeasurement); duration >= time.Second {
 		if measureErr := h.takeMeasurements(duration, false); measureErr != nil {
 			if measureErr == errFileTruncated {
@@ -244,16 +333,46 @@ func (h *Harvester) performRead() error {
 		}
 	}
 
+	text, bytesread, err := h.readline()
+
 	if err == nil {
 		lineOffset := h.offset
 		h.offset += int64(bytesread)
 
+		// Only we write atEOF, so only changing it needs the lock
+		if h.atEOF {
+			h.mutex.Lock()
+			h.atEOF = false
+			h.mutex.Unlock()
+		}
+
+		if h.skipping && h.skipLine(text) {
+			h.lastReadTime = time.Now()
+			h.byteCount += uint64(bytesread)
+			return nil
+		}
+
+		event := core.Event{"message": text}
+		if h.longLineDone {
+			event.AddTag("_linetoolong")
+			h.longLineDone = false
+		}
+
 		// Codec is last - it forwards harvester state for us such as offset for resume
-		h.codec.Event(lineOffset, h.offset, text)
+		h.codec.Event(lineOffset, h.offset, event)
 
 		h.lastReadTime = time.Now()
 		h.lineCount++
 		h.byteCount += uint64(bytesread)
+
+		// Truncation may have been detected whilst the pipeline was blocked
+		if h.truncated {
+			h.handleTruncation()
+		}
+		return nil
+	}
+
+	if err == errLineSkipped {
 		return nil
 	}
 
@@ -268,6 +387,51 @@ func (h *Harvester) performRead() error {
 		return errStopRequested
 	}
 
+	if h.readOnce {
+		// Read once files are never reopened, so we are finished
+		if h.reader.BufferedLen() != 0 {
+			log.Warning("%d bytes of incomplete log data with no line ending was discarded at the end of %s", h.reader.BufferedLen(), h.path)
+		}
+		log.Info("Completed harvest of %s; EOF reached", h.path)
+		h.completed = true
+		return errStopRequested
+	}
+
+	// A file truncated in place will not grow past our offset again, so check
+	// for truncation each time we reach EOF. As reaching EOF incurs a backoff
+	// this stats the file at most once per second, and only while idle
+	if !h.compressed {
+		if err = h.truncationCheck(); err != nil {
+			if err == errFileTruncated {
+				h.handleTruncation()
+				return nil
+			}
+			return err
+		}
+	}
+
+	h.mutex.RLock()
+	finish := h.finishAtEOF
+	h.mutex.RUnlock()
+	if finish {
+		info, err := h.file.Stat()
+		if err != nil {
+			log.Errorf("Unexpected error checking status of %s: %s", h.path, err)
+			return err
+		}
+
+		// Only stop once nothing was written whilst we backed off
+		if info.Size() <= h.offset+int64(h.reader.BufferedLen()) {
+			if h.reader.BufferedLen() != 0 {
+				log.Warning("%d bytes of incomplete log data with no line ending was discarded at the end of %s", h.reader.BufferedLen(), h.path)
+			}
+			log.Info("Stopping harvest of %s; EOF reached and file is no longer found", h.path)
+			return errStopRequested
+		}
+
+		return nil
+	}
+
 	h.mutex.Lock()
 	if h.lastEOF == nil {
 		h.lastEOF = new(time.Time)
@@ -275,6 +439,7 @@ func (h *Harvester) performRead() error {
 	}
 	*h.lastEOF = h.lastReadTime
 	*h.lastEOFOff = h.offset
+	h.atEOF = true
 	h.mutex.Unlock()
 
 	// Check shutdown
@@ -287,13 +452,32 @@ func (h *Harvester) performRead() error {
 	return nil
 }
 
+// skipLine returns true if the line should be discarded because we have not
+// yet reached a line that matches the skip to pattern
+func (h *Harvester) skipLine(text string) bool {
+	if !h.streamConfig.SkipToRegexp.MatchString(text) {
+		h.skippedLines++
+		h.longLineDone = false
+		return true
+	}
+
+	if h.skippedLines != 0 {
+		log.Info("Skipped %d lines before the first line matching the skip to pattern in %s", h.skippedLines, h.path)
+	}
+	h.skipping = false
+	return false
+}
+
 func (h *Harvester) handleTruncation() {
 	log.Warning("Unexpected file truncation, seeking to beginning: %s", h.path)
 
 	h.file.Seek(0, os.SEEK_SET)
 	h.offset = 0
+	h.sentOffset = 0
 	h.staleOffset = 0
 	h.lastStaleOffset = 0
+	h.truncated = false
+	h.skipping = false
 
 	// TODO: Should we be allowing truncation to lose buffer data? Or should
 	//       we be flushing what we have?
@@ -301,9 +485,19 @@ func (h *Harvester) handleTruncation() {
 		log.Errorf("%d bytes of incomplete log data was lost due to file truncation", h.reader.BufferedLen())
 	}
 
-	// Reset line buffer and codec buffers
+	// Reset line buffer and codec buffers, so that the codecs report the new
+	// offset and the registrar is reverted to it as new events are acknowledged
 	h.reader.Reset()
+	h.longLine = nil
+	h.codecReset()
+}
+
+// codecReset resets all codecs so they restart from the beginning of the file
+func (h *Harvester) codecReset() {
 	h.codec.Reset()
+	for _, codec := range h.codecChain {
+		codec.Reset()
+	}
 }
 
 func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked bool) error {
@@ -348,7 +542,7 @@ func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked b
 	h.lastByteCount = h.byteCount
 	h.lastLineCount = h.lineCount
 	h.lastOffset = h.offset
-	if h.fileinfo != nil {
+	if h.fileinfo != nil && !h.compressed {
 		h.lastSize = h.fileinfo.Size()
 	}
 	if h.offset > h.lastSize {
@@ -375,6 +569,24 @@ func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked b
 	return nil
 }
 
+// truncationCheck returns errFileTruncated if the open file is now smaller
+// than the current offset. The file handle is checked rather than the path, so
+// a file that was rotated and replaced by a new one, which is handled by the
+// prospector, is never mistaken for a truncation
+func (h *Harvester) truncationCheck() error {
+	info, err := h.file.Stat()
+	if err != nil {
+		log.Errorf("Unexpected error checking status of %s: %s", h.path, err)
+		return err
+	}
+
+	if info.Size() < h.offset {
+		return errFileTruncated
+	}
+
+	return nil
+}
+
 // statCheck checks for truncation and returns the file size of the file
 func (h *Harvester) statCheck() error {
 	info, err := h.file.Stat()
@@ -383,7 +595,9 @@ func (h *Harvester) statCheck() error {
 		return err
 	}
 
-	if info.Size() < h.offset {
+	// The offset of a compressed file is within the decompressed data so is not
+	// comparable to the file size
+	if !h.compressed && info.Size() < h.offset {
 		return errFileTruncated
 	}
 
@@ -400,48 +614,131 @@ func (h *Harvester) statCheck() error {
 
 	// Store latest stat()
 	h.fileinfo = info
+	h.inode = fileInode(info)
 
 	return nil
 }
 
-// eventCallback receives events from the final codec and ships them to the output
-func (h *Harvester) eventCallback(startOffset int64, endOffset int64, text string) {
-	event := core.Event{
-		"message": text,
+// setECSFields sets the fields Log Courier adds to events using their nested
+// Elastic Common Schema names
+func (h *Harvester) setECSFields(startOffset int64, event core.Event, timestamp interface{}) {
+	// Keep any timestamp a codec, such as json, parsed from the line
+	if timestamp == nil {
+		timestamp = time.Now().UTC().Format(time.RFC3339Nano)
 	}
-
+	event["@timestamp"] = timestamp
 	if h.streamConfig.AddHostField {
-		event["host"] = h.config.General.Host
+		event.SetNested("host.name", h.config.General.Host)
 	}
 	if h.streamConfig.AddPathField {
-		event["path"] = h.path
+		event.SetNested("log.file.path", h.path)
 	}
 	if h.streamConfig.AddOffsetField {
-		event["offset"] = startOffset
+		event.SetNested("log.offset", startOffset)
 	}
 	if h.streamConfig.AddTimezoneField {
-		event["timezone"] = h.timezone
+		event.SetNested("event.timezone", h.timezone)
+	}
+}
+
+// eventTimestamp returns the timestamp parsed from the message of the event, or
+// the current time if it could not be parsed, in which case the event is
+// tagged so the failure can be found
+func (h *Harvester) eventTimestamp(event core.Event) string {
+	now := time.Now()
+	if message, ok := event["message"].(string); ok {
+		if parsed, ok := parseTimestamp(&h.streamConfig.Timestamp, message, now); ok {
+			return parsed.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	event.AddTag("_timestampparsefailure")
+	return now.UTC().Format(time.RFC3339Nano)
+}
+
+// eventCallback receives events from the final codec and ships them to the output
+func (h *Harvester) eventCallback(startOffset int64, endOffset int64, event core.Event) {
+	// Events left out of the sample are not sent, but the offset of the next
+	// event sent covers them, in the same way as for the filter codec. Any at
+	// the end are covered by the offset reported when the harvester finishes
+	if h.sampler != nil && !h.sampler.keep(startOffset) {
+		return
+	}
+
+	timestamp := event["@timestamp"]
+
+	// Codecs have finished with the event, so any multiline joining has happened
+	// and the message is still in the "message" field
+	if h.streamConfig.Timestamp.Regexp != nil {
+		timestamp = h.eventTimestamp(event)
+		event["@timestamp"] = timestamp
+	}
+
+	// Codecs always work with the line data in the "message" field, so move it
+	// to the configured field now. If a codec, such as json, replaced the line
+	// then any "message" field is its own and is left untouched
+	if _, replaced := event[codecs.MessageReplacedKey]; replaced {
+		delete(event, codecs.MessageReplacedKey)
+	} else if h.streamConfig.MessageField != "message" {
+		if message, ok := event["message"]; ok {
+			event[h.streamConfig.MessageField] = message
+			delete(event, "message")
+		}
+	}
+
+	if !h.config.General.ECSCompatibility {
+		if h.streamConfig.AddHostField {
+			event["host"] = h.config.General.Host
+		}
+		if h.streamConfig.AddPathField {
+			event["path"] = h.path
+		}
+		if h.streamConfig.AddOffsetField {
+			event["offset"] = startOffset
+		}
+		if h.streamConfig.AddTimezoneField {
+			event["timezone"] = h.timezone
+		}
+	}
+	if h.config.General.IncludeOffset {
+		// Allows a deterministic document ID to be built downstream so that any
+		// events shipped again after a crash can be indexed idempotently
+		event["_offset"] = startOffset
+		if !h.isStream {
+			event["_inode"] = h.inode
+		}
 	}
 
+	// Tags given in the fields are merged with any added by the codecs
 	for k := range h.config.General.GlobalFields {
+		if k == "tags" {
+			event.MergeTags(h.config.General.GlobalFields[k])
+			continue
+		}
 		event[k] = h.config.General.GlobalFields[k]
 	}
 
 	for k := range h.streamConfig.Fields {
+		if k == "tags" {
+			event.MergeTags(h.streamConfig.Fields[k])
+			continue
+		}
 		event[k] = h.streamConfig.Fields[k]
 	}
 
+	for _, tag := range h.config.General.Tags {
+		event.AddTag(tag)
+	}
+
+	// ECS fields are set after the configured fields so that they are never
+	// overwritten, with any configured objects of the same name merged into
+	if h.config.General.ECSCompatibility {
+		h.setECSFields(startOffset, event, timestamp)
+	}
+
 	// If we split any of the line data, tag it
 	if h.split {
-		if v, ok := event["tags"]; ok {
-			va, ok := v.([]string)
-			if ok {
-				va = append(va, "splitline")
-				event["tags"] = va
-			}
-		} else {
-			event["tags"] = []string{"splitline"}
-		}
+		event.AddTag("splitline")
 		h.split = false
 	}
 
@@ -458,12 +755,29 @@ func (h *Harvester) eventCallback(startOffset int64, endOffset int64, text strin
 		Event:  encoded,
 	}
 
+	// If rate limited, hold the event until the limit allows it to be sent.
+	// Since the codecs are called synchronously this also blocks further reads
+	output := h.output
+	var limitChan <-chan time.Time
+	if h.rateLimiter != nil {
+		if delay := h.rateLimiter.take(); delay > 0 {
+			h.limitTimer.Reset(delay)
+			limitChan = h.limitTimer.C
+			output = nil
+		}
+	}
+
 EventLoop:
 	for {
 		select {
 		case <-h.stopChan:
+			h.dropEvent(startOffset)
 			break EventLoop
-		case h.output <- desc:
+		case <-limitChan:
+			limitChan = nil
+			output = h.output
+		case output <- desc:
+			h.sentOffset = endOffset
 			break EventLoop
 		case <-h.meterTimer.C:
 			// TODO: Configurable meter timer? Same as statCheck?
@@ -472,13 +786,26 @@ EventLoop:
 			// Take measurements if enough time has elapsed since the last measurement
 			if duration := time.Since(h.lastMeasurement); duration >= time.Second {
 				if measureErr := h.takeMeasurements(duration, true); measureErr == errStopRequested {
+					h.dropEvent(startOffset)
 					break EventLoop
+				} else if measureErr == errFileTruncated {
+					// Handled once this event is sent
+					h.truncated = true
 				}
 			}
 		}
 	}
 }
 
+// dropEvent records the offset of the first event that could not be sent due
+// to a stop request, so that the harvester reports it as the resume offset
+func (h *Harvester) dropEvent(startOffset int64) {
+	if h.droppedOffset == nil {
+		h.droppedOffset = new(int64)
+		*h.droppedOffset = startOffset
+	}
+}
+
 func (h *Harvester) prepareHarvester() error {
 	// Streams don't need opening or checking
 	if h.isStream {
@@ -506,9 +833,37 @@ func (h *Harvester) prepareHarvester() error {
 
 	// Store latest stat()
 	h.fileinfo = info
+	h.inode = fileInode(info)
+
+	if h.compressed {
+		// Compressed data can not be seeked within, so skip to the offset by
+		// decompressing from the beginning and discarding
+		gzipReader, err := gzip.NewReader(h.file)
+		if err != nil {
+			h.file.Close()
+			return fmt.Errorf("Failed to read gzip header: %s", err)
+		}
+
+		if _, err = io.CopyN(ioutil.Discard, gzipReader, h.offset); err != nil {
+			h.file.Close()
+			return fmt.Errorf("Failed to skip to offset %d within compressed data: %s", h.offset, err)
+		}
+
+		h.input = gzipReader
+		return nil
+	}
+
+	// If the file was truncated whilst we were not harvesting it then the
+	// resume offset is beyond the end of the file
+	if info.Size() < h.offset {
+		log.Warning("Resume offset %d is beyond the end of the file, which may have been truncated, seeking to beginning: %s", h.offset, h.path)
+		h.offset = 0
+		h.codecReset()
+	}
 
 	// TODO: Check error?
 	h.file.Seek(h.offset, os.SEEK_SET)
+	h.input = h.file
 
 	return nil
 }
@@ -521,6 +876,10 @@ func (h *Harvester) readline() (string, int, error) {
 	line, err := h.reader.ReadSlice()
 
 	if line != nil {
+		if h.longLine != nil || (err == ErrLineTooLong && h.streamConfig.MaxLineAction == "truncate") {
+			return h.truncateLine(line, err)
+		}
+
 		if err == nil {
 			// Line will always end in '\n' if no error, but check also for CR
 			if len(line) > 1 && line[len(line)-2] == '\r' {
@@ -556,6 +915,57 @@ func (h *Harvester) readline() (string, int, error) {
 	return "", 0, io.EOF
 }
 
+// truncateLine handles a segment of a line longer than max line bytes when the
+// max line action is "truncate". The first segment is kept and the remainder
+// is discarded up to the line ending, at which point the truncated line is
+// returned with a length that covers the entire line, so that the resume
+// offset moves beyond the discarded data
+func (h *Harvester) truncateLine(line []byte, err error) (string, int, error) {
+	if h.longLine == nil {
+		// We use string() to copy the memory, which is a slice of the line buffer we need to re-use
+		text := string(line)
+		h.longLine = &text
+		h.longLineBytes = 0
+	}
+
+	h.longLineBytes += len(line)
+
+	if err == ErrLineTooLong {
+		return "", 0, errLineSkipped
+	}
+
+	log.Warning("Truncated line in %s at offset %d that was %d bytes long", h.path, h.offset, h.longLineBytes)
+
+	text, length := *h.longLine, h.longLineBytes
+	h.longLine = nil
+	h.longLineDone = true
+	return text, length, nil
+}
+
+// flushLongLine ships the first segment of a line being truncated that has not
+// yet reached its line ending, so it is not lost when the harvester stops. The
+// offset covers only the segments already discarded, so on resume the rest of
+// the line is read as a new line
+func (h *Harvester) flushLongLine() {
+	if h.longLine == nil {
+		return
+	}
+
+	log.Warning("Truncated line in %s at offset %d that was at least %d bytes long with no line ending", h.path, h.offset, h.longLineBytes)
+
+	lineOffset := h.offset
+	h.offset += int64(h.longLineBytes)
+
+	event := core.Event{"message": *h.longLine}
+	event.AddTag("_linetoolong")
+	h.longLine = nil
+
+	h.codec.Event(lineOffset, h.offset, event)
+
+	h.lineCount++
+	h.byteCount += uint64(h.longLineBytes)
+}
+
 // APIEncodable returns an admin API entry with harvester status
 func (h *Harvester) APIEncodable() admin.APIEncodable {
 	h.mutex.RLock()
@@ -20,8 +20,14 @@ package harvester
 
 import (
 	"os"
+	"syscall"
 )
 
 func (h *Harvester) openFile(path string) (*os.File, error) {
 	return os.Open(path)
 }
+
+// fileInode returns the inode number of the file
+func fileInode(info os.FileInfo) uint64 {
+	return uint64(info.Sys().(*syscall.Stat_t).Ino)
+}
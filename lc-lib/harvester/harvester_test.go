@@ -0,0 +1,824 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package harvester
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/codecs"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+type testStream struct {
+	path string
+	info os.FileInfo
+}
+
+func (s *testStream) Info() (string, os.FileInfo) {
+	return s.path, s.info
+}
+
+func createTestConfig(t *testing.T) (*config.Config, *config.Stream) {
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	cfg.General.Host = "localhost"
+
+	streamConfig := &config.Stream{}
+	streamConfig.InitDefaults()
+	streamConfig.DeadTime = cfg.General.DeadTime
+	streamConfig.MessageField = cfg.General.MessageField
+	streamConfig.MaxLineBytes = cfg.General.MaxLineBytes
+
+	factory, err := codecs.NewPlainCodecFactory(cfg, "/stream/codecs[0]", nil, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+	streamConfig.Codecs = []config.CodecStub{config.CodecStub{Name: "plain", Factory: factory}}
+
+	return cfg, streamConfig
+}
+
+func createTestFile(t *testing.T, data []byte) (string, *testStream) {
+	dir, err := ioutil.TempDir("", "harvester")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+
+	path := filepath.Join(dir, "test.log")
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write test file: %s", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat test file: %s", err)
+	}
+
+	return dir, &testStream{path: path, info: info}
+}
+
+func gzipData(t *testing.T, data string) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write([]byte(data)); err != nil {
+		t.Fatalf("Failed to compress test data: %s", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to compress test data: %s", err)
+	}
+	return buffer.Bytes()
+}
+
+func receiveEvent(t *testing.T, output <-chan *core.EventDescriptor, offset int64) map[string]interface{} {
+	select {
+	case desc := <-output:
+		var event map[string]interface{}
+		if err := json.Unmarshal(desc.Event, &event); err != nil {
+			t.Errorf("Failed to decode event: %s", err)
+			return nil
+		}
+		if desc.Offset != offset {
+			t.Errorf("Unexpected offset: %d (expected %d)", desc.Offset, offset)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timeout waiting for event at offset %d", offset)
+	}
+	return nil
+}
+
+func checkEvent(t *testing.T, output <-chan *core.EventDescriptor, message string, offset int64) {
+	event := receiveEvent(t, output, offset)
+	if event != nil && event["message"] != message {
+		t.Errorf("Unexpected message: %v (expected %s)", event["message"], message)
+	}
+}
+
+func waitFinish(t *testing.T, h *Harvester) *FinishStatus {
+	select {
+	case status := <-h.OnFinish():
+		return status
+	case <-time.After(5 * time.Second):
+		h.Stop()
+		t.Fatal("Timeout waiting for harvester to finish")
+	}
+	return nil
+}
+
+func TestHarvesterGzip(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.Compression = "gzip"
+
+	dir, stream := createTestFile(t, gzipData(t, "first line\nsecond line\nthird line\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	checkEvent(t, output, "first line", 11)
+	checkEvent(t, output, "second line", 23)
+	checkEvent(t, output, "third line", 34)
+
+	status := waitFinish(t, h)
+	if status.Error != nil {
+		t.Errorf("Unexpected error: %s", status.Error)
+	}
+	if !status.Completed {
+		t.Error("Harvester did not report completion")
+	}
+	if status.LastEventOffset != 34 {
+		t.Errorf("Unexpected last event offset: %d", status.LastEventOffset)
+	}
+}
+
+func TestHarvesterGzipResume(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.Compression = "gzip"
+
+	dir, stream := createTestFile(t, gzipData(t, "first line\nsecond line\nthird line\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 11)
+	h.Start(output)
+
+	checkEvent(t, output, "second line", 23)
+	checkEvent(t, output, "third line", 34)
+
+	status := waitFinish(t, h)
+	if !status.Completed {
+		t.Error("Harvester did not report completion")
+	}
+}
+
+func TestHarvesterGzipFiltered(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.Compression = "gzip"
+
+	filter, err := codecs.NewFilterCodecFactory(cfg, "/stream/codecs[0]", map[string]interface{}{
+		"patterns": []string{"^DEBUG"},
+		"negate":   true,
+	}, "filter")
+	if err != nil {
+		t.Fatalf("Failed to create filter codec: %s", err)
+	}
+	streamConfig.Codecs = []config.CodecStub{config.CodecStub{Name: "filter", Factory: filter}}
+
+	dir, stream := createTestFile(t, gzipData(t, "first line\nDEBUG noise\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	checkEvent(t, output, "first line", 11)
+
+	// The filtered line at the end is never sent so acknowledgements only reach
+	// the first line, and the finish offset still covers the whole file
+	status := waitFinish(t, h)
+	if !status.Completed {
+		t.Error("Harvester did not report completion")
+	}
+	if status.LastEventOffset != 23 || status.LastSentOffset != 11 {
+		t.Errorf("Unexpected finish offsets: last event %d, last sent %d", status.LastEventOffset, status.LastSentOffset)
+	}
+}
+
+func TestHarvesterGzipTruncated(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.Compression = "gzip"
+
+	data := gzipData(t, "first line\nsecond line\nthird line\n")
+	dir, stream := createTestFile(t, data[:len(data)-10])
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	status := waitFinish(t, h)
+	if status.Error == nil {
+		t.Error("Harvester did not report an error for a truncated file")
+	}
+	if status.Completed {
+		t.Error("Harvester reported completion for a truncated file")
+	}
+
+	// Events before the truncation point may have been shipped, and resume
+	// must never be past what was shipped
+	var lastOffset int64
+	for len(output) != 0 {
+		lastOffset = (<-output).Offset
+	}
+	if status.LastEventOffset != lastOffset {
+		t.Errorf("Unexpected last event offset: %d (expected %d)", status.LastEventOffset, lastOffset)
+	}
+}
+
+func TestHarvesterTruncation(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+
+	dir, stream := createTestFile(t, []byte("first line\nsecond line\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	checkEvent(t, output, "first line", 11)
+	checkEvent(t, output, "second line", 23)
+
+	// Truncate in place and write a line shorter than the previous offset
+	if err := ioutil.WriteFile(stream.path, []byte("new line\n"), 0600); err != nil {
+		t.Fatalf("Failed to truncate test file: %s", err)
+	}
+
+	checkEvent(t, output, "new line", 9)
+
+	h.Stop()
+	status := waitFinish(t, h)
+	if status.Error != nil {
+		t.Errorf("Unexpected error: %s", status.Error)
+	}
+	if status.LastEventOffset != 9 {
+		t.Errorf("Unexpected last event offset: %d", status.LastEventOffset)
+	}
+}
+
+func TestHarvesterTruncationIdle(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+
+	dir, stream := createTestFile(t, []byte("first line\nsecond line\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	checkEvent(t, output, "first line", 11)
+	checkEvent(t, output, "second line", 23)
+
+	// Truncate to empty, the resume offset should return to the beginning
+	if err := os.Truncate(stream.path, 0); err != nil {
+		t.Fatalf("Failed to truncate test file: %s", err)
+	}
+
+	// Allow time to reach EOF and detect the truncation
+	time.Sleep(2500 * time.Millisecond)
+
+	h.Stop()
+	status := waitFinish(t, h)
+	if status.Error != nil {
+		t.Errorf("Unexpected error: %s", status.Error)
+	}
+	if status.LastEventOffset != 0 {
+		t.Errorf("Unexpected last event offset: %d", status.LastEventOffset)
+	}
+}
+
+func TestHarvesterTruncationResume(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+
+	dir, stream := createTestFile(t, []byte("new line\n"))
+	defer os.RemoveAll(dir)
+
+	// Resume offset beyond the end of the file as if truncated while stopped
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 23)
+	h.Start(output)
+
+	checkEvent(t, output, "new line", 9)
+
+	h.Stop()
+	waitFinish(t, h)
+}
+
+func TestHarvesterRateLimit(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.RateLimit = 2
+
+	dir, stream := createTestFile(t, []byte("first line\nsecond line\nthird line\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	start := time.Now()
+	h.Start(output)
+
+	// The first two events fill the burst and the third must wait
+	checkEvent(t, output, "first line", 11)
+	checkEvent(t, output, "second line", 23)
+	checkEvent(t, output, "third line", 34)
+
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Third event was not rate limited: received after %s", elapsed)
+	}
+
+	h.Stop()
+	waitFinish(t, h)
+}
+
+func TestHarvesterReadOnce(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.ReadOnce = true
+
+	dir, stream := createTestFile(t, []byte("first line\nsecond line\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	checkEvent(t, output, "first line", 11)
+	checkEvent(t, output, "second line", 23)
+
+	status := waitFinish(t, h)
+	if status.Error != nil {
+		t.Errorf("Unexpected error: %s", status.Error)
+	}
+	if !status.Completed {
+		t.Error("Harvester did not report completion")
+	}
+	if status.LastEventOffset != 23 {
+		t.Errorf("Unexpected last event offset: %d", status.LastEventOffset)
+	}
+}
+
+func TestHarvesterSampleRate(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.ReadOnce = true
+	streamConfig.SampleRate = 0.5
+
+	var data []byte
+	for i := 0; i < 50; i++ {
+		data = append(data, fmt.Sprintf("line %02d\n", i)...)
+	}
+
+	dir, stream := createTestFile(t, data)
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 50)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	// Lines are 8 bytes long, and the decision for each is the same as that of
+	// a new sampler for the same file
+	s := newSampler(0.5, stream.path)
+	var sent int64
+	for i := 0; i < 50; i++ {
+		if s.keep(int64(i * 8)) {
+			checkEvent(t, output, fmt.Sprintf("line %02d", i), int64(i*8+8))
+			sent = int64(i*8 + 8)
+		}
+	}
+
+	// Lines left out of the sample are still accounted for, including those at
+	// the end after the last event sent which will never be acknowledged
+	status := waitFinish(t, h)
+	if status.LastEventOffset != 400 {
+		t.Errorf("Unexpected last event offset: %d", status.LastEventOffset)
+	}
+	if status.LastSentOffset != sent {
+		t.Errorf("Unexpected last sent offset: %d (expected %d)", status.LastSentOffset, sent)
+	}
+
+	select {
+	case desc := <-output:
+		t.Errorf("Unexpected event at offset %d", desc.Offset)
+	default:
+	}
+}
+
+func TestHarvesterMessageField(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.MessageField = "log"
+
+	dir, stream := createTestFile(t, []byte("first line\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	event := receiveEvent(t, output, 11)
+	if event != nil {
+		if event["log"] != "first line" {
+			t.Errorf("Unexpected log field: %v", event["log"])
+		}
+		if _, ok := event["message"]; ok {
+			t.Errorf("Unexpected message field: %v", event["message"])
+		}
+	}
+
+	h.Stop()
+	waitFinish(t, h)
+}
+
+func TestHarvesterMessageFieldJSON(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.MessageField = "log"
+
+	factory, err := codecs.NewJSONCodecFactory(cfg, "/stream/codecs[0]", nil, "json")
+	if err != nil {
+		t.Fatalf("Failed to create json codec: %s", err)
+	}
+	streamConfig.Codecs = []config.CodecStub{config.CodecStub{Name: "json", Factory: factory}}
+
+	dir, stream := createTestFile(t, []byte("{\"log\": \"parsed\", \"message\": \"other\"}\nnot json\n{\"message\": \"decoded\"}\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	// A decoded field sharing the configured name must not be replaced
+	event := receiveEvent(t, output, 38)
+	if event != nil {
+		if event["log"] != "parsed" {
+			t.Errorf("Unexpected log field: %v", event["log"])
+		}
+		if event["message"] != "other" {
+			t.Errorf("Unexpected message field: %v", event["message"])
+		}
+	}
+
+	// Lines that fail to decode keep their text in the configured field
+	event = receiveEvent(t, output, 47)
+	if event != nil && event["log"] != "not json" {
+		t.Errorf("Unexpected log field: %v", event["log"])
+	}
+
+	// A decoded message field is not moved as it was not the harvested line
+	event = receiveEvent(t, output, 70)
+	if event != nil {
+		if event["message"] != "decoded" {
+			t.Errorf("Unexpected message field: %v", event["message"])
+		}
+		if _, ok := event["log"]; ok {
+			t.Errorf("Unexpected log field: %v", event["log"])
+		}
+		if _, ok := event[codecs.MessageReplacedKey]; ok {
+			t.Errorf("Unexpected %s field", codecs.MessageReplacedKey)
+		}
+	}
+
+	h.Stop()
+	waitFinish(t, h)
+}
+
+func TestHarvesterIncludeOffset(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	cfg.General.IncludeOffset = true
+
+	dir, stream := createTestFile(t, []byte("first line\nsecond line\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	inode := float64(fileInode(stream.info))
+
+	// The offset field is the start of the line, not the resume offset
+	for _, expected := range []struct{ start, end int64 }{{0, 11}, {11, 23}} {
+		event := receiveEvent(t, output, expected.end)
+		if event == nil {
+			continue
+		}
+		if event["_offset"] != float64(expected.start) {
+			t.Errorf("Unexpected _offset field: %v (expected %d)", event["_offset"], expected.start)
+		}
+		if event["_inode"] != inode {
+			t.Errorf("Unexpected _inode field: %v (expected %v)", event["_inode"], inode)
+		}
+	}
+
+	h.Stop()
+	waitFinish(t, h)
+}
+
+func TestHarvesterCodecChain(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+
+	filter, err := codecs.NewFilterCodecFactory(cfg, "/stream/codecs[0]", map[string]interface{}{
+		"patterns": []string{"^DEBUG"},
+		"negate":   true,
+	}, "filter")
+	if err != nil {
+		t.Fatalf("Failed to create filter codec: %s", err)
+	}
+	multiline, err := codecs.NewMultilineCodecFactory(cfg, "/stream/codecs[1]", map[string]interface{}{
+		"patterns": []string{"^[[:space:]]"},
+		"what":     "previous",
+	}, "multiline")
+	if err != nil {
+		t.Fatalf("Failed to create multiline codec: %s", err)
+	}
+	streamConfig.Codecs = []config.CodecStub{
+		config.CodecStub{Name: "filter", Factory: filter},
+		config.CodecStub{Name: "multiline", Factory: multiline},
+	}
+
+	dir, stream := createTestFile(t, []byte("DEBUG noise\nfirst\n  continued\nDEBUG noise\nsecond\nDEBUG noise\nthird\nDEBUG noise\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	// Filtered lines must not reach the multiline codec, and the offsets must
+	// still be those of the lines that were read
+	checkEvent(t, output, "first\n  continued", 30)
+	checkEvent(t, output, "second", 49)
+
+	h.Stop()
+	status := waitFinish(t, h)
+
+	// The multiline codec flushes the last event during teardown, which is
+	// either sent, in which case the trailing filtered line is accounted for,
+	// or dropped because we are stopping, in which case we resume from it
+	select {
+	case <-output:
+		if status.LastEventOffset != 79 {
+			t.Errorf("Unexpected last event offset: %d (expected 79)", status.LastEventOffset)
+		}
+	default:
+		if status.LastEventOffset != 61 {
+			t.Errorf("Unexpected last event offset: %d (expected 61)", status.LastEventOffset)
+		}
+	}
+}
+
+func TestHarvesterTags(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	cfg.General.Tags = []string{"general"}
+	cfg.General.GlobalFields = map[string]interface{}{"tags": []interface{}{"global"}}
+	streamConfig.Fields = map[string]interface{}{"tags": "stream"}
+
+	tagger, err := codecs.NewTaggerCodecFactory(cfg, "/stream/codecs[0]", map[string]interface{}{
+		"patterns": []interface{}{
+			map[string]interface{}{"pattern": "ERROR", "add tag": []string{"alertable", "general"}},
+		},
+	}, "tagger")
+	if err != nil {
+		t.Fatalf("Failed to create tagger codec: %s", err)
+	}
+	streamConfig.Codecs = []config.CodecStub{config.CodecStub{Name: "tagger", Factory: tagger}}
+
+	dir, stream := createTestFile(t, []byte("ERROR line\nINFO line\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	// Tags from the codecs, fields and general tags are merged without duplicates
+	for _, expected := range []struct {
+		offset int64
+		tags   []interface{}
+	}{
+		{11, []interface{}{"alertable", "general", "global", "stream"}},
+		{21, []interface{}{"global", "stream", "general"}},
+	} {
+		event := receiveEvent(t, output, expected.offset)
+		if event != nil && !reflect.DeepEqual(event["tags"], expected.tags) {
+			t.Errorf("Unexpected tags: %v (expected %v)", event["tags"], expected.tags)
+		}
+	}
+
+	h.Stop()
+	waitFinish(t, h)
+}
+
+func TestHarvesterECS(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	cfg.General.ECSCompatibility = true
+	logField := map[string]interface{}{"level": "info", "offset": "user"}
+	streamConfig.Fields = map[string]interface{}{
+		"log":  logField,
+		"host": "user",
+	}
+
+	dir, stream := createTestFile(t, []byte("first\nsecond\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 6)
+	h.Start(output)
+
+	// ECS fields are nested, and configured fields are merged in without
+	// overwriting them
+	event := receiveEvent(t, output, 13)
+	if event != nil {
+		expected := map[string]interface{}{
+			"message": "second",
+			"host":    map[string]interface{}{"name": "localhost"},
+			"log": map[string]interface{}{
+				"level":  "info",
+				"offset": float64(6),
+				"file":   map[string]interface{}{"path": stream.path},
+			},
+		}
+		for key, value := range expected {
+			if !reflect.DeepEqual(event[key], value) {
+				t.Errorf("Unexpected %s: %v (expected %v)", key, event[key], value)
+			}
+		}
+		if _, ok := event["path"]; ok {
+			t.Errorf("Unexpected path field: %v", event["path"])
+		}
+		timestamp, _ := event["@timestamp"].(string)
+		if _, err := time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			t.Errorf("Invalid @timestamp: %v", event["@timestamp"])
+		}
+	}
+
+	// The configured field must not have been modified
+	if len(logField) != 2 {
+		t.Errorf("Configured field was modified: %v", logField)
+	}
+
+	h.Stop()
+	waitFinish(t, h)
+}
+
+func TestHarvesterTimestamp(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.Timestamp = config.Timestamp{
+		GoLayout: "2006-01-02 15:04:05Z07:00",
+		Regexp:   regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \S+)`),
+	}
+
+	multiline, err := codecs.NewMultilineCodecFactory(cfg, "/stream/codecs[0]", map[string]interface{}{
+		"patterns": []string{"^[[:space:]]"},
+		"what":     "previous",
+	}, "multiline")
+	if err != nil {
+		t.Fatalf("Failed to create multiline codec: %s", err)
+	}
+	streamConfig.Codecs = []config.CodecStub{config.CodecStub{Name: "multiline", Factory: multiline}}
+
+	dir, stream := createTestFile(t, []byte("2017-02-18 10:00:01Z first\n  2017-02-19 10:00:01Z continued\nbroken\n2017-02-18 10:00:03Z third\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	// The timestamp is taken from the first line of the joined event
+	event := receiveEvent(t, output, 60)
+	if event != nil {
+		if event["@timestamp"] != "2017-02-18T10:00:01Z" || event["tags"] != nil {
+			t.Errorf("Unexpected timestamp: %v (tags: %v)", event["@timestamp"], event["tags"])
+		}
+	}
+
+	// Failing to parse falls back to the time the line was read
+	event = receiveEvent(t, output, 67)
+	if event != nil {
+		timestamp, _ := event["@timestamp"].(string)
+		if parsed, err := time.Parse(time.RFC3339Nano, timestamp); err != nil || time.Since(parsed) > time.Minute {
+			t.Errorf("Unexpected fallback timestamp: %v", event["@timestamp"])
+		}
+		if !reflect.DeepEqual(event["tags"], []interface{}{"_timestampparsefailure"}) {
+			t.Errorf("Unexpected tags: %v", event["tags"])
+		}
+	}
+
+	h.Stop()
+	waitFinish(t, h)
+}
+
+func TestHarvesterSkipToPattern(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.SkipToRegexp = regexp.MustCompile("^[0-9]{4} ")
+
+	data := []byte("2017 first\n  continued\n2017 second\n")
+
+	dir, stream := createTestFile(t, data)
+	defer os.RemoveAll(dir)
+
+	// Starting from the beginning nothing is skipped
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	checkEvent(t, output, "2017 first", 11)
+	checkEvent(t, output, "  continued", 23)
+	checkEvent(t, output, "2017 second", 35)
+
+	h.Stop()
+	waitFinish(t, h)
+
+	// Starting part way through skips to the next matching line only
+	h = NewHarvester(stream, cfg, streamConfig, 5)
+	h.Start(output)
+
+	checkEvent(t, output, "2017 second", 35)
+
+	h.Stop()
+	waitFinish(t, h)
+
+	select {
+	case desc := <-output:
+		t.Errorf("Unexpected event: %s", desc.Event)
+	default:
+	}
+}
+
+func TestHarvesterMaxLineTruncate(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.MaxLineBytes = 10
+	streamConfig.MaxLineAction = "truncate"
+
+	dir, stream := createTestFile(t, []byte("short\n0123456789abcdefghij\nnext\n"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	checkEvent(t, output, "short", 6)
+
+	// The offset must cover the discarded remainder so it is not read again
+	event := receiveEvent(t, output, 27)
+	if event != nil {
+		if event["message"] != "0123456789" {
+			t.Errorf("Unexpected message: %v", event["message"])
+		}
+		if tags, ok := event["tags"].([]interface{}); !ok || len(tags) != 1 || tags[0] != "_linetoolong" {
+			t.Errorf("Truncated line was not tagged: %v", event["tags"])
+		}
+	}
+
+	event = receiveEvent(t, output, 32)
+	if event != nil {
+		if event["message"] != "next" {
+			t.Errorf("Unexpected message: %v", event["message"])
+		}
+		if _, ok := event["tags"]; ok {
+			t.Errorf("Line after truncated line was tagged: %v", event["tags"])
+		}
+	}
+
+	h.Stop()
+	waitFinish(t, h)
+}
+
+func TestHarvesterMaxLineTruncateEOF(t *testing.T) {
+	cfg, streamConfig := createTestConfig(t)
+	streamConfig.MaxLineBytes = 10
+	streamConfig.MaxLineAction = "truncate"
+	streamConfig.ReadOnce = true
+
+	dir, stream := createTestFile(t, []byte("short\n0123456789abcdefghijkl"))
+	defer os.RemoveAll(dir)
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(stream, cfg, streamConfig, 0)
+	h.Start(output)
+
+	checkEvent(t, output, "short", 6)
+
+	// A truncated line with no line ending is shipped when the harvester stops
+	event := receiveEvent(t, output, 26)
+	if event != nil {
+		if event["message"] != "0123456789" {
+			t.Errorf("Unexpected message: %v", event["message"])
+		}
+		if tags, ok := event["tags"].([]interface{}); !ok || len(tags) != 1 || tags[0] != "_linetoolong" {
+			t.Errorf("Truncated line was not tagged: %v", event["tags"])
+		}
+	}
+
+	status := waitFinish(t, h)
+	if status.Error != nil || status.LastEventOffset != 26 {
+		t.Errorf("Unexpected finish status: %v", status)
+	}
+}
@@ -19,6 +19,8 @@ package harvester
 import (
 	"os"
 	"syscall"
+
+	"github.com/driskell/log-courier/lc-lib/registrar"
 )
 
 func (h *Harvester) openFile(path string) (*os.File, error) {
@@ -40,3 +42,11 @@ func (h *Harvester) openFile(path string) (*os.File, error) {
 
 	return os.NewFile(uintptr(handle), path), nil
 }
+
+// fileInode returns the file index number of the file, which is the Windows
+// equivalent of an inode number
+func fileInode(info os.FileInfo) uint64 {
+	state := &registrar.FileStateOS{}
+	state.PopulateFileIds(info)
+	return uint64(state.IdxHi)<<32 | uint64(state.IdxLo)
+}
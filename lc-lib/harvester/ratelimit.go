@@ -0,0 +1,63 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"time"
+)
+
+// rateLimiter is a token bucket that limits the rate at which events are
+// emitted whilst still allowing short bursts. The bucket holds up to one
+// second's worth of tokens
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a new rateLimiter allowing the given number of events
+// per second, starting with a full bucket
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// take consumes a token for a single event and returns how long to wait
+// before the event can be emitted. If the bucket is empty the token is
+// borrowed so that the delay applies to the next event as well
+func (r *rateLimiter) take() time.Duration {
+	return r.takeAt(time.Now())
+}
+
+// takeAt is take, using the given time as the current time
+func (r *rateLimiter) takeAt(now time.Time) time.Duration {
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.rate {
+		r.tokens = r.rate
+	}
+	r.last = now
+
+	r.tokens--
+	if r.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-r.tokens / r.rate * float64(time.Second))
+}
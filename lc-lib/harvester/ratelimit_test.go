@@ -0,0 +1,69 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package harvester
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	limiter := newRateLimiter(10)
+	now := limiter.last
+
+	// A full second's worth of events is allowed immediately
+	for i := 0; i < 10; i++ {
+		if delay := limiter.takeAt(now); delay != 0 {
+			t.Fatalf("Event %d was delayed by %s within the burst", i, delay)
+		}
+	}
+
+	if delay := limiter.takeAt(now); delay != 100*time.Millisecond {
+		t.Errorf("Unexpected delay after the burst: %s", delay)
+	}
+
+	// The borrowed token must be repaid before another is available
+	if delay := limiter.takeAt(now); delay != 200*time.Millisecond {
+		t.Errorf("Unexpected delay for second event after the burst: %s", delay)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	limiter := newRateLimiter(10)
+	now := limiter.last
+
+	for i := 0; i < 10; i++ {
+		limiter.takeAt(now)
+	}
+
+	// After 100ms one token should have been added
+	now = now.Add(100 * time.Millisecond)
+	if delay := limiter.takeAt(now); delay != 0 {
+		t.Errorf("Event was delayed by %s after refill", delay)
+	}
+
+	// Refill never exceeds the burst size
+	now = now.Add(10 * time.Second)
+	for i := 0; i < 10; i++ {
+		if delay := limiter.takeAt(now); delay != 0 {
+			t.Fatalf("Event %d was delayed by %s within the burst", i, delay)
+		}
+	}
+	if delay := limiter.takeAt(now); delay == 0 {
+		t.Error("Event was not delayed after the burst")
+	}
+}
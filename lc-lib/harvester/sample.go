@@ -0,0 +1,56 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// sampleSeed varies the selection made by samplers on each restart
+var sampleSeed = uint64(time.Now().UnixNano())
+
+// sampler decides which events to keep when only a sample of a file is wanted.
+// The decision is made from a hash of the offset of each event rather than a
+// running sequence, so it is spread evenly through the file and is the same
+// if an event is read again, until the next restart
+type sampler struct {
+	rate float64
+	key  uint64
+}
+
+func newSampler(rate float64, path string) *sampler {
+	hash := fnv.New64a()
+	hash.Write([]byte(path))
+
+	return &sampler{
+		rate: rate,
+		key:  sampleSeed ^ hash.Sum64(),
+	}
+}
+
+// keep returns true if the event at the given offset is part of the sample
+func (s *sampler) keep(offset int64) bool {
+	// splitmix64 finaliser, so that consecutive offsets are well distributed
+	value := s.key + uint64(offset)*0x9e3779b97f4a7c15
+	value = (value ^ (value >> 30)) * 0xbf58476d1ce4e5b9
+	value = (value ^ (value >> 27)) * 0x94d049bb133111eb
+	value ^= value >> 31
+
+	// Use the top 53 bits as a fraction in [0, 1)
+	return float64(value>>11)/(1<<53) < s.rate
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSamplerRate(t *testing.T) {
+	s := newSampler(0.25, "/var/log/test.log")
+
+	// The sample should be the right size throughout the file, not only overall
+	for _, start := range []int64{0, 1000000} {
+		kept := 0
+		for offset := start; offset < start+100000; offset += 10 {
+			if s.keep(offset) {
+				kept++
+			}
+		}
+		if rate := float64(kept) / 10000; math.Abs(rate-0.25) > 0.05 {
+			t.Errorf("Unexpected sample rate from offset %d: %f", start, rate)
+		}
+	}
+}
+
+func TestSamplerDeterministic(t *testing.T) {
+	first := newSampler(0.5, "/var/log/test.log")
+	second := newSampler(0.5, "/var/log/test.log")
+
+	for offset := int64(0); offset < 10000; offset += 10 {
+		if first.keep(offset) != second.keep(offset) {
+			t.Fatalf("Different decision for offset %d", offset)
+		}
+	}
+}
+
+func TestSamplerNone(t *testing.T) {
+	s := newSampler(0, "/var/log/test.log")
+
+	for offset := int64(0); offset < 10000; offset += 10 {
+		if s.keep(offset) {
+			t.Fatalf("Event at offset %d was kept with a sample rate of 0", offset)
+		}
+	}
+}
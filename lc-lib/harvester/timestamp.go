@@ -0,0 +1,62 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"strings"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+// parseTimestamp extracts the timestamp from the first line of the message
+// using the pattern and layout configured. If the pattern has a group the
+// first group is parsed, otherwise the whole match is. A timestamp without a
+// zone is local, and one without a year, such as syslog, is given the year
+// from now
+func parseTimestamp(timestampConfig *config.Timestamp, message string, now time.Time) (time.Time, bool) {
+	if idx := strings.IndexByte(message, '\n'); idx != -1 {
+		message = message[:idx]
+	}
+
+	match := timestampConfig.Regexp.FindStringSubmatch(message)
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	value := match[0]
+	if len(match) > 1 {
+		value = match[1]
+	}
+
+	parsed, err := time.ParseInLocation(timestampConfig.GoLayout, value, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	if parsed.Year() == 0 {
+		parsed = time.Date(now.Year(), parsed.Month(), parsed.Day(), parsed.Hour(), parsed.Minute(), parsed.Second(), parsed.Nanosecond(), parsed.Location())
+
+		// More than a day ahead means the event is from the end of last year,
+		// such as when reading the logs for December in January
+		if parsed.After(now.AddDate(0, 0, 1)) {
+			parsed = parsed.AddDate(-1, 0, 0)
+		}
+	}
+
+	return parsed, true
+}
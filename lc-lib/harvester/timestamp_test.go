@@ -0,0 +1,79 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	timestampConfig := &config.Timestamp{
+		GoLayout: "02/Jan/2006:15:04:05 -0700",
+		Regexp:   regexp.MustCompile(`\[([^]]+)\]`),
+	}
+	now := time.Date(2017, 2, 20, 0, 0, 0, 0, time.UTC)
+
+	parsed, ok := parseTimestamp(timestampConfig, "127.0.0.1 - - [18/Feb/2017:10:00:01 +0100] \"GET / HTTP/1.1\" 200 12", now)
+	if !ok || !parsed.Equal(time.Date(2017, 2, 18, 9, 0, 1, 0, time.UTC)) {
+		t.Errorf("Unexpected timestamp: %s (%t)", parsed, ok)
+	}
+
+	// Only the first line of a multiline event is searched
+	if _, ok := parseTimestamp(timestampConfig, "no timestamp\n[18/Feb/2017:10:00:01 +0100]", now); ok {
+		t.Error("Timestamp was found beyond the first line")
+	}
+
+	if _, ok := parseTimestamp(timestampConfig, "[not a timestamp]", now); ok {
+		t.Error("Timestamp was parsed from an invalid value")
+	}
+}
+
+func TestParseTimestampWholeMatch(t *testing.T) {
+	timestampConfig := &config.Timestamp{
+		GoLayout: "2006-01-02 15:04:05Z07:00",
+		Regexp:   regexp.MustCompile(`\d{4}-\d{2}-\d{2} \S+`),
+	}
+
+	parsed, ok := parseTimestamp(timestampConfig, "INFO 2017-02-18 10:00:01Z started", time.Now())
+	if !ok || !parsed.Equal(time.Date(2017, 2, 18, 10, 0, 1, 0, time.UTC)) {
+		t.Errorf("Unexpected timestamp: %s (%t)", parsed, ok)
+	}
+}
+
+func TestParseTimestampNoYear(t *testing.T) {
+	timestampConfig := &config.Timestamp{
+		GoLayout: time.Stamp,
+		Regexp:   regexp.MustCompile(`^(\w{3} [ \d]\d \d{2}:\d{2}:\d{2})`),
+	}
+
+	now := time.Date(2017, 2, 20, 12, 0, 0, 0, time.Local)
+	parsed, ok := parseTimestamp(timestampConfig, "Feb  8 10:00:01 host app: message", now)
+	if !ok || !parsed.Equal(time.Date(2017, 2, 8, 10, 0, 1, 0, time.Local)) {
+		t.Errorf("Unexpected timestamp: %s (%t)", parsed, ok)
+	}
+
+	// December logs read in January are from last year
+	now = time.Date(2017, 1, 2, 12, 0, 0, 0, time.Local)
+	parsed, ok = parseTimestamp(timestampConfig, "Dec 31 23:59:59 host app: message", now)
+	if !ok || !parsed.Equal(time.Date(2016, 12, 31, 23, 59, 59, 0, time.Local)) {
+		t.Errorf("Unexpected timestamp: %s (%t)", parsed, ok)
+	}
+}
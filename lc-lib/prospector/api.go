@@ -37,11 +37,21 @@ func (a *apiStatus) Update() error {
 	a.p.mutex.RLock()
 	a.SetEntry("watchedFiles", admin.APINumber(len(a.p.prospectorindex)))
 	a.SetEntry("activeStates", admin.APINumber(len(a.p.prospectors)))
+	a.SetEntry("activeHarvesters", admin.APINumber(a.p.activeHarvesters))
+	a.SetEntry("queuedHarvesters", admin.APINumber(len(a.p.pending)))
 	a.p.mutex.RUnlock()
 
 	return nil
 }
 
+// metrics adds the prospector metrics to a metrics request
+func (p *Prospector) metrics(m *admin.Metrics) {
+	p.mutex.RLock()
+	m.Gauge("log_courier_harvesters_active", "Number of harvesters currently reading files", float64(p.activeHarvesters))
+	m.Gauge("log_courier_harvesters_queued", "Number of harvesters waiting to start because of the max active harvesters limit", float64(len(p.pending)))
+	p.mutex.RUnlock()
+}
+
 type apiFiles struct {
 	admin.APIArray
 
@@ -107,6 +117,12 @@ func (a *apiFiles) processEntry(info *prospectorInfo) {
 	case statusResume:
 		status = "resuming"
 		errString = admin.APINull
+	case statusCompleted:
+		status = "completed"
+		errString = admin.APINull
+	case statusPending:
+		status = "queued"
+		errString = admin.APINull
 	case statusFailed:
 		status = "failed"
 		errString = admin.APIString(info.err.Error())
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// recursiveWildcard is the path component that matches any number of nested
+// directories, including none
+const recursiveWildcard = "**"
+
+// globRecursive returns the files matching the given pattern in the same way
+// as filepath.Glob, but additionally allows "**" as a path component to match
+// directories at any depth, up to the given maximum below the directory the
+// "**" appears in. Symlinked directories are followed but each directory is
+// only visited once, so symlink loops are not traversed repeatedly
+func globRecursive(pattern string, maxDepth int64) ([]string, error) {
+	components := strings.Split(pattern, string(filepath.Separator))
+
+	index := -1
+	for i, component := range components {
+		if component == recursiveWildcard {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return filepath.Glob(pattern)
+	}
+
+	prefix := strings.Join(components[:index], string(filepath.Separator))
+	if index == 0 {
+		prefix = "."
+	} else if prefix == "" {
+		prefix = string(filepath.Separator)
+	}
+
+	// A trailing "**" matches all files within the tree, but not the
+	// directories themselves
+	rest := strings.Join(components[index+1:], string(filepath.Separator))
+	filesOnly := rest == ""
+	if filesOnly {
+		rest = "*"
+	}
+
+	bases, err := filepath.Glob(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var matches []string
+
+	for _, base := range bases {
+		var dirs []string
+		walkDirectories(base, maxDepth, make(map[string]bool), &dirs)
+
+		for _, dir := range dirs {
+			dirMatches, err := globRecursive(filepath.Join(dir, rest), maxDepth)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, match := range dirMatches {
+				if filesOnly {
+					if info, err := os.Stat(match); err != nil || info.IsDir() {
+						continue
+					}
+				}
+
+				if !seen[match] {
+					seen[match] = true
+					matches = append(matches, match)
+				}
+			}
+		}
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// walkDirectories appends the given directory and all directories beneath it,
+// up to the given depth, to the list. The visited map holds the resolved path
+// of each directory already appended so that symlink loops are skipped
+func walkDirectories(dir string, depth int64, visited map[string]bool, dirs *[]string) {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return
+	}
+
+	if visited[resolved] {
+		log.Debug("Skipping directory already scanned (possible symlink loop): %s", dir)
+		return
+	}
+	visited[resolved] = true
+
+	*dirs = append(*dirs, dir)
+
+	if depth == 0 {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Warning("Failed to read directory %s: %s", dir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+
+		if entry.Mode()&os.ModeSymlink != 0 {
+			// Follow symlinks so that symlinked directories are traversed
+			if entry, err = os.Stat(path); err != nil {
+				continue
+			}
+		}
+
+		if entry.IsDir() {
+			walkDirectories(path, depth-1, visited, dirs)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func createTestTree(t *testing.T, files ...string) string {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("Failed to create directory: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("test\n"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %s", err)
+		}
+	}
+
+	return dir
+}
+
+func checkGlob(t *testing.T, dir string, pattern string, maxDepth int64, expected ...string) {
+	matches, err := globRecursive(filepath.Join(dir, pattern), maxDepth)
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %s", pattern, err)
+	}
+
+	for i := range expected {
+		expected[i] = filepath.Join(dir, expected[i])
+	}
+
+	if len(matches) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Errorf("Unexpected matches for %s: %v (expected %v)", pattern, matches, expected)
+	}
+}
+
+func TestGlobRecursive(t *testing.T) {
+	dir := createTestTree(t, "current.log", "a/current.log", "a/b/current.log", "a/b/other.log", "c/d/e/current.log")
+	defer os.RemoveAll(dir)
+
+	checkGlob(t, dir, "**/current.log", 16, "a/b/current.log", "a/current.log", "c/d/e/current.log", "current.log")
+	checkGlob(t, dir, "a/**/*.log", 16, "a/b/current.log", "a/b/other.log", "a/current.log")
+	checkGlob(t, dir, "*/**/current.log", 16, "a/b/current.log", "a/current.log", "c/d/e/current.log")
+	checkGlob(t, dir, "a/**", 16, "a/b/current.log", "a/b/other.log", "a/current.log")
+	checkGlob(t, dir, "*/current.log", 16, "a/current.log")
+}
+
+func TestGlobRecursiveNewDirectory(t *testing.T) {
+	dir := createTestTree(t, "tenant1/current.log")
+	defer os.RemoveAll(dir)
+
+	checkGlob(t, dir, "**/current.log", 16, "tenant1/current.log")
+
+	if err := os.MkdirAll(filepath.Join(dir, "tenant2"), 0700); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tenant2", "current.log"), []byte("test\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	checkGlob(t, dir, "**/current.log", 16, "tenant1/current.log", "tenant2/current.log")
+}
+
+func TestGlobRecursiveMaxDepth(t *testing.T) {
+	dir := createTestTree(t, "current.log", "a/current.log", "a/b/current.log", "a/b/c/current.log")
+	defer os.RemoveAll(dir)
+
+	checkGlob(t, dir, "**/current.log", 0, "current.log")
+	checkGlob(t, dir, "**/current.log", 1, "a/current.log", "current.log")
+	checkGlob(t, dir, "**/current.log", 2, "a/b/current.log", "a/current.log", "current.log")
+}
+
+func TestGlobRecursiveSymlinkLoop(t *testing.T) {
+	dir := createTestTree(t, "a/current.log")
+	defer os.RemoveAll(dir)
+
+	if err := os.Symlink(dir, filepath.Join(dir, "a", "loop")); err != nil {
+		t.Skipf("Unable to create symlink: %s", err)
+	}
+
+	checkGlob(t, dir, "**/current.log", 16, "a/current.log")
+}
@@ -20,6 +20,7 @@ import (
 	"os"
 
 	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/harvester"
 	"github.com/driskell/log-courier/lc-lib/registrar"
 )
@@ -29,6 +30,8 @@ const (
 	statusResume
 	statusFailed
 	statusInvalid
+	statusCompleted
+	statusPending
 )
 
 const (
@@ -43,17 +46,37 @@ type prospectorInfo struct {
 	lastSeen     uint32
 	status       int
 	running      bool
+	stopping     bool
 	orphaned     int
 	finishOffset int64
 	harvester    *harvester.Harvester
 	err          error
+
+	// pendingCompletion is set when a read once harvester completes and the
+	// registrar has not yet been informed
+	pendingCompletion bool
+
+	// pendingSkip is set when a harvester stops with events at the end that were
+	// never sent, and so will never be acknowledged, and the registrar has not
+	// yet been informed. sentOffset is the end of the last event sent
+	pendingSkip bool
+	sentOffset  int64
+
+	// pendingConfig is the configuration to start the harvester with when it is
+	// queued due to the maximum active harvester limit
+	pendingConfig *config.File
 }
 
 func newProspectorInfoFromFileState(file string, filestate *registrar.FileState) *prospectorInfo {
+	status := statusResume
+	if filestate.Completed {
+		status = statusCompleted
+	}
+
 	return &prospectorInfo{
 		file:         file,
 		identity:     filestate,
-		status:       statusResume,
+		status:       status,
 		finishOffset: filestate.Offset,
 	}
 }
@@ -92,9 +115,10 @@ func (pi *prospectorInfo) isRunning() bool {
 }
 
 func (pi *prospectorInfo) stop() {
-	if !pi.running {
+	if !pi.running || pi.stopping {
 		return
 	}
+	pi.stopping = true
 	pi.harvester.Stop()
 }
 
@@ -112,12 +136,18 @@ func (pi *prospectorInfo) apiEncodable() admin.APIEncodable {
 
 func (pi *prospectorInfo) setHarvesterStopped(status *harvester.FinishStatus) {
 	pi.running = false
+	pi.stopping = false
 	// Resume harvesting from the last event offset, not the last read, to allow codec to read from the last event
 	// This ensures multiline codec populates correctly on resume
 	pi.finishOffset = status.LastEventOffset
+	pi.sentOffset = status.LastSentOffset
+	pi.pendingSkip = status.LastSentOffset < status.LastEventOffset
 	if status.Error != nil {
 		pi.status = statusFailed
 		pi.err = status.Error
+	} else if status.Completed {
+		pi.status = statusCompleted
+		pi.pendingCompletion = true
 	}
 	if status.LastStat != nil {
 		// Keep the last stat the harvester ran so we compare timestamps for potential resume
@@ -51,6 +51,10 @@ type Prospector struct {
 	registrar       registrar.Registrator
 	registrarSpool  registrar.EventSpooler
 
+	// Harvesters are queued here when max active harvesters is reached
+	activeHarvesters int
+	pending          []*prospectorInfo
+
 	output chan<- *core.EventDescriptor
 }
 
@@ -73,6 +77,8 @@ func NewProspector(pipeline *core.Pipeline, config *config.Config, fromBeginning
 	ret.initAPI()
 
 	if err := ret.init(); err != nil {
+		// Release the registrar so it can still shut down
+		ret.registrarSpool.Close()
 		return nil, err
 	}
 
@@ -125,9 +131,13 @@ func (p *Prospector) Run() {
 	}
 	for _, info := range p.prospectors {
 		info.wait()
+		p.reportFinished(info)
 	}
 	p.mutex.Unlock()
 
+	// Flush any completions
+	p.registrarSpool.Send()
+
 	// Disconnect from the registrar
 	p.registrarSpool.Close()
 
@@ -140,6 +150,10 @@ func (p *Prospector) runOnce() bool {
 	newlastscan := time.Now()
 	p.iteration++ // Overflow is allowed
 
+	p.mutex.Lock()
+	p.startPending()
+	p.mutex.Unlock()
+
 	for configKey, config := range p.config.Files {
 		for _, path := range config.Paths {
 			p.scan(path, &p.config.Files[configKey])
@@ -153,11 +167,7 @@ func (p *Prospector) runOnce() bool {
 	// Clean up the prospector collections
 	p.mutex.Lock()
 	for _, info := range p.prospectors {
-		if info.orphaned >= orphanedMaybe {
-			if !info.isRunning() {
-				delete(p.prospectors, info)
-			}
-		} else {
+		if info.orphaned == orphanedNo {
 			if info.lastSeen >= p.iteration {
 				continue
 			}
@@ -165,9 +175,21 @@ func (p *Prospector) runOnce() bool {
 			info.orphaned = orphanedMaybe
 		}
 		if info.orphaned == orphanedMaybe {
+			// Keep it for at least another scan so we can still detect a rename
 			info.orphaned = orphanedYes
-			p.registrarSpool.Add(registrar.NewDeletedEvent(info))
+			if info.isRunning() {
+				log.Info("File is no longer found, harvester will stop at the end of the file: %s", info.file)
+				info.harvester.FinishAtEOF()
+			}
+			continue
 		}
+		// The registrar state must remain until the harvester stops, as it may
+		// still be acknowledging events or the file may yet be found renamed
+		if info.isRunning() {
+			continue
+		}
+		delete(p.prospectors, info)
+		p.registrarSpool.Add(registrar.NewDeletedEvent(info))
 	}
 	p.mutex.Unlock()
 
@@ -203,7 +225,7 @@ DelayLoop:
 // scan crawls a path for file movements
 func (p *Prospector) scan(path string, config *config.File) {
 	// Evaluate the path as a wildcards/shell glob
-	matches, err := filepath.Glob(path)
+	matches, err := globRecursive(path, config.MaxRecursionDepth)
 	if err != nil {
 		log.Error("glob(%s) failed: %v", path, err)
 		return
@@ -211,10 +233,28 @@ func (p *Prospector) scan(path string, config *config.File) {
 
 	// Check any matched files to see if we need to start a harvester
 	for _, file := range matches {
+		if p.isExcluded(file, config) {
+			continue
+		}
+
 		p.processFile(file, config)
 	}
 }
 
+// isExcluded returns true if the base name of the given file matches any of
+// the exclude patterns of the file group
+func (p *Prospector) isExcluded(file string, config *config.File) bool {
+	base := filepath.Base(file)
+	for _, exclude := range config.Exclude {
+		// Patterns were validated during configuration load
+		if matched, _ := filepath.Match(exclude, base); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
 // processFile works out if a single discovered file has moved or is new etc.
 func (p *Prospector) processFile(file string, config *config.File) {
 	defer func() {
@@ -292,9 +332,18 @@ func (p *Prospector) processFile(file string, config *config.File) {
 			// This is a new entry
 			info = newProspectorInfoFromFileInfo(file, fileinfo)
 
-			// Check for dead time, but only if the file modification time is before the last scan started
-			// This ensures we don't skip genuine creations with dead times less than 10s
-			if fileinfo.ModTime().Before(p.lastscan) && time.Since(fileinfo.ModTime()) > config.DeadTime {
+			if config.Compression != "none" {
+				// Compressed files are only read once, so dead time does not apply
+				log.Info("Launching harvester on new compressed file: %s", file)
+				p.startHarvester(info, config)
+			} else if config.ReadOnce {
+				// Read once files are often old files being imported, so dead time
+				// does not apply
+				log.Info("Launching harvester on new read once file: %s", file)
+				p.startHarvester(info, config)
+			} else if fileinfo.ModTime().Before(p.lastscan) && time.Since(fileinfo.ModTime()) > config.DeadTime {
+				// Check for dead time, but only if the file modification time is before the last scan started
+				// This ensures we don't skip genuine creations with dead times less than 10s
 				// Old file, skip it, but push offset of file size so we start from the end if this file changes and needs picking up
 				log.Info("Skipping file (older than dead time of %v): %s", config.DeadTime, file)
 
@@ -332,6 +381,9 @@ func (p *Prospector) processFile(file string, config *config.File) {
 				// File is not the same file we saw previously, it must have rotated and is a new file
 				log.Info("Launching harvester on rotated file: %s", file)
 
+				// Look for where the previous file was rotated to so it can be drained
+				p.findRotated(info, config)
+
 				// Forget about the previous harvester and let it continue on the old file - so start a new channel to use with the new harvester
 				info = newProspectorInfoFromFileInfo(file, fileinfo)
 
@@ -347,7 +399,24 @@ func (p *Prospector) processFile(file string, config *config.File) {
 	// Resume stopped harvesters
 	resume := !info.isRunning()
 	if resume {
-		if info.status == statusResume {
+		p.reportFinished(info)
+
+		if info.status == statusCompleted {
+			// Read once files are never resumed, unless the file was truncated
+			// and rewritten. The offset of a compressed file is within the
+			// decompressed data so can not be compared with the file size
+			if config.Compression == "none" && fileinfo.Size() < info.finishOffset {
+				log.Info("Restarting harvester on a completed read once file that was truncated: %s", file)
+				p.registrarSpool.Add(registrar.NewResetEvent(info))
+				info.status = statusOk
+				info.finishOffset = 0
+			} else {
+				resume = false
+			}
+		} else if info.status == statusPending {
+			// Already queued to start
+			resume = false
+		} else if info.status == statusResume {
 			if info.finishOffset == fileinfo.Size() && time.Since(fileinfo.ModTime()) > config.DeadTime {
 				// Old file with an unchanged offset, skip it
 				log.Info("Skipping file (older than dead time of %v): %s", config.DeadTime, file)
@@ -377,6 +446,55 @@ func (p *Prospector) processFile(file string, config *config.File) {
 	p.prospectorindex[file] = info
 }
 
+// findRotated looks for a file that was rotated away from its path using the
+// rotation patterns of the file group. If found, it is tracked at its new path
+// and, if its harvester had stopped, a harvester is started to read it to the
+// end from where it left off, so that lines written to it just before rotation
+// are not missed
+func (p *Prospector) findRotated(info *prospectorInfo, config *config.File) {
+	if len(config.Rotation) == 0 || info.status == statusInvalid {
+		return
+	}
+
+	dir := filepath.Dir(info.file)
+	for _, pattern := range config.Rotation {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+
+		// Patterns were validated during configuration load
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			// Known files are handled by the usual rename detection
+			if _, isKnown := p.prospectorindex[match]; isKnown {
+				continue
+			}
+
+			fileinfo, err := os.Stat(match)
+			if err != nil || !info.identity.SameAs(fileinfo) {
+				continue
+			}
+
+			log.Info("Rotated file was found using the rotation patterns: %s -> %s", info.file, match)
+			info.file = match
+			info.orphaned = orphanedNo
+			info.update(fileinfo, p.iteration)
+			p.prospectorindex[match] = info
+			p.registrarSpool.Add(registrar.NewRenamedEvent(info, match))
+
+			if !info.isRunning() && (info.status == statusOk || info.status == statusResume) && info.finishOffset < fileinfo.Size() {
+				// The rotated file will not be written to again, so read it once to
+				// the end, after which it is forgotten unless it matches the paths
+				log.Info("Draining rotated file: %s", match)
+				drainConfig := *config
+				drainConfig.ReadOnce = true
+				p.startHarvesterWithOffset(info, &drainConfig, info.finishOffset)
+			}
+			return
+		}
+	}
+}
+
 // flagDuplicateError notes a file as a duplicate of another file (symlink?)
 // and only reports an error to the log if it wasn't already noted before
 func (p *Prospector) flagDuplicateError(file string, info *prospectorInfo) {
@@ -396,11 +514,30 @@ func (p *Prospector) flagDuplicateError(file string, info *prospectorInfo) {
 	p.prospectorindex[file] = info
 }
 
+// reportFinished informs the registrar of events at the end of a stopped
+// harvester that were never sent, so that the offset moves beyond them, and of
+// a completed read once file so it is not harvested again after a restart
+func (p *Prospector) reportFinished(info *prospectorInfo) {
+	if info.pendingSkip {
+		p.registrarSpool.Add(registrar.NewSkippedEvent(info, info.sentOffset, info.finishOffset))
+		info.pendingSkip = false
+	}
+
+	if !info.pendingCompletion {
+		return
+	}
+
+	p.registrarSpool.Add(registrar.NewCompletedEvent(info, info.finishOffset))
+	info.pendingCompletion = false
+}
+
 // startHarvester starts a new harvester against a file
 func (p *Prospector) startHarvester(info *prospectorInfo, fileconfig *config.File) {
 	var offset int64
 
-	if p.fromBeginning {
+	// Compressed and read once files can not be tailed so are always read from
+	// the beginning
+	if p.fromBeginning || fileconfig.StartPosition == "beginning" || fileconfig.Compression != "none" || fileconfig.ReadOnce {
 		offset = 0
 	} else {
 		offset = info.identity.Stat().Size()
@@ -415,11 +552,71 @@ func (p *Prospector) startHarvester(info *prospectorInfo, fileconfig *config.Fil
 // startHarvesterWithOffset starts a new harvester against a file starting at
 // the given offset
 func (p *Prospector) startHarvesterWithOffset(info *prospectorInfo, fileconfig *config.File, offset int64) {
+	if max := p.config.General.MaxActiveHarvesters; max != 0 && int64(p.activeHarvesters) >= max {
+		log.Info("Queueing harvester as maximum of %d active harvesters is reached: %s", max, info.file)
+		info.status = statusPending
+		info.pendingConfig = fileconfig
+		info.finishOffset = offset
+		p.pending = append(p.pending, info)
+		return
+	}
+
 	// TODO - hook in a shutdown channel
 	info.harvester = harvester.NewHarvester(info, p.config, &fileconfig.Stream, offset)
 	info.running = true
 	info.status = statusOk
+	info.pendingConfig = nil
 	info.harvester.Start(p.output)
+	p.activeHarvesters++
+}
+
+// startPending recounts the active harvesters and starts queued harvesters
+// whilst there is capacity to do so. If harvesters remain queued afterwards,
+// idle harvesters are stopped so they can make room on the next scan, and will
+// be resumed as normal if their files are modified
+func (p *Prospector) startPending() {
+	p.activeHarvesters = 0
+	for _, info := range p.prospectors {
+		if info.isRunning() {
+			p.activeHarvesters++
+		}
+	}
+
+	max := p.config.General.MaxActiveHarvesters
+	for len(p.pending) != 0 {
+		if max != 0 && int64(p.activeHarvesters) >= max {
+			break
+		}
+
+		info := p.pending[0]
+		p.pending = p.pending[1:]
+
+		// Skip entries that disappeared whilst they were queued
+		if info.status != statusPending {
+			continue
+		}
+		if info.orphaned != orphanedNo {
+			// Resume from the queued offset if the file is found again
+			info.status = statusResume
+			continue
+		}
+
+		log.Info("Launching queued harvester: %s", info.file)
+		p.startHarvesterWithOffset(info, info.pendingConfig, info.finishOffset)
+	}
+
+	needed := len(p.pending)
+	for _, info := range p.prospectors {
+		if needed == 0 {
+			break
+		}
+
+		if info.running && !info.stopping && info.harvester.IsIdle() {
+			log.Info("Stopping idle harvester to make room for queued harvesters: %s", info.file)
+			info.stop()
+			needed--
+		}
+	}
 }
 
 // lookupFileIds checks a file's filesystem identifiers against all other known
@@ -465,4 +662,5 @@ func (p *Prospector) initAPI() {
 	prospectorAPI.SetEntry("status", &apiStatus{p: p})
 
 	p.adminConfig.SetEntry("prospector", prospectorAPI)
+	p.adminConfig.SetMetrics("prospector", p.metrics)
 }
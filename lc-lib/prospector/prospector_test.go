@@ -0,0 +1,562 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/codecs"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+)
+
+type testEventSpool struct {
+	events []registrar.EventProcessor
+}
+
+func (s *testEventSpool) Close() {}
+
+func (s *testEventSpool) Add(event registrar.EventProcessor) {
+	s.events = append(s.events, event)
+}
+
+func (s *testEventSpool) Send() {}
+
+func createTestProspector(t *testing.T) (*Prospector, *config.File, chan *core.EventDescriptor) {
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	cfg.General.Host = "localhost"
+
+	fileConfig := &config.File{}
+	fileConfig.InitDefaults()
+	fileConfig.Stream.InitDefaults()
+	fileConfig.DeadTime = cfg.General.DeadTime
+	fileConfig.MessageField = cfg.General.MessageField
+	fileConfig.MaxLineBytes = cfg.General.MaxLineBytes
+
+	factory, err := codecs.NewPlainCodecFactory(cfg, "/files[0]/codecs[0]", nil, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+	fileConfig.Codecs = []config.CodecStub{config.CodecStub{Name: "plain", Factory: factory}}
+
+	output := make(chan *core.EventDescriptor, 10)
+	p := &Prospector{
+		config:          cfg,
+		prospectorindex: make(map[string]*prospectorInfo),
+		prospectors:     make(map[*prospectorInfo]*prospectorInfo),
+		fromBeginning:   true,
+		registrarSpool:  &testEventSpool{},
+		output:          output,
+	}
+
+	return p, fileConfig, output
+}
+
+func receiveTestEvent(t *testing.T, output <-chan *core.EventDescriptor) map[string]interface{} {
+	select {
+	case desc := <-output:
+		var event map[string]interface{}
+		if err := json.Unmarshal(desc.Event, &event); err != nil {
+			t.Fatalf("Failed to decode event: %s", err)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for event")
+	}
+	return nil
+}
+
+func stopTestProspector(p *Prospector) {
+	for _, info := range p.prospectors {
+		info.stop()
+	}
+	for _, info := range p.prospectors {
+		info.wait()
+	}
+}
+
+func TestProspectorRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, fileConfig, output := createTestProspector(t)
+	fileConfig.Rotation = []string{"test.log.1"}
+	defer stopTestProspector(p)
+
+	path := filepath.Join(dir, "test.log")
+	if err := ioutil.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	p.iteration++
+	p.processFile(path, fileConfig)
+	if event := receiveTestEvent(t, output); event["message"] != "first" {
+		t.Fatalf("Unexpected event: %v", event)
+	}
+
+	// The harvester stops, such as when the dead time is reached
+	info := p.prospectorindex[path]
+	info.stop()
+	info.wait()
+
+	// A line is written and then the file is rotated as with logrotate's create
+	// option, all before the next scan
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open file: %s", err)
+	}
+	if _, err := file.Write([]byte("second\n")); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+	file.Close()
+
+	rotated := filepath.Join(dir, "test.log.1")
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatalf("Failed to rotate file: %s", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("third\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	// The rotated file is drained and the new file is harvested
+	p.iteration++
+	p.processFile(path, fileConfig)
+
+	received := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		event := receiveTestEvent(t, output)
+		message, _ := event["message"].(string)
+		received[message], _ = event["path"].(string)
+	}
+	if received["second"] != rotated || received["third"] != path {
+		t.Errorf("Unexpected events received: %v", received)
+	}
+
+	if p.prospectorindex[rotated] != info || info.file != rotated {
+		t.Errorf("Rotated file is not tracked at its new path")
+	}
+
+	// The drained harvester finishes once it reaches the end
+	info.wait()
+	if info.status != statusCompleted {
+		t.Errorf("Rotated file harvester did not complete: %d", info.status)
+	}
+}
+
+func TestProspectorRotationNotFound(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, fileConfig, output := createTestProspector(t)
+	fileConfig.Rotation = []string{"test.log.1"}
+	defer stopTestProspector(p)
+
+	path := filepath.Join(dir, "test.log")
+	if err := ioutil.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	p.iteration++
+	p.processFile(path, fileConfig)
+	receiveTestEvent(t, output)
+
+	info := p.prospectorindex[path]
+	info.stop()
+	info.wait()
+
+	// Rotated somewhere the patterns do not match
+	if err := os.Rename(path, filepath.Join(dir, "test.log.old")); err != nil {
+		t.Fatalf("Failed to rotate file: %s", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("second\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	p.iteration++
+	p.processFile(path, fileConfig)
+	if event := receiveTestEvent(t, output); event["message"] != "second" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+	if info.orphaned != orphanedMaybe || info.isRunning() {
+		t.Errorf("Previous file was not orphaned")
+	}
+}
+
+func createTestQueuedProspector(t *testing.T, dir string) (*Prospector, *config.File, chan *core.EventDescriptor, *prospectorInfo, *prospectorInfo) {
+	p, fileConfig, output := createTestProspector(t)
+	p.config.General.MaxActiveHarvesters = 1
+	fileConfig.DeadTime = 10 * time.Second
+
+	first := filepath.Join(dir, "first.log")
+	if err := ioutil.WriteFile(first, []byte("first\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+	second := filepath.Join(dir, "second.log")
+	if err := ioutil.WriteFile(second, []byte("second\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	p.iteration++
+	p.processFile(first, fileConfig)
+	p.processFile(second, fileConfig)
+	if event := receiveTestEvent(t, output); event["message"] != "first" {
+		t.Fatalf("Unexpected event: %v", event)
+	}
+
+	running, queued := p.prospectorindex[first], p.prospectorindex[second]
+	if !running.isRunning() || queued.status != statusPending || len(p.pending) != 1 {
+		t.Fatalf("Second harvester was not queued")
+	}
+
+	return p, fileConfig, output, running, queued
+}
+
+func TestProspectorMaxActiveHarvesters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, _, output, running, queued := createTestQueuedProspector(t, dir)
+	defer stopTestProspector(p)
+
+	// Nothing starts whilst there is no capacity
+	p.startPending()
+	if queued.isRunning() || len(p.pending) != 1 {
+		t.Fatalf("Queued harvester started without capacity")
+	}
+
+	running.stop()
+	running.wait()
+
+	p.startPending()
+	if !queued.isRunning() || len(p.pending) != 0 {
+		t.Fatalf("Queued harvester did not start")
+	}
+	if event := receiveTestEvent(t, output); event["message"] != "second" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
+
+func TestProspectorMaxActiveHarvestersOrphaned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, fileConfig, output, running, queued := createTestQueuedProspector(t, dir)
+	defer stopTestProspector(p)
+
+	// The queued file disappears before there is capacity
+	queued.orphaned = orphanedMaybe
+
+	running.stop()
+	running.wait()
+
+	p.startPending()
+	if queued.isRunning() || len(p.pending) != 0 {
+		t.Fatalf("Orphaned queued harvester was started")
+	}
+
+	// If the file is found again it is started as it is no longer queued
+	queued.orphaned = orphanedNo
+	p.iteration++
+	p.processFile(queued.file, fileConfig)
+	if !queued.isRunning() {
+		t.Fatalf("Orphaned queued harvester was not started when found again")
+	}
+	if event := receiveTestEvent(t, output); event["message"] != "second" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
+
+func TestProspectorMaxActiveHarvestersIdle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, _, output, running, queued := createTestQueuedProspector(t, dir)
+	defer stopTestProspector(p)
+
+	// A harvester that only just read data is not idle
+	p.startPending()
+	if running.stopping {
+		t.Fatalf("Active harvester was stopped")
+	}
+
+	// Once it has waited at the end of the file for a while it makes room. The
+	// end of the file is only reported after a backoff of a second
+	time.Sleep(1500 * time.Millisecond)
+	p.startPending()
+	if !running.stopping {
+		t.Fatalf("Idle harvester was not stopped")
+	}
+	running.wait()
+
+	p.startPending()
+	if !queued.isRunning() {
+		t.Fatalf("Queued harvester did not start")
+	}
+	if event := receiveTestEvent(t, output); event["message"] != "second" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
+
+func countDeletedEvents(p *Prospector) int {
+	count := 0
+	for _, event := range p.registrarSpool.(*testEventSpool).events {
+		if _, ok := event.(*registrar.DeletedEvent); ok {
+			count++
+		}
+	}
+	return count
+}
+
+func TestProspectorRenameRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, fileConfig, output := createTestProspector(t)
+	p.config.General.ProspectInterval = 10 * time.Millisecond
+	fileConfig.Paths = []string{filepath.Join(dir, "*.log")}
+	p.config.Files = append(p.config.Files, *fileConfig)
+	defer stopTestProspector(p)
+
+	path := filepath.Join(dir, "test.log")
+	if err := ioutil.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	p.runOnce()
+	receiveTestEvent(t, output)
+	info := p.prospectorindex[path]
+
+	// Renamed to a path that still matches whilst the harvester is running
+	renamed := filepath.Join(dir, "renamed.log")
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatalf("Failed to rename file: %s", err)
+	}
+
+	p.runOnce()
+	if p.prospectorindex[renamed] != info || info.file != renamed || info.orphaned != orphanedNo {
+		t.Errorf("Renamed file is not tracked at its new path")
+	}
+	if !info.isRunning() || countDeletedEvents(p) != 0 {
+		t.Errorf("Renamed file harvester was stopped or its state deleted")
+	}
+}
+
+func TestProspectorRenameRunningOrphaned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, fileConfig, output := createTestProspector(t)
+	p.config.General.ProspectInterval = 10 * time.Millisecond
+	fileConfig.Paths = []string{filepath.Join(dir, "test.log")}
+	p.config.Files = append(p.config.Files, *fileConfig)
+	defer stopTestProspector(p)
+
+	path := filepath.Join(dir, "test.log")
+	if err := ioutil.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	p.runOnce()
+	receiveTestEvent(t, output)
+	info := p.prospectorindex[path]
+
+	// Renamed somewhere the paths do not match whilst the harvester is running
+	renamed := filepath.Join(dir, "test.log.old")
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatalf("Failed to rename file: %s", err)
+	}
+
+	p.runOnce()
+	if _, ok := p.prospectorindex[path]; ok || info.orphaned != orphanedYes {
+		t.Fatalf("Renamed file was not orphaned")
+	}
+
+	// The harvester continues until it reads to the end and the state remains
+	file, err := os.OpenFile(renamed, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open file: %s", err)
+	}
+	if _, err := file.Write([]byte("second\n")); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+	file.Close()
+
+	if event := receiveTestEvent(t, output); event["message"] != "second" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+
+	p.runOnce()
+	if _, ok := p.prospectors[info]; !ok || countDeletedEvents(p) != 0 {
+		t.Fatalf("Orphaned file state was deleted whilst its harvester is running")
+	}
+
+	// The harvester stops by itself at the end of the file, well before the
+	// dead time, and then the state is deleted
+	select {
+	case status := <-info.harvester.OnFinish():
+		if status.Error != nil || status.LastReadOffset != 13 {
+			t.Fatalf("Orphaned harvester did not finish at the end of the file: %v", status)
+		}
+		info.setHarvesterStopped(status)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for orphaned harvester to finish")
+	}
+
+	p.runOnce()
+	if _, ok := p.prospectors[info]; ok || countDeletedEvents(p) != 1 {
+		t.Errorf("Orphaned file state was not deleted once its harvester stopped")
+	}
+}
+
+func TestProspectorExclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, fileConfig, output := createTestProspector(t)
+	fileConfig.Paths = []string{filepath.Join(dir, "*")}
+	fileConfig.Exclude = []string{"*.gz", "skip-*"}
+	defer stopTestProspector(p)
+
+	for _, name := range []string{"test.log", "test.log.gz", "skip-test.log"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %s", err)
+		}
+	}
+
+	p.iteration++
+	p.scan(fileConfig.Paths[0], fileConfig)
+
+	if event := receiveTestEvent(t, output); event["message"] != "test.log" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+	if len(p.prospectors) != 1 || p.prospectorindex[filepath.Join(dir, "test.log")] == nil {
+		t.Errorf("Excluded files were harvested: %v", p.prospectorindex)
+	}
+
+	select {
+	case desc := <-output:
+		t.Errorf("Unexpected event from an excluded file: %s", desc.Event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestProspectorStartPosition(t *testing.T) {
+	for _, position := range []string{"beginning", "end"} {
+		dir, err := ioutil.TempDir("", "prospector")
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+
+		p, fileConfig, output := createTestProspector(t)
+		p.fromBeginning = false
+		fileConfig.StartPosition = position
+		defer stopTestProspector(p)
+
+		path := filepath.Join(dir, "test.log")
+		if err := ioutil.WriteFile(path, []byte("old\n"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %s", err)
+		}
+
+		p.iteration++
+		p.processFile(path, fileConfig)
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			t.Fatalf("Failed to open file: %s", err)
+		}
+		if _, err := file.Write([]byte("new\n")); err != nil {
+			t.Fatalf("Failed to write file: %s", err)
+		}
+		file.Close()
+
+		expected := "old"
+		if position == "end" {
+			expected = "new"
+		}
+		if event := receiveTestEvent(t, output); event["message"] != expected {
+			t.Errorf("Unexpected first event for start position %s: %v", position, event)
+		}
+	}
+}
+
+func TestProspectorStartPositionSavedState(t *testing.T) {
+	for _, position := range []string{"beginning", "end"} {
+		dir, err := ioutil.TempDir("", "prospector")
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+
+		p, fileConfig, output := createTestProspector(t)
+		p.fromBeginning = false
+		fileConfig.StartPosition = position
+		defer stopTestProspector(p)
+
+		path := filepath.Join(dir, "test.log")
+		if err := ioutil.WriteFile(path, []byte("old\nnew\n"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %s", err)
+		}
+		fileinfo, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat file: %s", err)
+		}
+
+		// The registrar has a saved offset part way through the file
+		state := &registrar.FileState{Source: &path, Offset: 4}
+		state.PopulateFileIds(fileinfo)
+		p.loadCallback(path, state)
+
+		p.iteration++
+		p.processFile(path, fileConfig)
+
+		if event := receiveTestEvent(t, output); event["message"] != "new" {
+			t.Errorf("Saved offset was not used for start position %s: %v", position, event)
+		}
+	}
+}
@@ -20,6 +20,9 @@
 package publisher
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/driskell/log-courier/lc-lib/admin"
 )
 
@@ -36,7 +39,32 @@ func (a *apiStatus) Update() error {
 	a.SetEntry("speed", admin.APIFloat(a.p.lineSpeed))
 	a.SetEntry("publishedLines", admin.APINumber(a.p.lastLineCount))
 	a.SetEntry("pendingPayloads", admin.APINumber(a.p.numPayloads))
+	a.SetEntry("maxPendingPayloads", admin.APINumber(a.p.config.MaxPendingPayloads))
+	a.SetEntry("heldEvents", admin.APINumber(a.p.heldEvents))
+	a.SetEntry("acknowledgedPayloads", admin.APINumber(a.p.ackedPayloads))
+	if a.p.lastAck.IsZero() {
+		a.SetEntry("lastAcknowledgement", admin.APINull)
+	} else {
+		a.SetEntry("lastAcknowledgement", admin.APIString(a.p.lastAck.Format(time.RFC3339)))
+	}
 	a.p.mutex.RUnlock()
 
 	return nil
 }
+
+// metrics adds the publisher metrics to a metrics request
+func (p *Publisher) metrics(m *admin.Metrics) {
+	network := strconv.Itoa(p.index)
+
+	p.mutex.RLock()
+	m.Counter("log_courier_published_events_total", "Number of events acknowledged by the remote endpoint", float64(p.lineCount), "network", network)
+	m.Counter("log_courier_sent_bytes_total", "Number of encoded event bytes sent to transports, before compression", float64(p.bytesSent), "network", network)
+	m.Counter("log_courier_transport_reconnects_total", "Number of times a transport failed and had to reconnect", float64(p.reconnects), "network", network)
+	m.Gauge("log_courier_pending_payloads", "Number of payloads sent and awaiting acknowledgement", float64(p.numPayloads), "network", network)
+	m.Gauge("log_courier_max_pending_payloads", "Maximum number of payloads that can be awaiting acknowledgement", float64(p.config.MaxPendingPayloads), "network", network)
+	m.Gauge("log_courier_held_events", "Number of events held back from the spooler, which is non-zero while backpressure is being applied", float64(p.heldEvents), "network", network)
+	if !p.lastAck.IsZero() {
+		m.Gauge("log_courier_last_ack_age_seconds", "Seconds since the last acknowledgement was received", time.Since(p.lastAck).Seconds(), "network", network)
+	}
+	p.mutex.RUnlock()
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * This file is a modification of code from Logstash Forwarder.
+ * Copyright 2012-2013 Jordan Sissel and contributors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+)
+
+// Fanout distributes spooled events to a publisher for each configured network
+// so that every network receives every event. Acknowledgements from each
+// publisher are combined, and events are only acknowledged to the registrar
+// once every publisher has acknowledged them
+// Each publisher has its own endpoints, and therefore its own retry and
+// backoff, so a slow network only prevents progress in the registrar and does
+// not prevent other networks receiving events until its pending payload limit
+// is reached
+type Fanout struct {
+	core.PipelineSegment
+
+	mutex sync.Mutex
+
+	shutdownTimeout time.Duration
+	onShutdown      <-chan interface{}
+	drainTimer      *time.Timer
+
+	input          chan []*core.EventDescriptor
+	outputs        []chan<- []*core.EventDescriptor
+	registrarSpool registrar.EventSpooler
+	pending        []*core.EventDescriptor
+	acked          []int
+	references     int
+}
+
+// NewFanout creates a new Fanout on the given pipeline, along with a publisher
+// for each configured network
+func NewFanout(pipeline *core.Pipeline, config *config.Config, registrar registrar.Connector) *Fanout {
+	ret := &Fanout{
+		shutdownTimeout: config.General.ShutdownTimeout,
+		input:           make(chan []*core.EventDescriptor, 1),
+		outputs:         make([]chan<- []*core.EventDescriptor, len(config.Networks)),
+		acked:           make([]int, len(config.Networks)),
+	}
+
+	if registrar == nil {
+		ret.registrarSpool = newNullEventSpool()
+	} else {
+		ret.registrarSpool = registrar.Connect()
+	}
+
+	for index := range config.Networks {
+		ret.outputs[index] = NewPublisher(pipeline, config, index, &fanoutConnector{f: ret, index: index}).Connect()
+	}
+
+	pipeline.Register(ret)
+
+	return ret
+}
+
+// Connect is used by Spooler
+func (f *Fanout) Connect() chan<- []*core.EventDescriptor {
+	return f.input
+}
+
+// Run starts the fanout, passing all spooled events to every publisher
+// During shutdown it continues to pass events until the Spooler closes its
+// output, at which point the publishers are told no more events will follow
+func (f *Fanout) Run() {
+	defer func() {
+		f.Done()
+	}()
+
+	f.drainTimer = time.NewTimer(0)
+	f.drainTimer.Stop()
+	f.onShutdown = f.OnShutdown()
+
+FanoutLoop:
+	for {
+		select {
+		case spool, ok := <-f.input:
+			if !ok {
+				for _, output := range f.outputs {
+					close(output)
+				}
+				break FanoutLoop
+			}
+
+			// Record the events before publishing so acknowledgements can always
+			// be matched against them
+			f.mutex.Lock()
+			f.pending = append(f.pending, spool...)
+			f.mutex.Unlock()
+
+			for _, output := range f.outputs {
+				if !f.send(output, spool) {
+					break FanoutLoop
+				}
+			}
+		case <-f.onShutdown:
+			if !f.startDrain() {
+				break FanoutLoop
+			}
+		case <-f.drainTimer.C:
+			break FanoutLoop
+		}
+	}
+
+	log.Info("Fanout exiting")
+}
+
+// send passes the spool to a single publisher, returning false if shutdown
+// completes before it could be passed
+func (f *Fanout) send(output chan<- []*core.EventDescriptor, spool []*core.EventDescriptor) bool {
+	for {
+		select {
+		case output <- spool:
+			return true
+		case <-f.onShutdown:
+			if !f.startDrain() {
+				return false
+			}
+		case <-f.drainTimer.C:
+			return false
+		}
+	}
+}
+
+// startDrain begins waiting for the Spooler to close, bounded by the shutdown
+// timeout, returning false if draining is disabled
+func (f *Fanout) startDrain() bool {
+	f.onShutdown = nil
+	if f.shutdownTimeout <= 0 {
+		return false
+	}
+
+	f.drainTimer.Reset(f.shutdownTimeout)
+	return true
+}
+
+// ack records that the publisher at the given index has acknowledged the given
+// events, and passes to the registrar any events that all publishers have now
+// acknowledged
+// Each publisher acknowledges events in the order they were spooled, so only
+// a count of acknowledged events is needed for each
+func (f *Fanout) ack(index int, events []*core.EventDescriptor) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.acked[index] += len(events)
+
+	complete := f.acked[0]
+	for _, acked := range f.acked[1:] {
+		if acked < complete {
+			complete = acked
+		}
+	}
+
+	if complete == 0 {
+		return
+	}
+
+	for n := range f.acked {
+		f.acked[n] -= complete
+	}
+
+	f.registrarSpool.Add(registrar.NewAckEvent(f.pending[:complete]))
+	f.registrarSpool.Send()
+
+	f.pending = f.pending[complete:]
+}
+
+// dereference closes the registrar spool once all publishers have closed
+// their spools
+func (f *Fanout) dereference() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.references--
+	if f.references == 0 {
+		f.registrarSpool.Close()
+	}
+}
+
+// fanoutConnector is given to each publisher in place of the registrar
+type fanoutConnector struct {
+	f     *Fanout
+	index int
+}
+
+// Connect returns an event spool that passes acknowledgements to the Fanout
+func (c *fanoutConnector) Connect() registrar.EventSpooler {
+	c.f.mutex.Lock()
+	c.f.references++
+	c.f.mutex.Unlock()
+
+	return &fanoutEventSpool{f: c.f, index: c.index}
+}
+
+// fanoutEventSpool collects acknowledgements from a single publisher
+type fanoutEventSpool struct {
+	f      *Fanout
+	index  int
+	events []*core.EventDescriptor
+}
+
+// Close releases the spool
+func (s *fanoutEventSpool) Close() {
+	s.f.dereference()
+	s.f = nil
+}
+
+// Add collects the acknowledged events, the publisher only ever sends
+// acknowledgement events
+func (s *fanoutEventSpool) Add(event registrar.EventProcessor) {
+	if ackEvent, ok := event.(*registrar.AckEvent); ok {
+		s.events = append(s.events, ackEvent.Events()...)
+	}
+}
+
+// Send passes the collected acknowledgements to the Fanout
+func (s *fanoutEventSpool) Send() {
+	if len(s.events) != 0 {
+		s.f.ack(s.index, s.events)
+		s.events = nil
+	}
+}
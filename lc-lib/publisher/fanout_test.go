@@ -0,0 +1,143 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package publisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+)
+
+type testEventSpool struct {
+	acked []*core.EventDescriptor
+	sends int
+}
+
+func (s *testEventSpool) Close() {
+}
+
+func (s *testEventSpool) Add(event registrar.EventProcessor) {
+	s.acked = append(s.acked, event.(*registrar.AckEvent).Events()...)
+}
+
+func (s *testEventSpool) Send() {
+	s.sends++
+}
+
+func createTestFanout(outputs int, events int) (*Fanout, *testEventSpool) {
+	spool := &testEventSpool{}
+	fanout := &Fanout{
+		registrarSpool: spool,
+		acked:          make([]int, outputs),
+	}
+
+	for i := 0; i < events; i++ {
+		fanout.pending = append(fanout.pending, &core.EventDescriptor{Offset: int64(i)})
+	}
+
+	return fanout, spool
+}
+
+func TestFanoutAckRequiresAll(t *testing.T) {
+	fanout, spool := createTestFanout(2, 4)
+	events := fanout.pending
+
+	fanout.ack(0, events[:3])
+	if len(spool.acked) != 0 {
+		t.Fatalf("Events acknowledged before all outputs acknowledged: %d", len(spool.acked))
+	}
+
+	fanout.ack(1, events[:1])
+	if len(spool.acked) != 1 || spool.acked[0] != events[0] {
+		t.Fatalf("Expected only the first event to be acknowledged, got %d", len(spool.acked))
+	}
+
+	fanout.ack(1, events[1:4])
+	if len(spool.acked) != 3 || spool.acked[2] != events[2] {
+		t.Fatalf("Expected three events to be acknowledged, got %d", len(spool.acked))
+	}
+
+	fanout.ack(0, events[3:4])
+	if len(spool.acked) != 4 || spool.acked[3] != events[3] {
+		t.Fatalf("Expected all events to be acknowledged, got %d", len(spool.acked))
+	}
+
+	if spool.sends != 3 {
+		t.Errorf("Unexpected number of registrar sends: %d", spool.sends)
+	}
+
+	if len(fanout.pending) != 0 {
+		t.Errorf("Pending events remain after all acknowledged: %d", len(fanout.pending))
+	}
+}
+
+func TestFanoutSpool(t *testing.T) {
+	fanout, spool := createTestFanout(2, 2)
+	events := fanout.pending
+
+	spools := []*fanoutEventSpool{
+		&fanoutEventSpool{f: fanout, index: 0},
+		&fanoutEventSpool{f: fanout, index: 1},
+	}
+
+	spools[0].Add(registrar.NewAckEvent(events[:1]))
+	spools[0].Add(registrar.NewAckEvent(events[1:]))
+	spools[0].Send()
+	spools[1].Add(registrar.NewAckEvent(events))
+
+	if len(spool.acked) != 0 {
+		t.Fatalf("Events acknowledged before spool was sent: %d", len(spool.acked))
+	}
+
+	spools[1].Send()
+
+	if len(spool.acked) != 2 {
+		t.Fatalf("Expected both events to be acknowledged, got %d", len(spool.acked))
+	}
+}
+
+func TestFanoutShutdownDrain(t *testing.T) {
+	fanout, _ := createTestFanout(2, 0)
+	fanout.shutdownTimeout = 5 * time.Second
+	fanout.input = make(chan []*core.EventDescriptor, 1)
+	outputs := []chan []*core.EventDescriptor{
+		make(chan []*core.EventDescriptor, 1),
+		make(chan []*core.EventDescriptor, 1),
+	}
+	fanout.outputs = []chan<- []*core.EventDescriptor{outputs[0], outputs[1]}
+
+	pipeline := core.NewPipeline()
+	pipeline.Register(fanout)
+	pipeline.Start()
+	pipeline.Shutdown()
+
+	// Events flushed by the spooler during shutdown must still be passed on
+	fanout.input <- []*core.EventDescriptor{&core.EventDescriptor{}}
+	close(fanout.input)
+	pipeline.Wait()
+
+	for index, output := range outputs {
+		if spool, ok := <-output; !ok || len(spool) != 1 {
+			t.Fatalf("Output %d did not receive the flushed events", index)
+		}
+		if _, ok := <-output; ok {
+			t.Fatalf("Output %d was not closed", index)
+		}
+	}
+}
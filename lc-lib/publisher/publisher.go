@@ -39,11 +39,6 @@ var (
 	errNetworkPing    = errors.New("Server did not respond to keepalive")
 )
 
-const (
-	// TODO(driskell): Make the idle timeout configurable like the network timeout is?
-	keepaliveTimeout time.Duration = 900 * time.Second
-)
-
 // Publisher handles payloads and is responsible for passing ordered
 // acknowledgements to the Registrar
 // It makes all the load balancing and distribution decisions, leaving
@@ -62,10 +57,12 @@ type Publisher struct {
 
 	mutex sync.RWMutex
 
-	config       *config.Network
-	adminConfig  *admin.Config
-	endpointSink *endpoint.Sink
-	method       method
+	index           int
+	config          *config.Network
+	shutdownTimeout time.Duration
+	adminConfig     *admin.Config
+	endpointSink    *endpoint.Sink
+	method          method
 
 	payloadList    internallist.List
 	numPayloads    int64
@@ -73,30 +70,46 @@ type Publisher struct {
 	spoolChan      chan []*core.EventDescriptor
 	registrarSpool registrar.EventSpooler
 	shuttingDown   bool
+	draining       bool
+	spoolClosed    bool
 
 	lineCount       int64
 	lineSpeed       float64
 	lastLineCount   int64
 	lastMeasurement time.Time
 	secondsNoAck    int
+	ackedPayloads   int64
+	lastAck         time.Time
+	bytesSent       int64
+	reconnects      int64
+	heldEvents      int
 
 	measurementTimer *time.Timer
 	onShutdown       <-chan interface{}
+	drainTimer       *time.Timer
 	ifSpoolChan      <-chan []*core.EventDescriptor
 	nextSpool        []*core.EventDescriptor
 	resendList       internallist.List
 }
 
-// NewPublisher creates a new publisher instance on the given pipeline
-func NewPublisher(pipeline *core.Pipeline, config *config.Config, registrar registrar.Registrator) *Publisher {
+// NewPublisher creates a new publisher instance on the given pipeline that
+// publishes to the network configuration at the given index
+func NewPublisher(pipeline *core.Pipeline, config *config.Config, index int, registrar registrar.Connector) *Publisher {
 	ret := &Publisher{
-		config:       &config.Network,
-		adminConfig:  config.Get("admin").(*admin.Config),
-		spoolChan:    make(chan []*core.EventDescriptor, 1),
-		endpointSink: endpoint.NewSink(&config.Network),
+		index:           index,
+		config:          config.Networks[index],
+		shutdownTimeout: config.General.ShutdownTimeout,
+		adminConfig:     config.Get("admin").(*admin.Config),
+		spoolChan:       make(chan []*core.EventDescriptor, 1),
+		endpointSink:    endpoint.NewSink(config.Networks[index]),
 	}
 
-	ret.initAPI()
+	// Number the API entries if there are multiple networks to publish to
+	if len(config.Networks) > 1 {
+		ret.initAPI(fmt.Sprintf("publisher%d", index))
+	} else {
+		ret.initAPI("publisher")
+	}
 	ret.initMethod()
 
 	if registrar == nil {
@@ -129,8 +142,13 @@ func (p *Publisher) initMethod() {
 	panic(fmt.Sprintf("Internal error: Unknown publishing method: %s", p.config.Method))
 }
 
+// Connector is implemented by the stages the Spooler can send spooled events
+// to, such as a Publisher or a Fanout
+type Connector interface {
+	Connect() chan<- []*core.EventDescriptor
+}
+
 // Connect is used by Spooler
-// TODO: Spooler doesn't need to know of publisher, only of events
 func (p *Publisher) Connect() chan<- []*core.EventDescriptor {
 	return p.spoolChan
 }
@@ -139,6 +157,8 @@ func (p *Publisher) Connect() chan<- []*core.EventDescriptor {
 // EndpointSink so it can make payload distribution decisions
 func (p *Publisher) Run() {
 	p.measurementTimer = time.NewTimer(time.Second)
+	p.drainTimer = time.NewTimer(0)
+	p.drainTimer.Stop()
 	p.onShutdown = p.OnShutdown()
 	p.ifSpoolChan = p.spoolChan
 
@@ -169,7 +189,26 @@ func (p *Publisher) runOnce() bool {
 			// TODO: What about out of sync ACK?
 			return true
 		}
-	case spool := <-p.ifSpoolChan:
+
+		// If draining and everything is now acknowledged, we can stop
+		if p.draining && p.drained() {
+			log.Info("All pending events delivered, completing shutdown")
+			return p.shutdown()
+		}
+	case spool, ok := <-p.ifSpoolChan:
+		if !ok {
+			// Spooler has shut down and will send nothing further
+			p.ifSpoolChan = nil
+			p.spoolChan = nil
+			p.spoolClosed = true
+
+			if p.draining && p.drained() {
+				log.Info("All pending events delivered, completing shutdown")
+				return p.shutdown()
+			}
+			break
+		}
+
 		if p.numPayloads >= p.config.MaxPendingPayloads {
 			log.Debug("Maximum pending payloads of %d reached, holding %d new events", p.config.MaxPendingPayloads, len(spool))
 		} else if p.resendList.Len() != 0 {
@@ -183,8 +222,7 @@ func (p *Publisher) runOnce() bool {
 		}
 
 		// No ready endpoint, wait for one
-		p.nextSpool = spool
-		p.ifSpoolChan = nil
+		p.holdSpool(spool)
 	case <-p.endpointSink.TimeoutChan():
 		// Process triggered timeouts
 		p.endpointSink.ProcessTimeouts()
@@ -195,17 +233,55 @@ func (p *Publisher) runOnce() bool {
 		p.reloadConfig(config)
 	case <-p.onShutdown:
 		p.onShutdown = nil
-		p.ifSpoolChan = nil
-		p.nextSpool = nil
-		p.shuttingDown = true
 
-		p.endpointSink.Shutdown()
-
-		// If no endpoints, nothing to wait for
-		if p.endpointSink.Count() == 0 {
-			// TODO: What about out of sync ACK?
-			return true
+		// Keep delivering until the Spooler has flushed and all pending events
+		// are acknowledged, or until the shutdown timeout expires
+		if p.shutdownTimeout > 0 && !p.drained() {
+			log.Info("Waiting up to %v for pending events to be delivered", p.shutdownTimeout)
+			p.draining = true
+			p.drainTimer.Reset(p.shutdownTimeout)
+			break
 		}
+
+		return p.shutdown()
+	case <-p.drainTimer.C:
+		log.Warning("Shutdown timeout expired with %d events undelivered", p.undelivered())
+		return p.shutdown()
+	}
+
+	return false
+}
+
+// drained returns true if the Spooler will send no more events and all events
+// received have been acknowledged
+func (p *Publisher) drained() bool {
+	return p.spoolClosed && p.nextSpool == nil && p.payloadList.Len() == 0
+}
+
+// undelivered returns the number of events received that have not yet been
+// acknowledged
+func (p *Publisher) undelivered() int {
+	count := len(p.nextSpool)
+	for element := p.payloadList.Front(); element != nil; element = element.Next() {
+		count += len(element.Value.(*payload.Payload).Events())
+	}
+	return count
+}
+
+// shutdown stops the endpoints and returns true if there are none remaining
+// to wait for
+func (p *Publisher) shutdown() bool {
+	p.drainTimer.Stop()
+	p.draining = false
+	p.holdSpool(nil)
+	p.shuttingDown = true
+
+	p.endpointSink.Shutdown()
+
+	// If no endpoints, nothing to wait for
+	if p.endpointSink.Count() == 0 {
+		// TODO: What about out of sync ACK?
+		return true
 	}
 
 	return false
@@ -213,10 +289,13 @@ func (p *Publisher) runOnce() bool {
 
 func (p *Publisher) reloadConfig(config *config.Config) {
 	oldMethod := p.config.Method
-	p.config = &config.Network
+	p.mutex.Lock()
+	p.config = config.Networks[p.index]
+	p.mutex.Unlock()
+	p.shutdownTimeout = config.General.ShutdownTimeout
 
 	// Give sink the new config
-	p.endpointSink.ReloadConfig(&config.Network)
+	p.endpointSink.ReloadConfig(p.config)
 
 	// Has method changed? Init the new method and discard the old one...
 	if p.config.Method != oldMethod {
@@ -244,13 +323,7 @@ func (p *Publisher) OnStarted(endpoint *endpoint.Endpoint) {
 	}
 
 	log.Debug("[%s] Starting keepalive timeout", endpoint.Server())
-	p.endpointSink.RegisterTimeout(
-		&endpoint.Timeout,
-		keepaliveTimeout,
-		func() {
-			p.timeoutKeepalive(endpoint)
-		},
-	)
+	p.registerKeepalive(endpoint)
 }
 
 // OnFinish handles when endpoints are finished
@@ -276,6 +349,10 @@ func (p *Publisher) OnFail(endpoint *endpoint.Endpoint) {
 		p.pullBackPending(endpoint)
 	}
 
+	p.mutex.Lock()
+	p.reconnects++
+	p.mutex.Unlock()
+
 	// Allow method to handle what we do due to the failed endpoint
 	p.method.onFail(endpoint)
 }
@@ -312,13 +389,7 @@ func (p *Publisher) OnAck(endpoint *endpoint.Endpoint, pendingPayload *payload.P
 			},
 		)
 	} else {
-		p.endpointSink.RegisterTimeout(
-			&endpoint.Timeout,
-			keepaliveTimeout,
-			func() {
-				p.timeoutKeepalive(endpoint)
-			},
-		)
+		p.registerKeepalive(endpoint)
 	}
 
 	complete := pendingPayload.Complete()
@@ -375,6 +446,8 @@ func (p *Publisher) OnAck(endpoint *endpoint.Endpoint, pendingPayload *payload.P
 	p.mutex.Lock()
 	if numComplete != 0 {
 		p.numPayloads -= numComplete
+		p.ackedPayloads += numComplete
+		p.lastAck = time.Now()
 	}
 	p.lineCount += int64(lineCount)
 	p.mutex.Unlock()
@@ -390,13 +463,7 @@ func (p *Publisher) OnPong(endpoint *endpoint.Endpoint) {
 	// If we haven't started sending anything, return to keepalive timeout
 	if endpoint.NumPending() == 0 {
 		log.Debug("[%s] Resetting keepalive timeout", endpoint.Server())
-		p.endpointSink.RegisterTimeout(
-			&endpoint.Timeout,
-			keepaliveTimeout,
-			func() {
-				p.timeoutKeepalive(endpoint)
-			},
-		)
+		p.registerKeepalive(endpoint)
 	}
 }
 
@@ -441,7 +508,7 @@ func (p *Publisher) tryQueueHeld() bool {
 	if p.numPayloads < p.config.MaxPendingPayloads && p.nextSpool != nil {
 		// We have events, send it to the endpoint and wait for more
 		if _, ok := p.sendEvents(p.nextSpool); ok {
-			p.nextSpool = nil
+			p.holdSpool(nil)
 			p.ifSpoolChan = p.spoolChan
 			return true
 		}
@@ -450,6 +517,19 @@ func (p *Publisher) tryQueueHeld() bool {
 	return false
 }
 
+// holdSpool holds events received from the Spooler until they can be sent and
+// stops receiving any more, so that the Spooler blocks and in turn blocks the
+// harvesters until delivery resumes
+// Passing nil clears the held events without resuming receiving
+func (p *Publisher) holdSpool(spool []*core.EventDescriptor) {
+	p.nextSpool = spool
+	p.ifSpoolChan = nil
+
+	p.mutex.Lock()
+	p.heldEvents = len(spool)
+	p.mutex.Unlock()
+}
+
 func (p *Publisher) sendEvents(events []*core.EventDescriptor) (*endpoint.Endpoint, bool) {
 	pendingPayload := payload.NewPayload(events)
 
@@ -470,6 +550,15 @@ func (p *Publisher) sendPayload(pendingPayload *payload.Payload) (*endpoint.Endp
 		return nil, false
 	}
 
+	var bytesSent int64
+	for _, event := range pendingPayload.Events() {
+		bytesSent += int64(len(event.Event))
+	}
+
+	p.mutex.Lock()
+	p.bytesSent += bytesSent
+	p.mutex.Unlock()
+
 	// If this is the first payload, start the network timeout
 	if endpoint.NumPending() == 1 {
 		p.endpointSink.RegisterTimeout(
@@ -493,6 +582,24 @@ func (p *Publisher) timeoutPending(endpoint *endpoint.Endpoint) {
 	}
 }
 
+// registerKeepalive starts the keepalive timeout for an idle endpoint, after
+// which a ping is sent. If keepalive is disabled any existing timeout is
+// cleared and no ping is sent
+func (p *Publisher) registerKeepalive(endpoint *endpoint.Endpoint) {
+	if p.config.Keepalive == 0 {
+		p.endpointSink.ClearTimeout(&endpoint.Timeout)
+		return
+	}
+
+	p.endpointSink.RegisterTimeout(
+		&endpoint.Timeout,
+		p.config.Keepalive,
+		func() {
+			p.timeoutKeepalive(endpoint)
+		},
+	)
+}
+
 func (p *Publisher) timeoutKeepalive(endpoint *endpoint.Endpoint) {
 	// Timeout for PING
 	log.Debug("[%s] Sending PING and starting pending timeout", endpoint.Server())
@@ -517,8 +624,8 @@ func (p *Publisher) takeMeasurements() {
 	p.mutex.Unlock()
 }
 
-// initAPI initialises the publisher API entries
-func (p *Publisher) initAPI() {
+// initAPI initialises the publisher API entries under the given name
+func (p *Publisher) initAPI(name string) {
 	// Is admin loaded into the pipeline?
 	if !p.adminConfig.Enabled {
 		return
@@ -528,5 +635,6 @@ func (p *Publisher) initAPI() {
 	publisherAPI.SetEntry("endpoints", p.endpointSink.APINavigatable())
 	publisherAPI.SetEntry("status", &apiStatus{p: p})
 
-	p.adminConfig.SetEntry("publisher", publisherAPI)
+	p.adminConfig.SetEntry(name, publisherAPI)
+	p.adminConfig.SetMetrics(name, p.metrics)
 }
@@ -0,0 +1,279 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+type testTransportFactory struct {
+	writes chan *testWrite
+	pings  chan transports.Observer
+}
+
+type testWrite struct {
+	observer transports.Observer
+	nonce    string
+}
+
+func (f *testTransportFactory) NewTransport(observer transports.Observer, finishOnFail bool) transports.Transport {
+	go func() {
+		observer.EventChan() <- transports.NewStatusEvent(observer, transports.Started)
+	}()
+
+	return &testTransport{observer: observer, writes: f.writes, pings: f.pings}
+}
+
+type testTransport struct {
+	observer transports.Observer
+	writes   chan *testWrite
+	pings    chan transports.Observer
+}
+
+func (t *testTransport) Fail() {}
+
+func (t *testTransport) Ping() error {
+	t.pings <- t.observer
+	return nil
+}
+
+func (t *testTransport) ReloadConfig(interface{}, bool) bool {
+	return false
+}
+
+func (t *testTransport) Shutdown() {
+	go func() {
+		t.observer.EventChan() <- transports.NewStatusEvent(t.observer, transports.Finished)
+	}()
+}
+
+func (t *testTransport) Write(nonce string, events []*core.EventDescriptor) error {
+	t.writes <- &testWrite{observer: t.observer, nonce: nonce}
+	return nil
+}
+
+func createTestPublisher(t *testing.T, maxPendingPayloads int64) (*core.Pipeline, *Publisher, *testTransportFactory) {
+	return createTestPublisherNetwork(t, func(network *config.Network) {
+		network.MaxPendingPayloads = maxPendingPayloads
+	})
+}
+
+func createTestPublisherNetwork(t *testing.T, configure func(*config.Network)) (*core.Pipeline, *Publisher, *testTransportFactory) {
+	factory := &testTransportFactory{writes: make(chan *testWrite, 10), pings: make(chan transports.Observer, 10)}
+
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	cfg.General.ShutdownTimeout = 0
+
+	network := &config.Network{}
+	network.InitDefaults()
+	network.Servers = []string{"test"}
+	network.AddressPools = []*addresspool.Pool{addresspool.NewPool("test")}
+	network.Factory = factory
+	configure(network)
+	cfg.Networks = []*config.Network{network}
+
+	pipeline := core.NewPipeline()
+	publisher := NewPublisher(pipeline, cfg, 0, nil)
+	pipeline.Start()
+
+	return pipeline, publisher, factory
+}
+
+func sendTestSpool(spoolChan chan<- []*core.EventDescriptor) bool {
+	select {
+	case spoolChan <- []*core.EventDescriptor{&core.EventDescriptor{Event: []byte("{}")}}:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+func receiveTestWrite(t *testing.T, factory *testTransportFactory) *testWrite {
+	select {
+	case write := <-factory.writes:
+		return write
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for payload to be written")
+	}
+	return nil
+}
+
+func heldEvents(p *Publisher) int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.heldEvents
+}
+
+func TestPublisherBackpressure(t *testing.T) {
+	pipeline, publisher, factory := createTestPublisher(t, 2)
+
+	// Pending payloads must be acknowledged before the endpoint will shut down
+	var writes []*testWrite
+	defer func() {
+		pipeline.Shutdown()
+		for _, write := range writes {
+			write.observer.EventChan() <- transports.NewAckEvent(write.observer, write.nonce, 1)
+		}
+		pipeline.Wait()
+	}()
+
+	spoolChan := publisher.Connect()
+
+	// Payloads are sent up to the limit
+	for i := 0; i < 2; i++ {
+		if !sendTestSpool(spoolChan) {
+			t.Fatalf("Publisher blocked before the limit was reached")
+		}
+		writes = append(writes, receiveTestWrite(t, factory))
+	}
+
+	// Once reached, one spool is held and one more is buffered, and then the
+	// spooler is blocked
+	if !sendTestSpool(spoolChan) || !sendTestSpool(spoolChan) {
+		t.Fatalf("Publisher blocked before holding events")
+	}
+	if sendTestSpool(spoolChan) {
+		t.Fatalf("Publisher did not block at the limit")
+	}
+	if held := heldEvents(publisher); held != 1 {
+		t.Errorf("Unexpected held events: %d (expected 1)", held)
+	}
+	select {
+	case <-factory.writes:
+		t.Fatalf("Payload was written beyond the limit")
+	default:
+	}
+
+	// An acknowledgement releases the held spool and resumes receiving
+	writes[0].observer.EventChan() <- transports.NewAckEvent(writes[0].observer, writes[0].nonce, 1)
+	writes = append(writes[1:], receiveTestWrite(t, factory))
+	if !sendTestSpool(spoolChan) {
+		t.Fatalf("Publisher did not resume after acknowledgement")
+	}
+}
+
+func encodeStatus(t *testing.T, status *apiStatus) map[string]interface{} {
+	if err := status.Update(); err != nil {
+		t.Fatalf("Failed to update status: %s", err)
+	}
+	encoded, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("Failed to encode status: %s", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Failed to decode status: %s", err)
+	}
+	return decoded
+}
+
+func TestPublisherAPIStatusAcknowledgement(t *testing.T) {
+	pipeline, publisher, factory := createTestPublisher(t, 10)
+	defer func() {
+		pipeline.Shutdown()
+		pipeline.Wait()
+	}()
+
+	status := &apiStatus{p: publisher}
+	if decoded := encodeStatus(t, status); decoded["acknowledgedPayloads"] != 0.0 || decoded["lastAcknowledgement"] != nil {
+		t.Errorf("Unexpected status before acknowledgement: %v", decoded)
+	}
+
+	if !sendTestSpool(publisher.Connect()) {
+		t.Fatalf("Publisher blocked")
+	}
+	write := receiveTestWrite(t, factory)
+	write.observer.EventChan() <- transports.NewAckEvent(write.observer, write.nonce, 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		decoded := encodeStatus(t, status)
+		if decoded["acknowledgedPayloads"] == 1.0 {
+			lastAck, _ := decoded["lastAcknowledgement"].(string)
+			if _, err := time.Parse(time.RFC3339, lastAck); err != nil {
+				t.Errorf("Unexpected last acknowledgement: %v", decoded["lastAcknowledgement"])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Acknowledgement was not counted: %v", decoded)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The endpoint records the payload and sequence last acknowledged
+	nonce, sequence := publisher.endpointSink.FindEndpoint("test").LastAck()
+	if nonce == nil || *nonce != write.nonce || sequence != 1 {
+		t.Errorf("Unexpected last acknowledgement on endpoint: %v %d", nonce, sequence)
+	}
+}
+
+func TestPublisherKeepalive(t *testing.T) {
+	pipeline, _, factory := createTestPublisherNetwork(t, func(network *config.Network) {
+		network.Keepalive = 50 * time.Millisecond
+	})
+	defer func() {
+		pipeline.Shutdown()
+		pipeline.Wait()
+	}()
+
+	// An idle connection is pinged after the keepalive, and again after the
+	// keepalive following each pong
+	for i := 0; i < 2; i++ {
+		started := time.Now()
+		select {
+		case observer := <-factory.pings:
+			if elapsed := time.Since(started); elapsed < 40*time.Millisecond {
+				t.Errorf("Ping %d was sent before the keepalive: %v", i, elapsed)
+			}
+			observer.EventChan() <- transports.NewPongEvent(observer)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for ping %d", i)
+		}
+	}
+}
+
+func TestPublisherKeepaliveDisabled(t *testing.T) {
+	pipeline, publisher, factory := createTestPublisherNetwork(t, func(network *config.Network) {
+		network.Keepalive = 0
+	})
+	defer func() {
+		pipeline.Shutdown()
+		pipeline.Wait()
+	}()
+
+	// Once a payload is acknowledged the connection is idle, and no ping is sent
+	if !sendTestSpool(publisher.Connect()) {
+		t.Fatalf("Publisher blocked")
+	}
+	write := receiveTestWrite(t, factory)
+	write.observer.EventChan() <- transports.NewAckEvent(write.observer, write.nonce, 1)
+
+	select {
+	case <-factory.pings:
+		t.Fatalf("Ping was sent with keepalive disabled")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -33,6 +33,11 @@ func NewAckEvent(events []*core.EventDescriptor) *AckEvent {
 	}
 }
 
+// Events returns the events that were acknowledged
+func (e *AckEvent) Events() []*core.EventDescriptor {
+	return e.events
+}
+
 // Process persists the ack event into the registrar state by storing the offset
 func (e *AckEvent) Process(state map[core.Stream]*FileState) {
 	if len(e.events) == 1 {
@@ -44,7 +49,8 @@ func (e *AckEvent) Process(state map[core.Stream]*FileState) {
 	for _, event := range e.events {
 		_, isFound := state[event.Stream]
 		if !isFound {
-			// This is probably stdin then or a deleted file we can't resume
+			// This is probably stdin, a heartbeat, or a deleted file we can't
+			// resume
 			continue
 		}
 
@@ -53,5 +59,7 @@ func (e *AckEvent) Process(state map[core.Stream]*FileState) {
 		}
 
 		state[event.Stream].Offset = event.Offset
+		state[event.Stream].checkSkipped()
+		state[event.Stream].checkCompleted()
 	}
 }
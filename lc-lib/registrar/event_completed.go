@@ -0,0 +1,52 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registrar
+
+import (
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// CompletedEvent is a registrar event which marks a read once file as
+// completed, so that it is never harvested again. The file is only marked as
+// completed once the events up to the given offset have been acknowledged
+type CompletedEvent struct {
+	stream core.Stream
+	offset int64
+}
+
+// NewCompletedEvent creates a new registrar completed event
+func NewCompletedEvent(stream core.Stream, offset int64) *CompletedEvent {
+	return &CompletedEvent{
+		stream: stream,
+		offset: offset,
+	}
+}
+
+// Process stores the completion offset into the registrar state, marking the
+// file as completed immediately if acknowledgements have already reached it
+func (e *CompletedEvent) Process(state map[core.Stream]*FileState) {
+	_, isFound := state[e.stream]
+	if !isFound {
+		// This is probably stdin or a deleted file we can't resume
+		return
+	}
+
+	log.Debug("Registrar received a completion event for %s at offset %d", *state[e.stream].Source, e.offset)
+
+	state[e.stream].completeOffset = &e.offset
+	state[e.stream].checkCompleted()
+}
@@ -41,9 +41,14 @@ func (e *DiscoverEvent) Process(state map[core.Stream]*FileState) {
 	log.Debug("Registrar received a new file event for %s", e.source)
 
 	// A new file we need to save offset information for so we can resume
+	claimSource(state, e.stream, e.source)
 	state[e.stream] = &FileState{
 		Source: &e.source,
 		Offset: e.offset,
 	}
-	state[e.stream].PopulateFileIds(e.fileinfo)
+	// Streams that are not files, such as event log channels, have no file
+	// information to identify them
+	if e.fileinfo != nil {
+		state[e.stream].PopulateFileIds(e.fileinfo)
+	}
 }
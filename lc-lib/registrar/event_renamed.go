@@ -41,6 +41,9 @@ func (e *RenamedEvent) Process(state map[core.Stream]*FileState) {
 
 	log.Debug("Registrar received a rename event for %s -> %s", state[e.stream].Source, e.source)
 
-	// Update the stored file name
+	// Update the stored file name, which makes it persistable again if another
+	// file had taken its previous name
+	claimSource(state, e.stream, e.source)
 	state[e.stream].Source = &e.source
+	state[e.stream].superseded = false
 }
@@ -0,0 +1,50 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// ResetEvent is a registrar event which clears the completed state of a read
+// once file, so that it is harvested again from the beginning
+type ResetEvent struct {
+	stream core.Stream
+}
+
+// NewResetEvent creates a new registrar reset event
+func NewResetEvent(stream core.Stream) *ResetEvent {
+	return &ResetEvent{
+		stream: stream,
+	}
+}
+
+// Process clears the completed state and offset in the registrar state
+func (e *ResetEvent) Process(state map[core.Stream]*FileState) {
+	_, isFound := state[e.stream]
+	if !isFound {
+		// This is probably stdin or a deleted file we can't resume
+		return
+	}
+
+	log.Debug("Registrar received a reset event for %s", *state[e.stream].Source)
+
+	state[e.stream].Offset = 0
+	state[e.stream].Completed = false
+	state[e.stream].completeOffset = nil
+	state[e.stream].skipAckOffset = nil
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// SkippedEvent is a registrar event which moves the offset of a file beyond
+// events at the end of a harvest that were never sent, such as those removed by
+// the filter codec, and so will never be acknowledged. The offset only moves
+// once the events that were sent have been acknowledged
+type SkippedEvent struct {
+	stream    core.Stream
+	ackOffset int64
+	offset    int64
+}
+
+// NewSkippedEvent creates a new registrar skipped event
+func NewSkippedEvent(stream core.Stream, ackOffset int64, offset int64) *SkippedEvent {
+	return &SkippedEvent{
+		stream:    stream,
+		ackOffset: ackOffset,
+		offset:    offset,
+	}
+}
+
+// Process stores the skipped offset into the registrar state, moving the offset
+// immediately if acknowledgements have already reached the events sent
+func (e *SkippedEvent) Process(state map[core.Stream]*FileState) {
+	_, isFound := state[e.stream]
+	if !isFound {
+		// This is probably stdin or a deleted file we can't resume
+		return
+	}
+
+	log.Debug("Registrar received a skipped event for %s from offset %d to %d", *state[e.stream].Source, e.ackOffset, e.offset)
+
+	state[e.stream].skipAckOffset = &e.ackOffset
+	state[e.stream].skipOffset = e.offset
+	state[e.stream].checkSkipped()
+	state[e.stream].checkCompleted()
+}
@@ -21,12 +21,29 @@ package registrar
 
 import (
 	"os"
+
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 type FileState struct {
 	FileStateOS
-	Source *string `json:"source,omitempty"`
-	Offset int64   `json:"offset,omitempty"`
+	Source    *string `json:"source,omitempty"`
+	Offset    int64   `json:"offset,omitempty"`
+	Completed bool    `json:"completed,omitempty"`
+
+	// completeOffset is the offset at which a read once file will be marked as
+	// completed, once acknowledgements have reached it
+	completeOffset *int64
+
+	// skipAckOffset is the offset acknowledgements must reach before the offset
+	// moves to skipOffset, beyond events that were never sent
+	skipAckOffset *int64
+	skipOffset    int64
+
+	// superseded is set when another file has since taken this source, such as
+	// after a rotation while this file is still being harvested, so that it is
+	// not persisted unless it is found again under a new name
+	superseded bool
 }
 
 type FileInfo struct {
@@ -51,6 +68,40 @@ func (fs *FileInfo) Update(fileinfo os.FileInfo, identity *FileIdentity) {
 	fs.fileinfo = fileinfo
 }
 
+// checkSkipped moves the offset beyond events that were never sent if one is
+// pending and all events that were sent have been acknowledged
+func (fs *FileState) checkSkipped() {
+	if fs.skipAckOffset == nil || fs.Offset < *fs.skipAckOffset {
+		return
+	}
+
+	if fs.Offset < fs.skipOffset {
+		fs.Offset = fs.skipOffset
+	}
+	fs.skipAckOffset = nil
+}
+
+// checkCompleted marks the state as completed if a completion offset is
+// pending and all events up to that offset have been acknowledged
+func (fs *FileState) checkCompleted() {
+	if fs.completeOffset == nil || fs.Offset < *fs.completeOffset {
+		return
+	}
+
+	fs.Completed = true
+	fs.completeOffset = nil
+}
+
+// claimSource marks any other state with the given source as superseded so
+// that only the given stream is persisted under it
+func claimSource(state map[core.Stream]*FileState, stream core.Stream, source string) {
+	for otherStream, otherState := range state {
+		if otherStream != stream && otherState.Source != nil && *otherState.Source == source {
+			otherState.superseded = true
+		}
+	}
+}
+
 func (fs *FileState) Stat() os.FileInfo {
 	return nil
 }
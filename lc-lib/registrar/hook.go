@@ -0,0 +1,122 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+var (
+	registeredCommitHooks = make(map[string]CommitHookFunc)
+
+	// commitHookRetryInterval is how long to wait before retrying a failed
+	// commit hook when commit hooks are required to succeed
+	commitHookRetryInterval = 1 * time.Second
+)
+
+// CommitOffset is the resume offset of a file that is being committed by the
+// registrar
+type CommitOffset struct {
+	Source string
+	Offset int64
+}
+
+// CommitHookFunc is called by the registrar with the offsets of all files
+// whose state advanced, before they are written to the registrar state file.
+// It is called synchronously from the registrar routine, so must not block for
+// long, and the given offsets must not be modified
+type CommitHookFunc func([]*CommitOffset) error
+
+// RegisterCommitHook registers a hook that will receive the new offsets of
+// files each time the registrar commits its state. It should be called from an
+// init() function
+func RegisterCommitHook(name string, hook CommitHookFunc) {
+	registeredCommitHooks[name] = hook
+}
+
+// changedOffsets returns the offsets of all files whose offset has changed
+// since the last successful commit
+func (r *Registrar) changedOffsets() []*CommitOffset {
+	var offsets []*CommitOffset
+
+	for stream, state := range r.state {
+		if state.superseded {
+			continue
+		}
+		if offset, ok := r.committed[stream]; ok && offset == state.Offset {
+			continue
+		}
+
+		offsets = append(offsets, &CommitOffset{
+			Source: *state.Source,
+			Offset: state.Offset,
+		})
+	}
+
+	return offsets
+}
+
+// markCommitted records the offsets of all files as committed
+func (r *Registrar) markCommitted() {
+	r.committed = make(map[core.Stream]int64, len(r.state))
+	for stream, state := range r.state {
+		r.committed[stream] = state.Offset
+	}
+}
+
+// callCommitHooks calls the registered commit hooks with any changed offsets
+// and returns false if the state should not be written. If commit hooks are
+// required, failing hooks are retried until they succeed or shutdown begins
+func (r *Registrar) callCommitHooks() bool {
+	if len(registeredCommitHooks) == 0 {
+		return true
+	}
+
+	offsets := r.changedOffsets()
+	if len(offsets) == 0 {
+		return true
+	}
+
+	for name, hook := range registeredCommitHooks {
+		for {
+			err := hook(offsets)
+			if err == nil {
+				break
+			}
+
+			if !r.commitHookRequired {
+				log.Warning("Commit hook %s failed: %s", name, err)
+				break
+			}
+
+			log.Error("Commit hook %s failed, retrying in %v: %s", name, commitHookRetryInterval, err)
+
+			select {
+			case <-r.OnShutdown():
+				// Leave the offsets uncommitted so they are retried with the
+				// next commit, or are resent after a restart
+				return false
+			case <-time.After(commitHookRetryInterval):
+			}
+		}
+	}
+
+	r.markCommitted()
+	return true
+}
@@ -22,15 +22,21 @@ package registrar
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
 	"os"
+	"path"
 	"sync"
 )
 
 type LoadPreviousFunc func(string, *FileState) (core.Stream, error)
 
-type Registrator interface {
+type Connector interface {
 	Connect() EventSpooler
+}
+
+type Registrator interface {
+	Connector
 	LoadPrevious(LoadPreviousFunc) (bool, error)
 }
 
@@ -44,14 +50,21 @@ type Registrar struct {
 	persistdir     string
 	statefile      string
 	state          map[core.Stream]*FileState
+
+	commitHookRequired bool
+	committed          map[core.Stream]int64
+
+	// skipBackup prevents a corrupt state file replacing the backup
+	skipBackup bool
 }
 
-func NewRegistrar(pipeline *core.Pipeline, persistdir string) *Registrar {
+func NewRegistrar(pipeline *core.Pipeline, config *config.General) *Registrar {
 	ret := &Registrar{
-		registrar_chan: make(chan []EventProcessor, 16), // TODO: Make configurable?
-		persistdir:     persistdir,
-		statefile:      ".log-courier",
-		state:          make(map[core.Stream]*FileState),
+		registrar_chan:     make(chan []EventProcessor, 16), // TODO: Make configurable?
+		persistdir:         config.PersistDir,
+		statefile:          ".log-courier",
+		state:              make(map[core.Stream]*FileState),
+		commitHookRequired: config.CommitHookRequired,
 	}
 
 	pipeline.Register(ret)
@@ -60,42 +73,55 @@ func NewRegistrar(pipeline *core.Pipeline, persistdir string) *Registrar {
 }
 
 func (r *Registrar) LoadPrevious(callback_func LoadPreviousFunc) (have_previous bool, err error) {
-	data := make(map[string]*FileState)
+	var (
+		data     map[string]*FileState
+		loaded   bool
+		parseErr error
+	)
+
+	// Load the previous state, trying the .new file in case we failed mid-move,
+	// and then the backup in case the state was corrupted by an unclean shutdown
+	filename := path.Join(r.persistdir, r.statefile)
+	for _, candidate := range []string{filename, filename + ".new", filename + ".old"} {
+		// Opening RDWR ensures we can write too and fail early
+		var f *os.File
+		f, err = os.OpenFile(candidate, os.O_RDWR, 0600)
+		if err != nil {
+			// Fail immediately if this is not a path not found error
+			if !os.IsNotExist(err) {
+				return
+			}
+			continue
+		}
 
-	// Load the previous state - opening RDWR ensures we can write too and fail early
-	// c_filename is what we will use to test create capability
-	filename := r.persistdir + string(os.PathSeparator) + ".log-courier"
-	c_filename := r.persistdir + string(os.PathSeparator) + ".log-courier.new"
+		// Parse the data
+		log.Notice("Loading registrar data from %s", candidate)
 
-	var f *os.File
-	f, err = os.OpenFile(filename, os.O_RDWR, 0600)
-	if err != nil {
-		// Fail immediately if this is not a path not found error
-		if !os.IsNotExist(err) {
-			return
+		data = make(map[string]*FileState)
+		err = json.NewDecoder(f).Decode(&data)
+		f.Close()
+		if err == nil {
+			loaded = true
+			break
 		}
 
-		// Try the .new file - maybe we failed mid-move
-		filename, c_filename = c_filename, filename
-		f, err = os.OpenFile(filename, os.O_RDWR, 0600)
+		log.Warning("Failed to parse registrar data from %s: %s", candidate, err)
+		parseErr = err
+		if candidate == filename {
+			r.skipBackup = true
+		}
 	}
 
-	if err != nil {
+	if !loaded {
 		// Did we fail, or did it just not exist?
-		if !os.IsNotExist(err) {
-			return
+		if parseErr != nil {
+			log.Error("No usable registrar data could be loaded, continuing without previous state")
 		}
 		return false, nil
 	}
 
-	// Parse the data
-	log.Notice("Loading registrar data from %s", filename)
 	have_previous = true
 
-	decoder := json.NewDecoder(f)
-	decoder.Decode(&data)
-	f.Close()
-
 	r.state = make(map[core.Stream]*FileState, len(data))
 
 	var stream core.Stream
@@ -106,6 +132,9 @@ func (r *Registrar) LoadPrevious(callback_func LoadPreviousFunc) (have_previous
 		r.state[stream] = state
 	}
 
+	// Loaded offsets were already committed previously
+	r.markCommitted()
+
 	// Test we can successfully save new states by attempting to save now
 	if err = r.writeRegistry(); err != nil {
 		return false, fmt.Errorf("Registry write failed: %s", err)
@@ -114,6 +143,27 @@ func (r *Registrar) LoadPrevious(callback_func LoadPreviousFunc) (have_previous
 	return
 }
 
+// writeRegistryFile writes the current state to the given file, syncing it to
+// disk so that it is complete before it replaces the previous state
+func (r *Registrar) writeRegistryFile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err = json.NewEncoder(file).Encode(r.toCanonical()); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
 func (r *Registrar) Connect() EventSpooler {
 	r.Lock()
 	defer r.Unlock()
@@ -134,6 +184,9 @@ func (r *Registrar) dereferenceSpooler() {
 func (r *Registrar) toCanonical() (canonical map[string]*FileState) {
 	canonical = make(map[string]*FileState, len(r.state))
 	for _, value := range r.state {
+		if value.superseded {
+			continue
+		}
 		if _, ok := canonical[*value.Source]; ok {
 			// We should never allow this - report an error
 			log.Error("BUG: Unexpected registrar conflict detected for %s", *value.Source)
@@ -161,6 +214,10 @@ RegistrarLoop:
 				event.Process(r.state)
 			}
 
+			if !r.callCommitHooks() {
+				continue
+			}
+
 			if err := r.writeRegistry(); err != nil {
 				log.Error("Registry write failed: %s", err)
 			}
@@ -22,23 +22,75 @@
 package registrar
 
 import (
-	"encoding/json"
+	"io"
 	"os"
 	"path"
 )
 
 func (r *Registrar) writeRegistry() error {
-	// Open tmp file, write, flush, rename
+	// Write and sync tmp file, keep the previous as a backup, then rename over
+	// it so there is always a state file in place
 	fname := path.Join(r.persistdir, r.statefile)
 	tname := fname + ".new"
-	file, err := os.Create(tname)
+	if err := r.writeRegistryFile(tname); err != nil {
+		return err
+	}
+
+	if !r.skipBackup {
+		if err := backupRegistry(fname, fname+".old"); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tname, fname); err != nil {
+		return err
+	}
+
+	r.skipBackup = false
+
+	// Sync the directory so the renames survive an unclean shutdown
+	dir, err := os.Open(r.persistdir)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer dir.Close()
+
+	return dir.Sync()
+}
 
-	encoder := json.NewEncoder(file)
-	encoder.Encode(r.toCanonical())
+// backupRegistry hard links the state file to the backup name, leaving the
+// state file itself in place, and falls back to a copy where links are not
+// supported
+func backupRegistry(fname, oname string) error {
+	if err := os.Remove(oname); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err := os.Link(fname, oname)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+
+	src, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(oname)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	if err = dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
 
-	return os.Rename(tname, fname)
+	return dst.Close()
 }
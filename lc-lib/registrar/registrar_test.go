@@ -0,0 +1,347 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+type testStream struct {
+	path string
+	info os.FileInfo
+}
+
+func (s *testStream) Info() (string, os.FileInfo) {
+	return s.path, s.info
+}
+
+func createTestRegistrar(t *testing.T, required bool) (string, *core.Pipeline, *Registrar, *testStream) {
+	dir, err := ioutil.TempDir("", "registrar")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+
+	path := filepath.Join(dir, "test.log")
+	if err = ioutil.WriteFile(path, []byte("test\n"), 0600); err != nil {
+		t.Fatalf("Failed to write test file: %s", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat test file: %s", err)
+	}
+
+	pipeline := core.NewPipeline()
+	registrar := NewRegistrar(pipeline, &config.General{PersistDir: dir, CommitHookRequired: required})
+	pipeline.Start()
+
+	return dir, pipeline, registrar, &testStream{path: path, info: info}
+}
+
+func readTestState(t *testing.T, dir string) map[string]*FileState {
+	return readTestStateFile(t, filepath.Join(dir, ".log-courier"))
+}
+
+func readTestStateFile(t *testing.T, filename string) map[string]*FileState {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read state file: %s", err)
+	}
+
+	state := make(map[string]*FileState)
+	if err = json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("Failed to decode state file: %s", err)
+	}
+
+	return state
+}
+
+func receiveCommit(t *testing.T, commits chan []*CommitOffset, source string, offset int64) {
+	select {
+	case offsets := <-commits:
+		if len(offsets) != 1 || offsets[0].Source != source || offsets[0].Offset != offset {
+			t.Errorf("Unexpected offsets committed: %v", offsets)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for commit hook")
+	}
+}
+
+func TestCommitHook(t *testing.T) {
+	commits := make(chan []*CommitOffset, 10)
+	RegisterCommitHook("test", func(offsets []*CommitOffset) error {
+		commits <- offsets
+		return nil
+	})
+	defer delete(registeredCommitHooks, "test")
+
+	dir, pipeline, registrar, stream := createTestRegistrar(t, false)
+	defer os.RemoveAll(dir)
+
+	spool := registrar.Connect()
+	spool.Add(NewDiscoverEvent(stream, stream.path, 0, stream.info))
+	spool.Send()
+	receiveCommit(t, commits, stream.path, 0)
+
+	spool.Add(NewAckEvent([]*core.EventDescriptor{&core.EventDescriptor{Stream: stream, Offset: 5}}))
+	spool.Send()
+	receiveCommit(t, commits, stream.path, 5)
+
+	// An acknowledgement that does not advance the state is not committed
+	spool.Add(NewAckEvent([]*core.EventDescriptor{&core.EventDescriptor{Stream: stream, Offset: 5}}))
+	spool.Send()
+
+	spool.Close()
+	pipeline.Wait()
+
+	select {
+	case offsets := <-commits:
+		t.Errorf("Unexpected commit: %v", offsets)
+	default:
+	}
+
+	if state := readTestState(t, dir); state[stream.path] == nil || state[stream.path].Offset != 5 {
+		t.Errorf("State file was not written correctly: %v", state)
+	}
+}
+
+func TestCommitHookRequired(t *testing.T) {
+	commits := make(chan []*CommitOffset, 10)
+	fail := true
+	RegisterCommitHook("test", func(offsets []*CommitOffset) error {
+		if fail {
+			fail = false
+			return errors.New("Test failure")
+		}
+		commits <- offsets
+		return nil
+	})
+	defer delete(registeredCommitHooks, "test")
+
+	oldInterval := commitHookRetryInterval
+	commitHookRetryInterval = 100 * time.Millisecond
+	defer func() {
+		commitHookRetryInterval = oldInterval
+	}()
+
+	dir, pipeline, registrar, stream := createTestRegistrar(t, true)
+	defer os.RemoveAll(dir)
+
+	spool := registrar.Connect()
+	spool.Add(NewDiscoverEvent(stream, stream.path, 0, stream.info))
+	spool.Send()
+
+	// The first attempt fails and the retry must receive the same offsets
+	receiveCommit(t, commits, stream.path, 0)
+
+	spool.Close()
+	pipeline.Wait()
+
+	if state := readTestState(t, dir); state[stream.path] == nil {
+		t.Errorf("State file was not written after the retry: %v", state)
+	}
+}
+
+func TestCommitHookRequiredShutdown(t *testing.T) {
+	RegisterCommitHook("test", func(offsets []*CommitOffset) error {
+		return errors.New("Test failure")
+	})
+	defer delete(registeredCommitHooks, "test")
+
+	dir, pipeline, registrar, stream := createTestRegistrar(t, true)
+	defer os.RemoveAll(dir)
+
+	spool := registrar.Connect()
+	spool.Add(NewDiscoverEvent(stream, stream.path, 0, stream.info))
+	spool.Send()
+
+	pipeline.Shutdown()
+	spool.Close()
+	pipeline.Wait()
+
+	// State must not be written if the hook never succeeded
+	if _, err := os.Stat(filepath.Join(dir, ".log-courier")); !os.IsNotExist(err) {
+		t.Errorf("State file was written despite the commit hook failing")
+	}
+}
+
+func TestSupersededSource(t *testing.T) {
+	dir, pipeline, registrar, stream := createTestRegistrar(t, false)
+	defer os.RemoveAll(dir)
+
+	// A rotated file takes the source while the previous file is still harvesting
+	rotated := &testStream{path: stream.path}
+	renamed := stream.path + ".1"
+
+	spool := registrar.Connect()
+	spool.Add(NewDiscoverEvent(stream, stream.path, 5, stream.info))
+	spool.Add(NewDiscoverEvent(rotated, stream.path, 0, nil))
+	spool.Send()
+
+	spool.Add(NewAckEvent([]*core.EventDescriptor{&core.EventDescriptor{Stream: stream, Offset: 10}}))
+	spool.Send()
+
+	spool.Close()
+	pipeline.Wait()
+
+	state := readTestState(t, dir)
+	if len(state) != 1 || state[stream.path] == nil || state[stream.path].Offset != 0 {
+		t.Errorf("Superseded file was persisted: %v", state)
+	}
+
+	// Finding the previous file under a new name must restore it
+	pipeline = core.NewPipeline()
+	registrar = NewRegistrar(pipeline, &config.General{PersistDir: dir})
+	pipeline.Start()
+
+	spool = registrar.Connect()
+	spool.Add(NewDiscoverEvent(stream, stream.path, 10, stream.info))
+	spool.Add(NewDiscoverEvent(rotated, stream.path, 0, nil))
+	spool.Add(NewRenamedEvent(stream, renamed))
+	spool.Send()
+
+	spool.Close()
+	pipeline.Wait()
+
+	state = readTestState(t, dir)
+	if len(state) != 2 || state[stream.path] == nil || state[stream.path].Offset != 0 {
+		t.Errorf("Rotated file was not persisted correctly: %v", state)
+	}
+	if state[renamed] == nil || state[renamed].Offset != 10 {
+		t.Errorf("Renamed file was not persisted correctly: %v", state)
+	}
+}
+
+func TestSkippedCompleted(t *testing.T) {
+	dir, pipeline, registrar, stream := createTestRegistrar(t, false)
+	defer os.RemoveAll(dir)
+
+	// A read once file finished at 20 but the events after 10 were never sent
+	spool := registrar.Connect()
+	spool.Add(NewDiscoverEvent(stream, stream.path, 0, stream.info))
+	spool.Add(NewSkippedEvent(stream, 10, 20))
+	spool.Add(NewCompletedEvent(stream, 20))
+	spool.Send()
+
+	spool.Add(NewAckEvent([]*core.EventDescriptor{&core.EventDescriptor{Stream: stream, Offset: 5}}))
+	spool.Send()
+	spool.Close()
+	pipeline.Wait()
+
+	if state := readTestState(t, dir); state[stream.path] == nil || state[stream.path].Offset != 5 || state[stream.path].Completed {
+		t.Fatalf("State moved beyond the skipped events before they were reached: %v", state[stream.path])
+	}
+
+	dir2, pipeline, registrar, stream := createTestRegistrar(t, false)
+	defer os.RemoveAll(dir2)
+
+	// Once the events sent are acknowledged the offset moves to the end and the
+	// file is completed
+	spool = registrar.Connect()
+	spool.Add(NewDiscoverEvent(stream, stream.path, 0, stream.info))
+	spool.Add(NewSkippedEvent(stream, 10, 20))
+	spool.Add(NewCompletedEvent(stream, 20))
+	spool.Send()
+
+	spool.Add(NewAckEvent([]*core.EventDescriptor{&core.EventDescriptor{Stream: stream, Offset: 10}}))
+	spool.Send()
+	spool.Close()
+	pipeline.Wait()
+
+	if state := readTestState(t, dir2); state[stream.path] == nil || state[stream.path].Offset != 20 || !state[stream.path].Completed {
+		t.Errorf("Skipped events were not moved beyond or file not completed: %v", state[stream.path])
+	}
+}
+
+func TestLoadPreviousRecover(t *testing.T) {
+	dir, pipeline, registrar, stream := createTestRegistrar(t, false)
+	defer os.RemoveAll(dir)
+
+	// Write twice so the first state is kept as the backup of the second
+	spool := registrar.Connect()
+	spool.Add(NewDiscoverEvent(stream, stream.path, 5, stream.info))
+	spool.Send()
+	spool.Add(NewAckEvent([]*core.EventDescriptor{&core.EventDescriptor{Stream: stream, Offset: 10}}))
+	spool.Send()
+	spool.Close()
+	pipeline.Wait()
+
+	// Simulate a crash mid-write that left both the state file and the new
+	// state file truncated
+	filename := filepath.Join(dir, ".log-courier")
+	for _, name := range []string{filename, filename + ".new"} {
+		if err := ioutil.WriteFile(name, []byte(`{"`+stream.path), 0600); err != nil {
+			t.Fatalf("Failed to corrupt state file: %s", err)
+		}
+	}
+
+	registrar = NewRegistrar(core.NewPipeline(), &config.General{PersistDir: dir})
+	loaded := make(map[string]*FileState)
+	havePrevious, err := registrar.LoadPrevious(func(file string, state *FileState) (core.Stream, error) {
+		loaded[file] = state
+		return &testStream{path: file}, nil
+	})
+	if err != nil || !havePrevious {
+		t.Fatalf("Failed to recover previous state: %v", err)
+	}
+	if len(loaded) != 1 || loaded[stream.path] == nil || loaded[stream.path].Offset != 5 {
+		t.Errorf("Recovered state is not the backup: %v", loaded)
+	}
+
+	// The recovered state must have been written back as the state file
+	if state := readTestState(t, dir); state[stream.path] == nil || state[stream.path].Offset != 5 {
+		t.Errorf("Recovered state was not written: %v", state)
+	}
+
+	// The corrupt state file must not have replaced the backup
+	if state := readTestStateFile(t, filename+".old"); state[stream.path] == nil || state[stream.path].Offset != 5 {
+		t.Errorf("Backup was replaced: %v", state)
+	}
+}
+
+func TestLoadPreviousCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registrar")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = ioutil.WriteFile(filepath.Join(dir, ".log-courier"), []byte(`{"`), 0600); err != nil {
+		t.Fatalf("Failed to write state file: %s", err)
+	}
+
+	// A corrupt state file without a backup is reported but is not fatal
+	registrar := NewRegistrar(core.NewPipeline(), &config.General{PersistDir: dir})
+	havePrevious, err := registrar.LoadPrevious(func(file string, state *FileState) (core.Stream, error) {
+		return &testStream{path: file}, nil
+	})
+	if err != nil {
+		t.Errorf("Corrupt state file without a backup failed to load: %s", err)
+	}
+	if havePrevious {
+		t.Errorf("Corrupt state file without a backup loaded previous state")
+	}
+}
@@ -20,8 +20,6 @@
 package registrar
 
 import (
-	"encoding/json"
-	"fmt"
 	"os"
 	"path"
 )
@@ -29,24 +27,31 @@ import (
 func (r *Registrar) writeRegistry() error {
 	fname := path.Join(r.persistdir, r.statefile)
 	tname := fname + ".new"
-	file, err := os.Create(tname)
-	if err != nil {
+	oname := fname + ".old"
+	if err := r.writeRegistryFile(tname); err != nil {
 		return err
 	}
 
-	encoder := json.NewEncoder(file)
-	encoder.Encode(r.toCanonical())
-	file.Close()
+	if !r.skipBackup {
+		// Rename will not replace an existing file, so remove the previous backup
+		// before keeping the current file as the new backup
+		if err := os.Remove(oname); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 
-	var d_err error
-	if _, err = os.Stat(fname); err == nil || !os.IsNotExist(err) {
-		d_err = os.Remove(fname)
+		if err := os.Rename(fname, oname); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if err := os.Remove(fname); err != nil && !os.IsNotExist(err) {
+		// The corrupt state file is discarded rather than kept as the backup
+		return err
 	}
 
-	err = os.Rename(tname, fname)
-	if err != nil {
-		return fmt.Errorf("%s -> %s", d_err, err)
+	if err := os.Rename(tname, fname); err != nil {
+		return err
 	}
 
+	r.skipBackup = false
+
 	return nil
 }
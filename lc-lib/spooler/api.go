@@ -0,0 +1,46 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spooler
+
+import (
+	"github.com/driskell/log-courier/lc-lib/admin"
+)
+
+type apiStatus struct {
+	admin.APIKeyValue
+
+	s *Spooler
+}
+
+// Update updates the spooler status information
+func (a *apiStatus) Update() error {
+	// Update the values and pass through to node
+	a.s.mutex.RLock()
+	a.SetEntry("pendingEvents", admin.APINumber(len(a.s.spool)))
+	a.SetEntry("pendingBytes", admin.APINumber(a.s.spool_size))
+	a.s.mutex.RUnlock()
+
+	return nil
+}
+
+// metrics adds the spooler metrics to a metrics request
+func (s *Spooler) metrics(m *admin.Metrics) {
+	s.mutex.RLock()
+	m.Gauge("log_courier_spool_pending_events", "Number of events held in the spool waiting to be published", float64(len(s.spool)))
+	m.Gauge("log_courier_spool_pending_bytes", "Size in bytes of the events held in the spool", float64(s.spool_size))
+	s.mutex.RUnlock()
+}
@@ -20,38 +20,53 @@
 package spooler
 
 import (
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
 	"github.com/driskell/log-courier/lc-lib/publisher"
-	"time"
 )
 
 const (
 	// Event header is just uint32 at the moment
 	event_header_size = 4
+
+	// heartbeatType is the type field of heartbeat events
+	heartbeatType = "log-courier-heartbeat"
 )
 
 type Spooler struct {
 	core.PipelineSegment
 	core.PipelineConfigReceiver
 
+	mutex sync.RWMutex
+
 	config      *config.General
+	adminConfig *admin.Config
 	spool       []*core.EventDescriptor
 	spool_size  int
 	input       chan *core.EventDescriptor
 	output      chan<- []*core.EventDescriptor
 	timer_start time.Time
 	timer       *time.Timer
+
+	lastEvent      time.Time
+	heartbeatTimer *time.Timer
 }
 
-func NewSpooler(pipeline *core.Pipeline, config *config.General, publisher_imp *publisher.Publisher) *Spooler {
+func NewSpooler(pipeline *core.Pipeline, config *config.Config, publisher_imp publisher.Connector) *Spooler {
 	ret := &Spooler{
-		config: config,
-		spool:  make([]*core.EventDescriptor, 0, config.SpoolSize),
-		input:  make(chan *core.EventDescriptor, 16), // TODO: Make configurable?
-		output: publisher_imp.Connect(),
+		config:      &config.General,
+		adminConfig: config.Get("admin").(*admin.Config),
+		spool:       make([]*core.EventDescriptor, 0, config.General.SpoolSize),
+		input:       make(chan *core.EventDescriptor, 16), // TODO: Make configurable?
+		output:      publisher_imp.Connect(),
 	}
 
+	ret.initAPI()
+
 	pipeline.Register(ret)
 
 	return ret
@@ -73,6 +88,10 @@ func (s *Spooler) Run() {
 	s.timer_start = time.Now()
 	s.timer = time.NewTimer(s.config.SpoolTimeout)
 
+	s.lastEvent = s.timer_start
+	s.heartbeatTimer = time.NewTimer(0)
+	s.setHeartbeatTimer(s.config.HeartbeatInterval)
+
 SpoolerLoop:
 	for {
 		select {
@@ -90,6 +109,8 @@ SpoolerLoop:
 				continue
 			}
 
+			s.lastEvent = time.Now()
+
 			if len(s.spool) > 0 && int64(s.spool_size)+int64(len(event.Event))+event_header_size >= s.config.SpoolMaxBytes {
 				log.Debug("Spooler flushing %d events due to spool max bytes (%d/%d - next is %d)", len(s.spool), s.spool_size, s.config.SpoolMaxBytes, len(event.Event)+4)
 
@@ -99,14 +120,12 @@ SpoolerLoop:
 				}
 
 				s.resetTimer()
-				s.spool_size += len(event.Event) + event_header_size
-				s.spool = append(s.spool, event)
+				s.queueEvent(event)
 
 				continue
 			}
 
-			s.spool_size += len(event.Event) + event_header_size
-			s.spool = append(s.spool, event)
+			s.queueEvent(event)
 
 			// Flush if full
 			if len(s.spool) >= cap(s.spool) {
@@ -129,6 +148,10 @@ SpoolerLoop:
 			}
 
 			s.resetTimer()
+		case <-s.heartbeatTimer.C:
+			if !s.heartbeat() {
+				break SpoolerLoop
+			}
 		case <-s.OnShutdown():
 			break SpoolerLoop
 		case config := <-s.OnConfig():
@@ -138,9 +161,30 @@ SpoolerLoop:
 		}
 	}
 
+	s.drain()
+
 	log.Info("Spooler exiting")
 }
 
+// drain passes anything still spooled to the publisher so it can be delivered
+// before shutdown completes, waiting at most the shutdown timeout, and then
+// closes the output to signal that no more events will follow
+func (s *Spooler) drain() {
+	if len(s.spool) > 0 && s.config.ShutdownTimeout > 0 {
+		log.Debug("Spooler flushing %d events due to shutdown", len(s.spool))
+
+		timer := time.NewTimer(s.config.ShutdownTimeout)
+		select {
+		case s.output <- s.spool:
+		case <-timer.C:
+			log.Warning("Shutdown timeout expired with %d spooled events undelivered", len(s.spool))
+		}
+		timer.Stop()
+	}
+
+	close(s.output)
+}
+
 func (s *Spooler) sendSpool() bool {
 	select {
 	case <-s.OnShutdown():
@@ -152,38 +196,135 @@ func (s *Spooler) sendSpool() bool {
 	case s.output <- s.spool:
 	}
 
+	s.mutex.Lock()
 	s.spool = make([]*core.EventDescriptor, 0, s.config.SpoolSize)
 	s.spool_size = 0
+	s.mutex.Unlock()
 
 	return true
 }
 
+// queueEvent adds an event to the spool
+func (s *Spooler) queueEvent(event *core.EventDescriptor) {
+	s.mutex.Lock()
+	s.spool_size += len(event.Event) + event_header_size
+	s.spool = append(s.spool, event)
+	s.mutex.Unlock()
+}
+
 func (s *Spooler) resetTimer() {
 	s.timer_start = time.Now()
+	s.setTimer(s.config.SpoolTimeout)
+}
 
+// setTimer restarts the flush timer so that it fires after the given duration
+func (s *Spooler) setTimer(duration time.Duration) {
 	// Stop the timer, and ensure the channel is empty before restarting it
 	s.timer.Stop()
 	select {
 	case <-s.timer.C:
 	default:
 	}
-	s.timer.Reset(s.config.SpoolTimeout)
+	s.timer.Reset(duration)
+}
+
+// heartbeat sends a heartbeat event if no events have been received within
+// the heartbeat interval, and restarts the heartbeat timer. Heartbeats use
+// HeartbeatStream so registrars ignore them when they are acknowledged
+func (s *Spooler) heartbeat() bool {
+	if s.config.HeartbeatInterval == 0 {
+		return true
+	}
+
+	if passed := time.Since(s.lastEvent); passed < s.config.HeartbeatInterval {
+		s.setHeartbeatTimer(s.config.HeartbeatInterval - passed)
+		return true
+	}
+
+	event := core.Event{
+		"type": heartbeatType,
+	}
+	if s.config.ECSCompatibility {
+		event["@timestamp"] = time.Now().UTC().Format(time.RFC3339Nano)
+		event.SetNested("host.name", s.config.Host)
+	} else {
+		event["host"] = s.config.Host
+	}
+
+	encoded, err := event.Encode()
+	if err != nil {
+		// This should never happen - log and skip if it does
+		log.Warning("Skipping heartbeat due to encoding failure: %s", err)
+	} else {
+		log.Debug("Spooler sending heartbeat after %v with no events", s.config.HeartbeatInterval)
+
+		// Send immediately along with anything already spooled
+		s.queueEvent(&core.EventDescriptor{Stream: core.HeartbeatStream, Event: encoded})
+		if !s.sendSpool() {
+			return false
+		}
+		s.resetTimer()
+	}
+
+	s.lastEvent = time.Now()
+	s.setHeartbeatTimer(s.config.HeartbeatInterval)
+	return true
+}
+
+// setHeartbeatTimer restarts the heartbeat timer so that it fires after the
+// given duration, or stops it if heartbeats are disabled
+func (s *Spooler) setHeartbeatTimer(duration time.Duration) {
+	s.heartbeatTimer.Stop()
+	select {
+	case <-s.heartbeatTimer.C:
+	default:
+	}
+
+	if s.config.HeartbeatInterval > 0 {
+		s.heartbeatTimer.Reset(duration)
+	}
 }
 
 func (s *Spooler) reloadConfig(config *config.Config) bool {
 	s.config = &config.General
 
-	// Immediate flush?
+	// Heartbeat timer continues from the last event
+	if passed := time.Since(s.lastEvent); passed < s.config.HeartbeatInterval {
+		s.setHeartbeatTimer(s.config.HeartbeatInterval - passed)
+	} else {
+		s.setHeartbeatTimer(0)
+	}
+
+	// Immediate flush? If any of the limits were lowered we may have already
+	// reached them
 	passed := time.Now().Sub(s.timer_start)
-	if passed >= s.config.SpoolTimeout || len(s.spool) >= int(s.config.SpoolSize) {
-		if !s.sendSpool() {
-			return false
+	if passed >= s.config.SpoolTimeout || len(s.spool) >= int(s.config.SpoolSize) || int64(s.spool_size) >= s.config.SpoolMaxBytes {
+		if len(s.spool) > 0 {
+			log.Debug("Spooler flushing %d events due to configuration reload", len(s.spool))
+
+			if !s.sendSpool() {
+				return false
+			}
 		}
-		s.timer_start = time.Now()
-		s.timer.Reset(s.config.SpoolTimeout)
+		s.resetTimer()
 	} else {
-		s.timer.Reset(passed - s.config.SpoolTimeout)
+		// Timer should continue from when the spool was started
+		s.setTimer(s.config.SpoolTimeout - passed)
 	}
 
 	return true
 }
+
+// initAPI initialises the spooler API entries
+func (s *Spooler) initAPI() {
+	// Is admin loaded into the pipeline?
+	if !s.adminConfig.Enabled {
+		return
+	}
+
+	spoolerAPI := &admin.APINode{}
+	spoolerAPI.SetEntry("status", &apiStatus{s: s})
+
+	s.adminConfig.SetEntry("spooler", spoolerAPI)
+	s.adminConfig.SetMetrics("spooler", s.metrics)
+}
@@ -0,0 +1,282 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package spooler
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+type testConnector struct {
+	output chan []*core.EventDescriptor
+}
+
+func (c *testConnector) Connect() chan<- []*core.EventDescriptor {
+	return c.output
+}
+
+func createTestConfig(spoolMaxBytes int64, spoolTimeout time.Duration) *config.Config {
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	cfg.General.SpoolMaxBytes = spoolMaxBytes
+	cfg.General.SpoolTimeout = spoolTimeout
+	return cfg
+}
+
+func createTestSpooler(cfg *config.Config) (*core.Pipeline, *Spooler, chan []*core.EventDescriptor) {
+	pipeline := core.NewPipeline()
+	connector := &testConnector{output: make(chan []*core.EventDescriptor, 10)}
+	spooler := NewSpooler(pipeline, cfg, connector)
+	pipeline.Start()
+	return pipeline, spooler, connector.output
+}
+
+func createTestEvent(size int) *core.EventDescriptor {
+	return &core.EventDescriptor{Event: make([]byte, size)}
+}
+
+func receiveSpool(t *testing.T, output chan []*core.EventDescriptor, expected int) {
+	select {
+	case spool := <-output:
+		if len(spool) != expected {
+			t.Errorf("Spool flushed with wrong number of events: %d (expected %d)", len(spool), expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for spool to flush")
+	}
+}
+
+func checkNoSpool(t *testing.T, output chan []*core.EventDescriptor) {
+	select {
+	case spool := <-output:
+		t.Fatalf("Spool flushed unexpectedly with %d events", len(spool))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSpoolerMaxBytes(t *testing.T) {
+	// Each event is 40 bytes plus the 4 byte header
+	pipeline, spooler, output := createTestSpooler(createTestConfig(100, 10*time.Second))
+
+	input := spooler.Connect()
+	input <- createTestEvent(40)
+	input <- createTestEvent(40)
+
+	checkNoSpool(t, output)
+
+	// Third will not fit so the first two should flush
+	input <- createTestEvent(40)
+
+	receiveSpool(t, output, 2)
+
+	pipeline.Shutdown()
+	pipeline.Wait()
+}
+
+func TestSpoolerReloadFlush(t *testing.T) {
+	pipeline, spooler, output := createTestSpooler(createTestConfig(1000, 10*time.Second))
+
+	input := spooler.Connect()
+	input <- createTestEvent(40)
+	input <- createTestEvent(40)
+
+	checkNoSpool(t, output)
+
+	// Reducing spool max bytes below the current spool size should flush
+	pipeline.SendConfig(createTestConfig(50, 10*time.Second))
+
+	receiveSpool(t, output, 2)
+
+	pipeline.Shutdown()
+	pipeline.Wait()
+}
+
+func TestSpoolerReloadTimeout(t *testing.T) {
+	pipeline, spooler, output := createTestSpooler(createTestConfig(1000, 10*time.Second))
+
+	input := spooler.Connect()
+	input <- createTestEvent(40)
+
+	checkNoSpool(t, output)
+
+	// A shorter timeout should continue from when the spool was started
+	pipeline.SendConfig(createTestConfig(1000, 500*time.Millisecond))
+
+	receiveSpool(t, output, 1)
+
+	pipeline.Shutdown()
+	pipeline.Wait()
+}
+
+func checkHeartbeat(t *testing.T, output chan []*core.EventDescriptor) {
+	select {
+	case spool := <-output:
+		if len(spool) != 1 {
+			t.Fatalf("Heartbeat flushed with wrong number of events: %d", len(spool))
+		}
+		if spool[0].Stream != core.HeartbeatStream {
+			t.Error("Heartbeat event does not have the heartbeat stream")
+		}
+
+		var event map[string]interface{}
+		if err := json.Unmarshal(spool[0].Event, &event); err != nil {
+			t.Fatalf("Failed to decode heartbeat: %s", err)
+		}
+		if event["type"] != heartbeatType {
+			t.Errorf("Unexpected heartbeat type: %v", event["type"])
+		}
+		if event["host"] != "localhost" {
+			t.Errorf("Unexpected heartbeat host: %v", event["host"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for heartbeat")
+	}
+}
+
+func TestSpoolerHeartbeat(t *testing.T) {
+	cfg := createTestConfig(1000, 10*time.Second)
+	cfg.General.Host = "localhost"
+	cfg.General.HeartbeatInterval = 200 * time.Millisecond
+	pipeline, _, output := createTestSpooler(cfg)
+
+	checkHeartbeat(t, output)
+	checkHeartbeat(t, output)
+
+	pipeline.Shutdown()
+	pipeline.Wait()
+}
+
+func TestSpoolerHeartbeatDeferred(t *testing.T) {
+	cfg := createTestConfig(1000, 50*time.Millisecond)
+	cfg.General.Host = "localhost"
+	cfg.General.HeartbeatInterval = 300 * time.Millisecond
+	pipeline, spooler, output := createTestSpooler(cfg)
+
+	// Real events should prevent a heartbeat being sent
+	input := spooler.Connect()
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		input <- createTestEvent(40)
+		receiveSpool(t, output, 1)
+	}
+
+	checkHeartbeat(t, output)
+
+	pipeline.Shutdown()
+	pipeline.Wait()
+}
+
+func TestSpoolerHeartbeatDisabled(t *testing.T) {
+	pipeline, _, output := createTestSpooler(createTestConfig(1000, 50*time.Millisecond))
+
+	checkNoSpool(t, output)
+
+	pipeline.Shutdown()
+	pipeline.Wait()
+}
+
+func checkClosed(t *testing.T, output chan []*core.EventDescriptor) {
+	select {
+	case spool, ok := <-output:
+		if ok {
+			t.Fatalf("Spool flushed unexpectedly with %d events", len(spool))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for output to close")
+	}
+}
+
+func TestSpoolerShutdownFlush(t *testing.T) {
+	pipeline, spooler, output := createTestSpooler(createTestConfig(1000, 10*time.Second))
+
+	input := spooler.Connect()
+	input <- createTestEvent(40)
+	input <- createTestEvent(40)
+
+	checkNoSpool(t, output)
+
+	// Shutdown should flush what remains and then close the output
+	pipeline.Shutdown()
+	pipeline.Wait()
+
+	receiveSpool(t, output, 2)
+	checkClosed(t, output)
+}
+
+func TestSpoolerShutdownNoTimeout(t *testing.T) {
+	cfg := createTestConfig(1000, 10*time.Second)
+	cfg.General.ShutdownTimeout = 0
+	pipeline, spooler, output := createTestSpooler(cfg)
+
+	input := spooler.Connect()
+	input <- createTestEvent(40)
+
+	checkNoSpool(t, output)
+
+	// With no shutdown timeout the spool is discarded
+	pipeline.Shutdown()
+	pipeline.Wait()
+
+	checkClosed(t, output)
+}
+
+func waitStatus(t *testing.T, status *apiStatus, expected string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := status.Update(); err != nil {
+			t.Fatalf("Failed to update status: %s", err)
+		}
+		encoded, err := json.Marshal(status)
+		if err != nil {
+			t.Fatalf("Failed to encode status: %s", err)
+		}
+		if string(encoded) == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected status\nGot:      %s\nExpected: %s", encoded, expected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSpoolerAPIStatus(t *testing.T) {
+	pipeline, spooler, output := createTestSpooler(createTestConfig(1000, 10*time.Second))
+	status := &apiStatus{s: spooler}
+
+	waitStatus(t, status, `{"pendingBytes":0,"pendingEvents":0}`)
+
+	// Each event is 40 bytes plus the 4 byte header
+	input := spooler.Connect()
+	input <- createTestEvent(40)
+	input <- createTestEvent(40)
+
+	waitStatus(t, status, `{"pendingBytes":88,"pendingEvents":2}`)
+
+	// Flushing empties the spool
+	spooler.Flush()
+	receiveSpool(t, output, 2)
+
+	waitStatus(t, status, `{"pendingBytes":0,"pendingEvents":0}`)
+
+	pipeline.Shutdown()
+	pipeline.Wait()
+}
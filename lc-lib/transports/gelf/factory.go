@@ -0,0 +1,127 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+var (
+	// TransportGELFName is the transport name for GELF
+	TransportGELFName = "gelf"
+)
+
+const (
+	defaultNetworkGELFProtocol    string        = "tcp"
+	defaultNetworkGELFChunkSize   int64         = 1420
+	defaultNetworkReconnect       time.Duration = 0 * time.Second
+	defaultNetworkReconnectMax    time.Duration = 300 * time.Second
+	defaultNetworkReconnectJitter float64       = 0.2
+
+	// gelfChunkHeaderSize is the size of the header of each UDP chunk, and the
+	// specification allows chunks of at most 8192 bytes including this
+	gelfChunkHeaderSize = 12
+	gelfMaxChunkSize    = 8192
+	gelfMaxChunks       = 128
+)
+
+// TransportGELFFactory holds the configuration from the configuration file
+// It allows creation of TransportGELF instances that use this configuration
+type TransportGELFFactory struct {
+	ChunkSize       int64         `config:"gelf chunk size"`
+	Protocol        string        `config:"gelf protocol"`
+	Reconnect       time.Duration `config:"reconnect backoff"`
+	ReconnectMax    time.Duration `config:"reconnect backoff max"`
+	ReconnectJitter float64       `config:"reconnect backoff jitter"`
+
+	host         string
+	messageField string
+	netConfig    *config.Network
+}
+
+// NewTransportGELFFactory create a new TransportGELFFactory from the provided
+// configuration data, reporting back any configuration errors it discovers.
+func NewTransportGELFFactory(config *config.Config, netConfig *config.Network, configPath string, unUsed map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	ret := &TransportGELFFactory{
+		host:         config.General.Host,
+		messageField: config.General.MessageField,
+		netConfig:    netConfig,
+	}
+
+	if err = config.PopulateConfig(ret, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if ret.Protocol != "tcp" && ret.Protocol != "udp" {
+		return nil, fmt.Errorf("The GELF protocol (%sgelf protocol) is not recognised: %s", configPath, ret.Protocol)
+	}
+
+	if ret.ChunkSize <= gelfChunkHeaderSize || ret.ChunkSize > gelfMaxChunkSize {
+		return nil, fmt.Errorf("Option %sgelf chunk size must be greater than %d and no more than %d", configPath, gelfChunkHeaderSize, gelfMaxChunkSize)
+	}
+
+	if ret.ReconnectJitter < 0 || ret.ReconnectJitter > 1 {
+		return nil, fmt.Errorf("Option %sreconnect backoff jitter must be between 0 and 1", configPath)
+	}
+
+	if netConfig.Socket != "" {
+		return nil, fmt.Errorf("Option %ssocket is only available when transport is unix", configPath)
+	}
+
+	return ret, nil
+}
+
+// InitDefaults sets the default configuration values
+func (f *TransportGELFFactory) InitDefaults() {
+	f.ChunkSize = defaultNetworkGELFChunkSize
+	f.Protocol = defaultNetworkGELFProtocol
+	f.Reconnect = defaultNetworkReconnect
+	f.ReconnectMax = defaultNetworkReconnectMax
+	f.ReconnectJitter = defaultNetworkReconnectJitter
+}
+
+// NewTransport returns a new Transport interface using the settings from the
+// TransportGELFFactory.
+func (f *TransportGELFFactory) NewTransport(observer transports.Observer, finishOnFail bool) transports.Transport {
+	ret := &TransportGELF{
+		config:         f,
+		finishOnFail:   finishOnFail,
+		observer:       observer,
+		controllerChan: make(chan int),
+		failChan:       make(chan error, 1),
+		sendChan:       make(chan *gelfPayload, f.netConfig.MaxPendingPayloads+1),
+		backoff:        core.NewExpBackoff(observer.Pool().Server()+" Reconnect", f.Reconnect, f.ReconnectMax),
+	}
+
+	ret.backoff.SetJitter(f.ReconnectJitter)
+
+	go ret.controller()
+
+	return ret
+}
+
+// Register the transport
+func init() {
+	config.RegisterTransport(TransportGELFName, NewTransportGELFFactory)
+}
@@ -0,0 +1,444 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+// gelfPayload holds a payload waiting to be sent, or a ping request if nil
+type gelfPayload struct {
+	nonce  string
+	events []*core.EventDescriptor
+}
+
+// TransportGELF implements a transport that sends events as GELF messages
+// over TCP or UDP
+// GELF has no acknowledgements, so events are acknowledged as soon as they
+// are written to the connection
+type TransportGELF struct {
+	config       *TransportGELFFactory
+	finishOnFail bool
+	socket       net.Conn
+	backoff      *core.ExpBackoff
+	desc         string
+
+	controllerChan chan int
+	observer       transports.Observer
+	failChan       chan error
+	sendChan       chan *gelfPayload
+}
+
+// ReloadConfig returns true if the transport needs to be restarted in order
+// for the new configuration to apply
+func (t *TransportGELF) ReloadConfig(factoryInterface interface{}, finishOnFail bool) bool {
+	newConfig := factoryInterface.(*TransportGELFFactory)
+	t.finishOnFail = finishOnFail
+
+	if newConfig.Protocol != t.config.Protocol || newConfig.ChunkSize != t.config.ChunkSize || newConfig.host != t.config.host || newConfig.messageField != t.config.messageField {
+		return true
+	}
+
+	// The backoff is created with the transport, so restart to apply changes
+	if newConfig.Reconnect != t.config.Reconnect || newConfig.ReconnectMax != t.config.ReconnectMax || newConfig.ReconnectJitter != t.config.ReconnectJitter {
+		return true
+	}
+
+	// The network configuration is read by the connection routines whilst they
+	// run, so rather than swap it, restart if anything we use has changed
+	newNet, oldNet := newConfig.netConfig, t.config.netConfig
+	if newNet.ConnectTimeout != oldNet.ConnectTimeout || newNet.WriteTimeout != oldNet.WriteTimeout || newNet.MaxPendingPayloads != oldNet.MaxPendingPayloads {
+		return true
+	}
+
+	return false
+}
+
+// ReconnectBackoff returns the delay currently being applied between
+// reconnection attempts, or 0 if the connection is healthy
+func (t *TransportGELF) ReconnectBackoff() time.Duration {
+	return t.backoff.Current()
+}
+
+// controller is the master routine which handles connection, sending and
+// reconnection
+func (t *TransportGELF) controller() {
+	defer func() {
+		t.sendEvent(nil, transports.NewStatusEvent(t.observer, transports.Finished))
+	}()
+
+	for {
+		err := t.connect()
+		if err == nil {
+			if t.sendEvent(t.controllerChan, transports.NewStatusEvent(t.observer, transports.Started)) {
+				t.disconnect()
+				return
+			}
+
+			var shutdown bool
+			if shutdown, err = t.sender(); shutdown {
+				t.disconnect()
+				return
+			}
+		}
+
+		if t.finishOnFail {
+			log.Errorf("[%s] Transport error: %s", t.observer.Pool().Server(), err)
+			t.disconnect()
+			return
+		}
+
+		log.Errorf("[%s] Transport error, reconnecting: %s", t.observer.Pool().Server(), err)
+
+		t.disconnect()
+
+		if t.sendEvent(t.controllerChan, transports.NewStatusEvent(t.observer, transports.Failed)) {
+			return
+		}
+
+		// If this returns false, we are shutting down
+		if !t.reconnectWait() {
+			return
+		}
+	}
+}
+
+// reconnectWait waits the reconnect timeout before attempting to reconnect.
+// It also monitors for shutdown events while waiting.
+func (t *TransportGELF) reconnectWait() bool {
+	select {
+	case <-t.controllerChan:
+		// Shutdown request
+		return false
+	case <-time.After(t.backoff.Trigger()):
+	}
+
+	return true
+}
+
+// connect connects the socket, which for UDP only selects the destination
+func (t *TransportGELF) connect() error {
+	addr, err := t.observer.Pool().Next()
+	if err != nil {
+		return fmt.Errorf("Failed to select next address: %s", err)
+	}
+
+	desc := t.observer.Pool().Desc()
+
+	log.Info("[%s] Attempting to connect to %s", t.observer.Pool().Server(), desc)
+
+	t.socket, err = net.DialTimeout(t.config.Protocol, addr.String(), t.config.netConfig.ConnectTimeout)
+	if err != nil {
+		t.socket = nil
+		return fmt.Errorf("Failed to connect to %s: %s", desc, err)
+	}
+
+	log.Notice("[%s] Connected to %s", t.observer.Pool().Server(), desc)
+	t.desc = desc
+
+	return nil
+}
+
+// disconnect closes the socket and discards any payloads that were not sent,
+// which the publisher will resend after it receives the failure
+func (t *TransportGELF) disconnect() {
+	if t.socket == nil {
+		return
+	}
+
+	t.socket.Close()
+	t.socket = nil
+
+DrainLoop:
+	for {
+		select {
+		case <-t.sendChan:
+		default:
+			break DrainLoop
+		}
+	}
+
+	log.Notice("[%s] Disconnected from %s", t.observer.Pool().Server(), t.desc)
+}
+
+// sender writes payloads to the socket until shutdown or failure, and returns
+// true if shutdown was signalled
+func (t *TransportGELF) sender() (bool, error) {
+	for {
+		select {
+		case <-t.controllerChan:
+			return true, nil
+		case err := <-t.failChan:
+			// A nil error is a forced failure by the publisher
+			if err == nil {
+				err = transports.ErrForcedFailure
+			}
+			return false, err
+		case payload := <-t.sendChan:
+			if payload == nil {
+				// GELF has no ping, so respond immediately whilst connected
+				if t.sendEvent(t.controllerChan, transports.NewPongEvent(t.observer)) {
+					return true, nil
+				}
+				continue
+			}
+
+			sent, err := t.writePayload(payload)
+
+			// Acknowledge what was written even if the rest failed so that it is not
+			// sent again
+			if sent != 0 {
+				if t.sendEvent(t.controllerChan, transports.NewAckEvent(t.observer, payload.nonce, uint32(sent))) {
+					return true, nil
+				}
+			}
+
+			if err != nil {
+				return false, err
+			}
+
+			t.backoff.Reset()
+		}
+	}
+}
+
+// writePayload writes each event in the payload to the socket as a GELF
+// message, returning how many events were written
+func (t *TransportGELF) writePayload(payload *gelfPayload) (int, error) {
+	for n, event := range payload.events {
+		message, err := t.config.encodeMessage(event.Event)
+		if err != nil {
+			// An event that can not be encoded never will be, so skip it
+			log.Warning("[%s] Skipping event that could not be encoded as GELF: %s", t.observer.Pool().Server(), err)
+			continue
+		}
+
+		if t.config.Protocol == "udp" {
+			err = t.writeChunked(message)
+		} else {
+			// GELF over TCP is delimited by a null byte
+			if t.config.netConfig.WriteTimeout > 0 {
+				t.socket.SetWriteDeadline(time.Now().Add(t.config.netConfig.WriteTimeout))
+			}
+			_, err = t.socket.Write(append(message, 0))
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				// The server is no longer reading what we send
+				err = fmt.Errorf("Write to %s timed out after %s", t.socket.RemoteAddr(), t.config.netConfig.WriteTimeout)
+			}
+		}
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return len(payload.events), nil
+}
+
+// writeChunked writes a message as a single UDP datagram, or as chunks if it
+// is larger than the chunk size
+func (t *TransportGELF) writeChunked(message []byte) error {
+	if len(message) <= int(t.config.ChunkSize) {
+		_, err := t.socket.Write(message)
+		return err
+	}
+
+	chunks, err := chunkMessage(message, int(t.config.ChunkSize))
+	if err != nil {
+		// UDP delivery is best effort so drop the message rather than fail
+		log.Warning("[%s] Dropping GELF message: %s", t.observer.Pool().Server(), err)
+		return nil
+	}
+
+	for _, chunk := range chunks {
+		if _, err = t.socket.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chunkMessage splits a message into GELF chunks of at most the given size,
+// each with the chunk header of magic bytes, message ID, index and count
+func chunkMessage(message []byte, chunkSize int) ([][]byte, error) {
+	dataSize := chunkSize - gelfChunkHeaderSize
+	count := (len(message) + dataSize - 1) / dataSize
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("Message of %d bytes needs %d chunks which is more than the maximum of %d", len(message), count, gelfMaxChunks)
+	}
+
+	messageID := make([]byte, 8)
+	if _, err := rand.Read(messageID); err != nil {
+		return nil, err
+	}
+
+	chunks := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		start, end := i*dataSize, (i+1)*dataSize
+		if end > len(message) {
+			end = len(message)
+		}
+
+		chunk := make([]byte, 0, gelfChunkHeaderSize+end-start)
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, messageID...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks[i] = append(chunk, message[start:end]...)
+	}
+
+	return chunks, nil
+}
+
+// encodeMessage converts an encoded event into a GELF message. The message
+// field becomes short_message, and all other fields become additional fields
+// prefixed with an underscore
+func (f *TransportGELFFactory) encodeMessage(data []byte) ([]byte, error) {
+	var event map[string]interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&event); err != nil {
+		return nil, err
+	}
+
+	message := map[string]interface{}{
+		"version":       "1.1",
+		"host":          f.host,
+		"short_message": "-",
+	}
+
+	switch host := event["host"].(type) {
+	case string:
+		if host != "" {
+			message["host"] = host
+		}
+		delete(event, "host")
+	case map[string]interface{}:
+		// ECS compatibility nests the host name, and the remainder of the object
+		// is kept as an additional field
+		if name, ok := host["name"].(string); ok && name != "" {
+			message["host"] = name
+		}
+		delete(host, "name")
+		if len(host) == 0 {
+			delete(event, "host")
+		}
+	}
+
+	for _, field := range []string{f.messageField, "message"} {
+		if shortMessage, ok := event[field].(string); ok {
+			if shortMessage != "" {
+				message["short_message"] = shortMessage
+			}
+			delete(event, field)
+			break
+		}
+	}
+
+	if timestamp, ok := event["@timestamp"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+			message["timestamp"] = float64(parsed.UnixNano()) / float64(time.Second)
+			delete(event, "@timestamp")
+		}
+	}
+
+	for key, value := range event {
+		name := gelfFieldName(key)
+
+		switch typedValue := value.(type) {
+		case nil:
+			continue
+		case string, json.Number:
+			message[name] = typedValue
+		case bool:
+			message[name] = fmt.Sprintf("%t", typedValue)
+		default:
+			// Additional fields can only be strings or numbers
+			encoded, err := json.Marshal(typedValue)
+			if err != nil {
+				return nil, err
+			}
+			message[name] = string(encoded)
+		}
+	}
+
+	return json.Marshal(message)
+}
+
+// gelfFieldName returns the additional field name to use for an event field,
+// replacing any characters that are not allowed
+func gelfFieldName(key string) string {
+	name := "_" + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, key)
+
+	// The _id field is reserved
+	if name == "_id" {
+		return "__id"
+	}
+
+	return name
+}
+
+// sendEvent ships an event structure to the observer whilst also monitoring for
+// any shutdown signal. Returns true if shutdown was signalled
+func (t *TransportGELF) sendEvent(controlChan <-chan int, event transports.Event) bool {
+	select {
+	case <-controlChan:
+		return true
+	case t.observer.EventChan() <- event:
+	}
+	return false
+}
+
+// Write a message to the transport
+func (t *TransportGELF) Write(nonce string, events []*core.EventDescriptor) error {
+	t.sendChan <- &gelfPayload{nonce: nonce, events: events}
+	return nil
+}
+
+// Ping the remote server
+func (t *TransportGELF) Ping() error {
+	t.sendChan <- nil
+	return nil
+}
+
+// Fail the transport
+func (t *TransportGELF) Fail() {
+	select {
+	case t.failChan <- nil:
+	default:
+	}
+}
+
+// Shutdown the transport
+func (t *TransportGELF) Shutdown() {
+	close(t.controllerChan)
+}
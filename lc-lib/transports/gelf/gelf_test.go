@@ -0,0 +1,324 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+type testObserver struct {
+	pool      *addresspool.Pool
+	eventChan chan transports.Event
+}
+
+func (o *testObserver) Pool() *addresspool.Pool {
+	return o.pool
+}
+
+func (o *testObserver) EventChan() chan<- transports.Event {
+	return o.eventChan
+}
+
+func createTestFactory(t *testing.T, server string, unUsed map[string]interface{}) *TransportGELFFactory {
+	cfg := &config.Config{General: config.General{Host: "courier.example.com", MessageField: "message"}}
+	netConfig := &config.Network{Servers: []string{server}, Timeout: time.Second, MaxPendingPayloads: 10}
+	factory, err := NewTransportGELFFactory(cfg, netConfig, "/network/", unUsed, TransportGELFName)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return factory.(*TransportGELFFactory)
+}
+
+func createTestEvents(t *testing.T, messages ...string) []*core.EventDescriptor {
+	events := make([]*core.EventDescriptor, len(messages))
+	for i, message := range messages {
+		encoded, err := core.Event{"message": message, "offset": i}.Encode()
+		if err != nil {
+			t.Fatalf("Failed to encode event: %s", err)
+		}
+		events[i] = &core.EventDescriptor{Event: encoded}
+	}
+	return events
+}
+
+func decodeTestMessage(t *testing.T, data []byte) map[string]interface{} {
+	var message map[string]interface{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatalf("Failed to decode GELF message: %s", err)
+	}
+	return message
+}
+
+// waitEvent waits for an event matching the given check, discarding others
+func waitEvent(t *testing.T, eventChan <-chan transports.Event, desc string, check func(transports.Event) bool) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-eventChan:
+			if check(event) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timeout waiting for %s", desc)
+		}
+	}
+}
+
+func waitStatus(t *testing.T, eventChan <-chan transports.Event, status transports.StatusChange) {
+	waitEvent(t, eventChan, fmt.Sprintf("status %d", status), func(event transports.Event) bool {
+		statusEvent, ok := event.(*transports.StatusEvent)
+		return ok && statusEvent.StatusChange() == status
+	})
+}
+
+func waitAck(t *testing.T, eventChan <-chan transports.Event, nonce string, sequence uint32) {
+	waitEvent(t, eventChan, "acknowledgement", func(event transports.Event) bool {
+		ackEvent, ok := event.(*transports.AckEvent)
+		return ok && ackEvent.Nonce() == nonce && ackEvent.Sequence() == sequence
+	})
+}
+
+func TestFactoryInvalid(t *testing.T) {
+	for _, unUsed := range []map[string]interface{}{
+		{"gelf protocol": "http"},
+		{"gelf chunk size": 12},
+		{"gelf chunk size": 8193},
+	} {
+		if _, err := NewTransportGELFFactory(&config.Config{}, &config.Network{}, "/network/", unUsed, TransportGELFName); err == nil || !strings.Contains(err.Error(), "/network/gelf") {
+			t.Errorf("Unexpected error for %v: %v", unUsed, err)
+		}
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	factory := createTestFactory(t, "127.0.0.1:12201", nil)
+
+	encoded, err := factory.encodeMessage([]byte(`{"message":"Test line","host":"web1","offset":12345678901234,"tags":["a","b"],"production":true,"id":"x","bad key":"y","@timestamp":"2017-02-18T10:00:00.5Z"}`))
+	if err != nil {
+		t.Fatalf("Failed to encode message: %s", err)
+	}
+
+	message := decodeTestMessage(t, encoded)
+	expected := map[string]interface{}{
+		"version":       "1.1",
+		"host":          "web1",
+		"short_message": "Test line",
+		"timestamp":     1487412000.5,
+		"_offset":       float64(12345678901234),
+		"_tags":         `["a","b"]`,
+		"_production":   "true",
+		"__id":          "x",
+		"_bad_key":      "y",
+	}
+	if len(message) != len(expected) {
+		t.Errorf("Unexpected fields in message: %v", message)
+	}
+	for key, value := range expected {
+		if message[key] != value {
+			t.Errorf("Unexpected value for %s: %v (expected %v)", key, message[key], value)
+		}
+	}
+
+	// The general host is used when the event has none, and short_message is
+	// always present
+	if encoded, err = factory.encodeMessage([]byte(`{"path":"/var/log/test.log"}`)); err != nil {
+		t.Fatalf("Failed to encode message: %s", err)
+	}
+	message = decodeTestMessage(t, encoded)
+	if message["host"] != "courier.example.com" || message["short_message"] != "-" || message["_path"] != "/var/log/test.log" {
+		t.Errorf("Unexpected message: %v", message)
+	}
+
+	// The nested host name is used with ECS compatibility
+	if encoded, err = factory.encodeMessage([]byte(`{"host":{"name":"web2","ip":"10.0.0.1"}}`)); err != nil {
+		t.Fatalf("Failed to encode message: %s", err)
+	}
+	message = decodeTestMessage(t, encoded)
+	if message["host"] != "web2" || message["_host"] != `{"ip":"10.0.0.1"}` {
+		t.Errorf("Unexpected message: %v", message)
+	}
+}
+
+func TestChunkMessage(t *testing.T) {
+	message := bytes.Repeat([]byte("0123456789"), 300)
+
+	chunks, err := chunkMessage(message, 1420)
+	if err != nil {
+		t.Fatalf("Failed to chunk message: %s", err)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("Unexpected chunk count: %d", len(chunks))
+	}
+
+	var reassembled []byte
+	for i, chunk := range chunks {
+		if len(chunk) > 1420 {
+			t.Errorf("Chunk %d is too large: %d", i, len(chunk))
+		}
+		if chunk[0] != 0x1e || chunk[1] != 0x0f || !bytes.Equal(chunk[2:10], chunks[0][2:10]) || chunk[10] != byte(i) || chunk[11] != 3 {
+			t.Errorf("Chunk %d has an invalid header: %v", i, chunk[0:12])
+		}
+		reassembled = append(reassembled, chunk[12:]...)
+	}
+	if !bytes.Equal(reassembled, message) {
+		t.Error("Reassembled message does not match")
+	}
+
+	if _, err = chunkMessage(message, 20); err == nil {
+		t.Error("Message needing more than the maximum chunks was chunked")
+	}
+}
+
+func TestTransportGELFTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	server := listener.Addr().String()
+	factory := createTestFactory(t, server, nil)
+
+	connChan := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			connChan <- nil
+			return
+		}
+		connChan <- conn
+	}()
+
+	eventChan := make(chan transports.Event)
+	observer := &testObserver{pool: addresspool.NewPool(server), eventChan: eventChan}
+	transport := factory.NewTransport(observer, false)
+
+	waitStatus(t, eventChan, transports.Started)
+
+	conn := <-connChan
+	if conn == nil {
+		t.Fatal("Failed to accept connection")
+	}
+	defer conn.Close()
+
+	if err := transport.Write("0123456789abcdef", createTestEvents(t, "first", "second")); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+
+	// Messages are delimited by a null byte
+	reader := bufio.NewReader(conn)
+	for _, expected := range []string{"first", "second"} {
+		data, err := reader.ReadBytes(0)
+		if err != nil {
+			t.Fatalf("Failed to read message: %s", err)
+		}
+		if message := decodeTestMessage(t, data[:len(data)-1]); message["short_message"] != expected {
+			t.Errorf("Unexpected message: %v", message)
+		}
+	}
+
+	waitAck(t, eventChan, "0123456789abcdef", 2)
+
+	// There is no ping in GELF so the response is immediate
+	transport.Ping()
+	waitEvent(t, eventChan, "pong", func(event transports.Event) bool {
+		_, ok := event.(*transports.PongEvent)
+		return ok
+	})
+
+	transport.Shutdown()
+	waitStatus(t, eventChan, transports.Finished)
+}
+
+func TestTransportGELFUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	server := conn.LocalAddr().String()
+	factory := createTestFactory(t, server, map[string]interface{}{"gelf protocol": "udp", "gelf chunk size": 64})
+
+	eventChan := make(chan transports.Event)
+	observer := &testObserver{pool: addresspool.NewPool(server), eventChan: eventChan}
+	transport := factory.NewTransport(observer, false)
+
+	waitStatus(t, eventChan, transports.Started)
+
+	long := strings.Repeat("long ", 40)
+	if err := transport.Write("0123456789abcdef", createTestEvents(t, long)); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+
+	waitAck(t, eventChan, "0123456789abcdef", 1)
+
+	// The message is larger than the chunk size so arrives in chunks
+	var reassembled []byte
+	var count byte = 1
+	buffer := make([]byte, 8192)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := byte(0); i < count; i++ {
+		length, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("Failed to read chunk: %s", err)
+		}
+		if length > 64 || buffer[0] != 0x1e || buffer[1] != 0x0f || buffer[10] != i {
+			t.Fatalf("Unexpected chunk: %v", buffer[0:12])
+		}
+		count = buffer[11]
+		reassembled = append(reassembled, buffer[12:length]...)
+	}
+
+	if message := decodeTestMessage(t, reassembled); message["short_message"] != long {
+		t.Errorf("Unexpected message: %v", message)
+	}
+
+	transport.Shutdown()
+	waitStatus(t, eventChan, transports.Finished)
+}
+
+func TestTransportGELFReloadBackoff(t *testing.T) {
+	factory := createTestFactory(t, "127.0.0.1:12201", map[string]interface{}{})
+	transport := &TransportGELF{config: factory}
+
+	if transport.ReloadConfig(createTestFactory(t, "127.0.0.1:12201", map[string]interface{}{}), false) {
+		t.Error("Transport restarted with an unchanged configuration")
+	}
+
+	for _, option := range []map[string]interface{}{
+		{"reconnect backoff": "5s"},
+		{"reconnect backoff max": "60s"},
+		{"reconnect backoff jitter": 0.5},
+	} {
+		if !transport.ReloadConfig(createTestFactory(t, "127.0.0.1:12201", option), false) {
+			t.Errorf("Transport did not restart when changing %v", option)
+		}
+	}
+}
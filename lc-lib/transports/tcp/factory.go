@@ -20,12 +20,14 @@
 package transports
 
 import (
+	"compress/zlib"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"regexp"
 	"time"
 
@@ -39,11 +41,15 @@ var (
 	TransportTCPTCP = "tcp"
 	// TransportTCPTLS is the transport name for encrypted TLS
 	TransportTCPTLS = "tls"
+	// TransportTCPUnix is the transport name for a local Unix domain socket
+	TransportTCPUnix = "unix"
 )
 
 const (
-	defaultNetworkReconnect    time.Duration = 0 * time.Second
-	defaultNetworkReconnectMax time.Duration = 300 * time.Second
+	defaultNetworkCompressionLevel int           = 3
+	defaultNetworkReconnect        time.Duration = 0 * time.Second
+	defaultNetworkReconnectMax     time.Duration = 300 * time.Second
+	defaultNetworkReconnectJitter  float64       = 0.2
 )
 
 // TransportTCPFactory holds the configuration from the configuration file
@@ -51,14 +57,18 @@ const (
 type TransportTCPFactory struct {
 	transport string
 
-	Reconnect      time.Duration `config:"reconnect backoff"`
-	ReconnectMax   time.Duration `config:"reconnect backoff max"`
-	SSLCertificate string        `config:"ssl certificate"`
-	SSLKey         string        `config:"ssl key"`
-	SSLCA          string        `config:"ssl ca"`
+	CompressionLevel int           `config:"compression level"`
+	Reconnect        time.Duration `config:"reconnect backoff"`
+	ReconnectMax     time.Duration `config:"reconnect backoff max"`
+	ReconnectJitter  float64       `config:"reconnect backoff jitter"`
+	SourceAddress    string        `config:"source address"`
+	SSLCertificate   string        `config:"ssl certificate"`
+	SSLKey           string        `config:"ssl key"`
+	SSLCA            string        `config:"ssl ca"`
 
 	hostportRegexp  *regexp.Regexp
 	netConfig       *config.Network
+	sourceAddr      *net.TCPAddr
 	certificate     *tls.Certificate
 	certificateList []*x509.Certificate
 	caList          []*x509.Certificate
@@ -66,33 +76,66 @@ type TransportTCPFactory struct {
 
 // NewTransportTCPFactory create a new TransportTCPFactory from the provided
 // configuration data, reporting back any configuration errors it discovers.
-func NewTransportTCPFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string) (interface{}, error) {
+func NewTransportTCPFactory(config *config.Config, netConfig *config.Network, configPath string, unUsed map[string]interface{}, name string) (interface{}, error) {
 	var err error
 
 	ret := &TransportTCPFactory{
 		transport:      name,
 		hostportRegexp: regexp.MustCompile(`^\[?([^]]+)\]?:([0-9]+)$`),
-		netConfig:      &config.Network,
+		netConfig:      netConfig,
 	}
 
-	// Only allow SSL configurations if using TLS
-	if name == TransportTCPTLS {
-		if err = config.PopulateConfig(ret, unUsed, configPath); err != nil {
-			return nil, err
+	if err = config.PopulateConfig(ret, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if ret.CompressionLevel < zlib.NoCompression || ret.CompressionLevel > zlib.BestCompression {
+		return nil, fmt.Errorf("Option %scompression level must be between %d and %d", configPath, zlib.NoCompression, zlib.BestCompression)
+	}
+
+	if ret.ReconnectJitter < 0 || ret.ReconnectJitter > 1 {
+		return nil, fmt.Errorf("Option %sreconnect backoff jitter must be between 0 and 1", configPath)
+	}
+
+	// The unix transport connects to a socket path rather than servers
+	if name == TransportTCPUnix {
+		if netConfig.Socket == "" {
+			return nil, fmt.Errorf("Option %ssocket is required when transport is unix", configPath)
+		}
+	} else if netConfig.Socket != "" {
+		return nil, fmt.Errorf("Option %ssocket is only available when transport is unix", configPath)
+	}
+
+	// The local address to connect from, which must be an IP address as it is
+	// used to select the network interface
+	if ret.SourceAddress != "" {
+		if name == TransportTCPUnix {
+			return nil, fmt.Errorf("Option %ssource address is only available when transport is tcp or tls", configPath)
 		}
 
+		ip := net.ParseIP(ret.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("Option %ssource address is not a valid IP address: %s", configPath, ret.SourceAddress)
+		}
+
+		ret.sourceAddr = &net.TCPAddr{IP: ip}
+	}
+
+	// Only allow SSL configurations if using TLS
+	if name == TransportTCPTLS {
 		if len(ret.SSLCertificate) > 0 || len(ret.SSLKey) > 0 {
 			if len(ret.SSLCertificate) == 0 {
-				return nil, errors.New("ssl key is only valid with a matching ssl certificate")
+				return nil, fmt.Errorf("Option %sssl key is only valid with a matching %sssl certificate", configPath, configPath)
 			}
 
 			if len(ret.SSLKey) == 0 {
-				return nil, errors.New("ssl key must be specified when a ssl certificate is provided")
+				return nil, fmt.Errorf("Option %sssl key must be specified when %sssl certificate is provided", configPath, configPath)
 			}
 
+			// LoadX509KeyPair also verifies the private key matches the certificate
 			certificate, err := tls.LoadX509KeyPair(ret.SSLCertificate, ret.SSLKey)
 			if err != nil {
-				return nil, fmt.Errorf("Failed loading client ssl certificate: %s", err)
+				return nil, fmt.Errorf("Failed loading client ssl certificate %s with key %s: %s", ret.SSLCertificate, ret.SSLKey, err)
 			}
 
 			ret.certificate = &certificate
@@ -133,19 +176,40 @@ func NewTransportTCPFactory(config *config.Config, configPath string, unUsed map
 				break
 			}
 		}
-	} else {
-		if err := config.ReportUnusedConfig(unUsed, configPath); err != nil {
-			return nil, err
-		}
+	} else if len(ret.SSLCertificate) > 0 || len(ret.SSLKey) > 0 || len(ret.SSLCA) > 0 {
+		return nil, fmt.Errorf("Options %sssl certificate, %sssl key and %sssl ca are only available when transport is tls", configPath, configPath, configPath)
 	}
 
 	return ret, nil
 }
 
+// configureTLS prepares the given TLS configuration with the client
+// certificate to present during the handshake and the CA list used to verify
+// the server
+func (f *TransportTCPFactory) configureTLS(tlsConfig *tls.Config) {
+	// Disable SSLv3 (mitigate POODLE vulnerability)
+	tlsConfig.MinVersion = tls.VersionTLS10
+
+	// Set the certificate if we set one
+	if f.certificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*f.certificate}
+	} else {
+		tlsConfig.Certificates = nil
+	}
+
+	// Set CA for server verification
+	tlsConfig.RootCAs = x509.NewCertPool()
+	for _, cert := range f.caList {
+		tlsConfig.RootCAs.AddCert(cert)
+	}
+}
+
 // InitDefaults sets the default configuration values
 func (f *TransportTCPFactory) InitDefaults() {
+	f.CompressionLevel = defaultNetworkCompressionLevel
 	f.Reconnect = defaultNetworkReconnect
 	f.ReconnectMax = defaultNetworkReconnectMax
+	f.ReconnectJitter = defaultNetworkReconnectJitter
 }
 
 // NewTransport returns a new Transport interface using the settings from the
@@ -159,6 +223,8 @@ func (f *TransportTCPFactory) NewTransport(observer transports.Observer, finishO
 		backoff:        core.NewExpBackoff(observer.Pool().Server()+" Reconnect", f.Reconnect, f.ReconnectMax),
 	}
 
+	ret.backoff.SetJitter(f.ReconnectJitter)
+
 	go ret.controller()
 
 	return ret
@@ -168,4 +234,5 @@ func (f *TransportTCPFactory) NewTransport(observer transports.Observer, finishO
 func init() {
 	config.RegisterTransport(TransportTCPTCP, NewTransportTCPFactory)
 	config.RegisterTransport(TransportTCPTLS, NewTransportTCPFactory)
+	config.RegisterTransport(TransportTCPUnix, NewTransportTCPFactory)
 }
@@ -0,0 +1,281 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+type testCertificate struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+func createTestCertificate(t *testing.T, dir string, name string, parent *testCertificate, isCA bool) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		DNSNames:              []string{name},
+	}
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %s", err)
+	}
+
+	ret := &testCertificate{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+
+	if err := ioutil.WriteFile(ret.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %s", err)
+	}
+	if err := ioutil.WriteFile(ret.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %s", err)
+	}
+
+	return ret
+}
+
+func createTestFactory(unUsed map[string]interface{}) (*TransportTCPFactory, error) {
+	factory, err := NewTransportTCPFactory(&config.Config{}, &config.Network{}, "/network/", unUsed, TransportTCPTLS)
+	if err != nil {
+		return nil, err
+	}
+	return factory.(*TransportTCPFactory), nil
+}
+
+func TestFactoryClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcouriertest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := createTestCertificate(t, dir, "ca", nil, true)
+	client := createTestCertificate(t, dir, "client", ca, false)
+
+	factory, err := createTestFactory(map[string]interface{}{
+		"ssl ca":          ca.certFile,
+		"ssl certificate": client.certFile,
+		"ssl key":         client.keyFile,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if factory.certificate == nil {
+		t.Fatal("Client certificate was not loaded")
+	}
+	if len(factory.certificateList) != 1 || !factory.certificateList[0].Equal(client.cert) {
+		t.Fatal("Client certificate list does not contain the client certificate")
+	}
+}
+
+func TestFactoryClientCertificateErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcouriertest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := createTestCertificate(t, dir, "ca", nil, true)
+	client := createTestCertificate(t, dir, "client", ca, false)
+	other := createTestCertificate(t, dir, "other", ca, false)
+
+	tests := []struct {
+		name     string
+		config   map[string]interface{}
+		expected string
+	}{
+		{
+			"missing key",
+			map[string]interface{}{"ssl ca": ca.certFile, "ssl certificate": client.certFile},
+			"Option /network/ssl key must be specified when /network/ssl certificate is provided",
+		},
+		{
+			"missing certificate",
+			map[string]interface{}{"ssl ca": ca.certFile, "ssl key": client.keyFile},
+			"Option /network/ssl key is only valid with a matching /network/ssl certificate",
+		},
+		{
+			"mismatched key",
+			map[string]interface{}{"ssl ca": ca.certFile, "ssl certificate": client.certFile, "ssl key": other.keyFile},
+			"Failed loading client ssl certificate",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := createTestFactory(test.config)
+		if err == nil {
+			t.Errorf("Expected error for %s", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Unexpected error for %s: %s", test.name, err)
+		}
+	}
+}
+
+func TestConfigureTLSMutual(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcouriertest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := createTestCertificate(t, dir, "ca", nil, true)
+	server := createTestCertificate(t, dir, "localhost", ca, false)
+	client := createTestCertificate(t, dir, "client", ca, false)
+
+	serverCertificate, err := tls.LoadX509KeyPair(server.certFile, server.keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load server certificate: %s", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	peerChan := make(chan []*x509.Certificate, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			peerChan <- nil
+			return
+		}
+		defer conn.Close()
+
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil {
+			peerChan <- nil
+			return
+		}
+		peerChan <- tlsConn.ConnectionState().PeerCertificates
+	}()
+
+	factory, err := createTestFactory(map[string]interface{}{
+		"ssl ca":          ca.certFile,
+		"ssl certificate": client.certFile,
+		"ssl key":         client.keyFile,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	tlsConfig := &tls.Config{ServerName: "localhost"}
+	factory.configureTLS(tlsConfig)
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), tlsConfig)
+	if err != nil {
+		t.Fatalf("Handshake failed: %s", err)
+	}
+	defer conn.Close()
+
+	peers := <-peerChan
+	if len(peers) == 0 || !peers[0].Equal(client.cert) {
+		t.Fatal("Server did not receive the client certificate")
+	}
+}
+
+func TestFactorySocket(t *testing.T) {
+	if _, err := NewTransportTCPFactory(&config.Config{}, &config.Network{}, "/network/", nil, TransportTCPUnix); err == nil || !strings.Contains(err.Error(), "/network/socket is required") {
+		t.Errorf("Unexpected error for missing socket: %v", err)
+	}
+
+	if _, err := NewTransportTCPFactory(&config.Config{}, &config.Network{Socket: "/var/run/courier.sock"}, "/network/", nil, TransportTCPTCP); err == nil || !strings.Contains(err.Error(), "/network/socket is only available") {
+		t.Errorf("Unexpected error for socket with tcp: %v", err)
+	}
+
+	if _, err := NewTransportTCPFactory(&config.Config{}, &config.Network{Socket: "/var/run/courier.sock"}, "/network/", map[string]interface{}{"ssl ca": "/etc/ca.crt"}, TransportTCPUnix); err == nil || !strings.Contains(err.Error(), "only available when transport is tls") {
+		t.Errorf("Unexpected error for ssl options with unix: %v", err)
+	}
+}
+
+func TestFactorySourceAddress(t *testing.T) {
+	factory, err := NewTransportTCPFactory(&config.Config{}, &config.Network{}, "/network/", map[string]interface{}{"source address": "127.0.0.1"}, TransportTCPTCP)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if addr := factory.(*TransportTCPFactory).sourceAddr; addr == nil || !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Unexpected source address: %v", addr)
+	}
+
+	if _, err := NewTransportTCPFactory(&config.Config{}, &config.Network{}, "/network/", map[string]interface{}{"source address": "eth0"}, TransportTCPTCP); err == nil || !strings.Contains(err.Error(), "/network/source address is not a valid IP address") {
+		t.Errorf("Unexpected error for invalid source address: %v", err)
+	}
+
+	if _, err := NewTransportTCPFactory(&config.Config{}, &config.Network{Socket: "/var/run/courier.sock"}, "/network/", map[string]interface{}{"source address": "127.0.0.1"}, TransportTCPUnix); err == nil || !strings.Contains(err.Error(), "/network/source address is only available") {
+		t.Errorf("Unexpected error for source address with unix: %v", err)
+	}
+}
@@ -23,7 +23,6 @@ import (
 	"bytes"
 	"compress/zlib"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/binary"
 	"fmt"
 	"net"
@@ -51,6 +50,7 @@ type TransportTCP struct {
 	tlsSocket    *tls.Conn
 	tlsConfig    tls.Config
 	backoff      *core.ExpBackoff
+	desc         string
 
 	controllerChan chan int
 	observer       transports.Observer
@@ -78,13 +78,32 @@ func (t *TransportTCP) ReloadConfig(factoryInterface interface{}, finishOnFail b
 		return true
 	}
 
-	// Only copy net config just in case something in the factory did change that
-	// we didn't account for which does require a restart
-	t.config.netConfig = newConfig.netConfig
+	if newConfig.SourceAddress != t.config.SourceAddress {
+		return true
+	}
+
+	// The compression level and backoff are used by other routines, so a restart
+	// is simplest
+	if newConfig.CompressionLevel != t.config.CompressionLevel || newConfig.Reconnect != t.config.Reconnect || newConfig.ReconnectMax != t.config.ReconnectMax || newConfig.ReconnectJitter != t.config.ReconnectJitter {
+		return true
+	}
+
+	// The network configuration is read by the connection routines whilst they
+	// run, so rather than swap it, restart if anything we use has changed
+	newNet, oldNet := newConfig.netConfig, t.config.netConfig
+	if newNet.ConnectTimeout != oldNet.ConnectTimeout || newNet.WriteTimeout != oldNet.WriteTimeout || newNet.MaxPendingPayloads != oldNet.MaxPendingPayloads || newNet.Socket != oldNet.Socket {
+		return true
+	}
 
 	return false
 }
 
+// ReconnectBackoff returns the delay currently being applied between
+// reconnection attempts, or 0 if the connection is healthy
+func (t *TransportTCP) ReconnectBackoff() time.Duration {
+	return t.backoff.Current()
+}
+
 // controller is the master routine which handles connection and reconnection
 // When reconnecting, the socket and all routines are torn down and restarted.
 // It also
@@ -104,9 +123,9 @@ func (t *TransportTCP) controller() {
 			return
 		}
 		if err == nil {
-			// Connected - sit and wait for shutdown or error
-			t.backoff.Reset()
-
+			// Connected - sit and wait for shutdown or error. The backoff is reset
+			// by the receiver once the first acknowledgement arrives, so a server
+			// that accepts connections but then fails them still gets backed off
 			select {
 			// TODO: Handle configuration reload
 			case <-t.controllerChan:
@@ -175,43 +194,45 @@ func (t *TransportTCP) connect() (bool, error) {
 		t.disconnect()
 	}
 
-	addr, err := t.observer.Pool().Next()
+	network, address, desc, err := t.nextAddress()
 	if err != nil {
-		return false, fmt.Errorf("Failed to select next address: %s", err)
+		return false, err
 	}
 
-	desc := t.observer.Pool().Desc()
-
 	log.Info("[%s] Attempting to connect to %s", t.observer.Pool().Server(), desc)
 
-	tcpsocket, err := net.DialTimeout("tcp", addr.String(), t.config.netConfig.Timeout)
+	dialer := net.Dialer{Timeout: t.config.netConfig.ConnectTimeout}
+	if t.config.sourceAddr != nil {
+		dialer.LocalAddr = t.config.sourceAddr
+	}
+
+	// A missing or refused socket is handled the same as a refused connection,
+	// so we back off and retry until it reappears
+	tcpsocket, err := dialer.Dial(network, address)
 	if err != nil {
+		if t.config.sourceAddr != nil {
+			// This includes failing to bind the source address, which is retried
+			// in the same way rather than allowing any other interface to be used
+			return false, fmt.Errorf("Failed to connect to %s from source address %s: %s", desc, t.config.SourceAddress, err)
+		}
 		return false, fmt.Errorf("Failed to connect to %s: %s", desc, err)
 	}
 
+	// All writes go through the wrapper so they can observe shutdown and the
+	// write timeout whilst blocked
+	wrapsocket := &transportTCPWrap{transport: t, tcpsocket: tcpsocket}
+
 	// Now wrap in TLS if this is the TLS transport
 	if t.config.transport == TransportTCPTLS {
-		// Disable SSLv3 (mitigate POODLE vulnerability)
-		t.tlsConfig.MinVersion = tls.VersionTLS10
-
-		// Set the certificate if we set one
-		if t.config.certificate != nil {
-			t.tlsConfig.Certificates = []tls.Certificate{*t.config.certificate}
-		} else {
-			t.tlsConfig.Certificates = nil
-		}
-
-		// Set CA for server verification
-		t.tlsConfig.RootCAs = x509.NewCertPool()
-		for _, cert := range t.config.caList {
-			t.tlsConfig.RootCAs.AddCert(cert)
-		}
+		t.config.configureTLS(&t.tlsConfig)
 
 		// Set the tlsConfig server name for server validation (required since Go 1.3)
 		t.tlsConfig.ServerName = t.observer.Pool().Host()
 
-		t.tlsSocket = tls.Client(&transportTCPWrap{transport: t, tcpsocket: tcpsocket}, &t.tlsConfig)
-		t.tlsSocket.SetDeadline(time.Now().Add(t.config.netConfig.Timeout))
+		t.tlsSocket = tls.Client(wrapsocket, &t.tlsConfig)
+		if t.config.netConfig.ConnectTimeout > 0 {
+			t.tlsSocket.SetDeadline(time.Now().Add(t.config.netConfig.ConnectTimeout))
+		}
 		err = t.tlsSocket.Handshake()
 		if err != nil {
 			t.tlsSocket.Close()
@@ -219,13 +240,15 @@ func (t *TransportTCP) connect() (bool, error) {
 			t.checkClientCertificates()
 			return false, fmt.Errorf("TLS Handshake failure with %s: %s", desc, err)
 		}
+		t.tlsSocket.SetDeadline(time.Time{})
 
 		t.socket = t.tlsSocket
 	} else {
-		t.socket = tcpsocket
+		t.socket = wrapsocket
 	}
 
 	log.Notice("[%s] Connected to %s", t.observer.Pool().Server(), desc)
+	t.desc = desc
 
 	// Signal channels
 	t.sendControl = make(chan int, 1)
@@ -250,6 +273,21 @@ func (t *TransportTCP) connect() (bool, error) {
 	return false, nil
 }
 
+// nextAddress returns the network, address and description of the next
+// address to connect to
+func (t *TransportTCP) nextAddress() (string, string, string, error) {
+	if t.config.transport == TransportTCPUnix {
+		return "unix", t.config.netConfig.Socket, t.config.netConfig.Socket, nil
+	}
+
+	addr, err := t.observer.Pool().Next()
+	if err != nil {
+		return "", "", "", fmt.Errorf("Failed to select next address: %s", err)
+	}
+
+	return "tcp", addr.String(), t.observer.Pool().Desc(), nil
+}
+
 // checkClientCertificates logs a warning if it finds any certificates that are
 // not currently valid
 func (t *TransportTCP) checkClientCertificates() {
@@ -299,7 +337,7 @@ func (t *TransportTCP) disconnect() {
 
 	t.socket.Close()
 
-	log.Notice("[%s] Disconnected from %s", t.observer.Pool().Server(), t.observer.Pool().Desc())
+	log.Notice("[%s] Disconnected from %s", t.observer.Pool().Server(), t.desc)
 }
 
 // sender handles socket writes
@@ -322,11 +360,21 @@ SenderLoop:
 		case msg := <-t.sendChan:
 			// Write deadline is managed by our net.Conn wrapper that TLS will call
 			// into and keeps retrying writes until timeout or error
+			if t.config.netConfig.WriteTimeout > 0 {
+				t.socket.SetWriteDeadline(time.Now().Add(t.config.netConfig.WriteTimeout))
+			}
 			_, err := t.socket.Write(msg)
 			if err != nil {
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-					// Shutdown will have been received by the wrapper
-					break SenderLoop
+					select {
+					case <-t.sendControl:
+						// Shutdown will have been received by the wrapper
+						break SenderLoop
+					default:
+					}
+
+					// The server is no longer reading what we send
+					err = fmt.Errorf("Write to %s timed out after %s", t.desc, t.config.netConfig.WriteTimeout)
 				}
 				// Fail the transport
 				select {
@@ -348,6 +396,7 @@ func (t *TransportTCP) receiver() {
 	var err error
 	var shutdown bool
 	var message []byte
+	var acknowledged bool
 
 	header := make([]byte, 8)
 
@@ -387,6 +436,11 @@ ReceiverLoop:
 				break ReceiverLoop
 			}
 
+			if !acknowledged {
+				t.backoff.Reset()
+				acknowledged = true
+			}
+
 			if t.sendEvent(t.recvControl, transports.NewAckEventWithBytes(t.observer, message[0:16], message[16:20])) {
 				break ReceiverLoop
 			}
@@ -464,6 +518,21 @@ func (t *TransportTCP) sendEvent(controlChan <-chan int, event transports.Event)
 
 // Write a message to the transport
 func (t *TransportTCP) Write(nonce string, events []*core.EventDescriptor) error {
+	messageBytes, err := encodeEvents(nonce, events, t.config.CompressionLevel)
+	if err != nil {
+		return err
+	}
+
+	t.sendChan <- messageBytes
+	return nil
+}
+
+// encodeEvents encodes events into a JDAT message, compressing them at the
+// given zlib compression level. A level of 0 means the data is stored within
+// the zlib stream without compression, rather than using a new uncompressed
+// message type, so that it can still be read by any receiver. The stored
+// blocks only add a few bytes for every 64KiB
+func encodeEvents(nonce string, events []*core.EventDescriptor, level int) ([]byte, error) {
 	var messageBuffer bytes.Buffer
 
 	// Encapsulate the data into the message
@@ -471,12 +540,12 @@ func (t *TransportTCP) Write(nonce string, events []*core.EventDescriptor) error
 	// 4-byte uint32 data length
 	// Then the data
 	if _, err := messageBuffer.Write([]byte("JDAT")); err != nil {
-		return err
+		return nil, err
 	}
 
 	// False length as we don't know it yet
 	if _, err := messageBuffer.Write([]byte("----")); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create the compressed data payload
@@ -484,25 +553,28 @@ func (t *TransportTCP) Write(nonce string, events []*core.EventDescriptor) error
 	// The event data is each event, prefixed with a 4-byte uint32 length, one
 	// after the other
 	if _, err := messageBuffer.Write([]byte(nonce)); err != nil {
-		return err
+		return nil, err
 	}
 
-	compressor, err := zlib.NewWriterLevel(&messageBuffer, 3)
+	compressor, err := zlib.NewWriterLevel(&messageBuffer, level)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for _, event := range events {
 		if err := binary.Write(compressor, binary.BigEndian, uint32(len(event.Event))); err != nil {
-			return err
+			return nil, err
 		}
 
 		if _, err := compressor.Write(event.Event); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	compressor.Close()
+	// Failing to complete the compressed stream would send corrupt data
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
 
 	// Fill in the size
 	// TODO: This prevents us bypassing buffer and just sending...
@@ -510,8 +582,7 @@ func (t *TransportTCP) Write(nonce string, events []*core.EventDescriptor) error
 	messageBytes := messageBuffer.Bytes()
 	binary.BigEndian.PutUint32(messageBytes[4:8], uint32(messageBuffer.Len()-8))
 
-	t.sendChan <- messageBytes
-	return nil
+	return messageBytes, nil
 }
 
 // Ping the remote server
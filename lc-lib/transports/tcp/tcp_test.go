@@ -0,0 +1,451 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+func createTestEvents(count int) []*core.EventDescriptor {
+	events := make([]*core.EventDescriptor, count)
+	for i := 0; i < count; i++ {
+		event := core.Event{
+			"message":  fmt.Sprintf("192.168.0.%d - - [18/Feb/2017:10:00:%02d +0000] \"GET /index.html?page=%d HTTP/1.1\" 200 %d \"-\" \"Mozilla/5.0\"", i%255, i%60, i, 1000+i),
+			"host":     "localhost.localdomain",
+			"path":     "/var/log/nginx/access.log",
+			"offset":   int64(i * 120),
+			"timezone": "+0000 UTC",
+		}
+		encoded, err := event.Encode()
+		if err != nil {
+			panic(err)
+		}
+		events[i] = &core.EventDescriptor{Event: encoded}
+	}
+	return events
+}
+
+func decodeTestMessage(t *testing.T, message []byte) (string, [][]byte) {
+	if string(message[0:4]) != "JDAT" {
+		t.Fatalf("Unexpected message type: %s", message[0:4])
+	}
+
+	if length := binary.BigEndian.Uint32(message[4:8]); int(length) != len(message)-8 {
+		t.Fatalf("Unexpected message length: %d (expected %d)", length, len(message)-8)
+	}
+
+	nonce := string(message[8:24])
+
+	reader, err := zlib.NewReader(bytes.NewReader(message[24:]))
+	if err != nil {
+		t.Fatalf("Failed to read compressed data: %s", err)
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to decompress data: %s", err)
+	}
+
+	var events [][]byte
+	for len(data) != 0 {
+		length := binary.BigEndian.Uint32(data[0:4])
+		events = append(events, data[4:4+length])
+		data = data[4+length:]
+	}
+
+	return nonce, events
+}
+
+func TestEncodeEventsLevels(t *testing.T) {
+	events := createTestEvents(100)
+	nonce := "0123456789abcdef"
+
+	for level := zlib.NoCompression; level <= zlib.BestCompression; level++ {
+		message, err := encodeEvents(nonce, events, level)
+		if err != nil {
+			t.Fatalf("Failed to encode events at level %d: %s", level, err)
+		}
+
+		decodedNonce, decoded := decodeTestMessage(t, message)
+		if decodedNonce != nonce {
+			t.Errorf("Unexpected nonce at level %d: %s", level, decodedNonce)
+		}
+		if len(decoded) != len(events) {
+			t.Fatalf("Unexpected number of events at level %d: %d", level, len(decoded))
+		}
+		for i, event := range decoded {
+			if !bytes.Equal(event, events[i].Event) {
+				t.Errorf("Event %d was corrupted at level %d", i, level)
+			}
+		}
+	}
+}
+
+func TestEncodeEventsInvalidLevel(t *testing.T) {
+	if _, err := encodeEvents("0123456789abcdef", createTestEvents(1), 10); err == nil {
+		t.Error("Encoding succeeded with an invalid compression level")
+	}
+}
+
+func BenchmarkEncodeEvents(b *testing.B) {
+	events := createTestEvents(1024)
+
+	for _, level := range []int{zlib.NoCompression, zlib.BestSpeed, defaultNetworkCompressionLevel, 6, zlib.BestCompression} {
+		b.Run(fmt.Sprintf("Level%d", level), func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				message, err := encodeEvents("0123456789abcdef", events, level)
+				if err != nil {
+					b.Fatalf("Failed to encode events: %s", err)
+				}
+				size = len(message)
+			}
+			b.ReportMetric(float64(size), "wire-bytes/op")
+		})
+	}
+}
+
+type testObserver struct {
+	pool      *addresspool.Pool
+	eventChan chan transports.Event
+}
+
+func (o *testObserver) Pool() *addresspool.Pool {
+	return o.pool
+}
+
+func (o *testObserver) EventChan() chan<- transports.Event {
+	return o.eventChan
+}
+
+// waitEvent waits for an event matching the given check, discarding others,
+// such as the repeated failures whilst waiting to reconnect
+func waitEvent(t *testing.T, eventChan <-chan transports.Event, desc string, check func(transports.Event) bool) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-eventChan:
+			if check(event) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timeout waiting for %s", desc)
+		}
+	}
+}
+
+func waitStatus(t *testing.T, eventChan <-chan transports.Event, status transports.StatusChange) {
+	waitEvent(t, eventChan, fmt.Sprintf("status %d", status), func(event transports.Event) bool {
+		statusEvent, ok := event.(*transports.StatusEvent)
+		return ok && statusEvent.StatusChange() == status
+	})
+}
+
+func TestTransportUnixReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcouriertest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "courier.sock")
+	netConfig := &config.Network{Servers: []string{path}, Socket: path, Timeout: time.Second, MaxPendingPayloads: 10}
+	factory, err := NewTransportTCPFactory(&config.Config{}, netConfig, "/network/", map[string]interface{}{
+		"reconnect backoff":     "50ms",
+		"reconnect backoff max": "100ms",
+	}, TransportTCPUnix)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	eventChan := make(chan transports.Event)
+	observer := &testObserver{pool: addresspool.NewPool(path), eventChan: eventChan}
+	transport := factory.(*TransportTCPFactory).NewTransport(observer, false)
+
+	// The socket does not exist yet
+	waitStatus(t, eventChan, transports.Failed)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatalf("Failed to listen: %s", err)
+		}
+
+		connChan := make(chan net.Conn, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				connChan <- nil
+				return
+			}
+			connChan <- conn
+		}()
+
+		waitStatus(t, eventChan, transports.Started)
+
+		conn := <-connChan
+		if conn == nil {
+			t.Fatal("Failed to accept connection")
+		}
+
+		if err := transport.Write("0123456789abcdef", createTestEvents(1)); err != nil {
+			t.Fatalf("Failed to write events: %s", err)
+		}
+
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(conn, header); err != nil || string(header[0:4]) != "JDAT" {
+			t.Fatalf("Unexpected message received: %v %s", header, err)
+		}
+		message := make([]byte, binary.BigEndian.Uint32(header[4:8]))
+		if _, err := io.ReadFull(conn, message); err != nil {
+			t.Fatalf("Failed to read message: %s", err)
+		}
+
+		ack := append([]byte("ACKN\x00\x00\x00\x14"), message[0:16]...)
+		ack = append(ack, 0, 0, 0, 1)
+		if _, err := conn.Write(ack); err != nil {
+			t.Fatalf("Failed to write acknowledgement: %s", err)
+		}
+
+		waitEvent(t, eventChan, "acknowledgement", func(event transports.Event) bool {
+			ackEvent, ok := event.(*transports.AckEvent)
+			return ok && ackEvent.Nonce() == "0123456789abcdef" && ackEvent.Sequence() == 1
+		})
+
+		// Closing the listener removes the socket, as if the receiver restarted
+		conn.Close()
+		listener.Close()
+
+		waitStatus(t, eventChan, transports.Failed)
+	}
+
+	transport.Shutdown()
+	waitStatus(t, eventChan, transports.Finished)
+}
+
+func TestTransportSourceAddress(t *testing.T) {
+	// Linux routes the whole loopback range locally, other systems may not
+	if probe, err := net.Listen("tcp", "127.0.0.2:0"); err != nil {
+		t.Skipf("Source address 127.0.0.2 is not available: %s", err)
+	} else {
+		probe.Close()
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	server := listener.Addr().String()
+	netConfig := &config.Network{Servers: []string{server}, Timeout: time.Second, MaxPendingPayloads: 10}
+	factory, err := NewTransportTCPFactory(&config.Config{}, netConfig, "/network/", map[string]interface{}{
+		"source address": "127.0.0.2",
+	}, TransportTCPTCP)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	connChan := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			connChan <- nil
+			return
+		}
+		connChan <- conn
+	}()
+
+	eventChan := make(chan transports.Event)
+	observer := &testObserver{pool: addresspool.NewPool(server), eventChan: eventChan}
+	transport := factory.(*TransportTCPFactory).NewTransport(observer, false)
+
+	waitStatus(t, eventChan, transports.Started)
+
+	conn := <-connChan
+	if conn == nil {
+		t.Fatal("Failed to accept connection")
+	}
+	if addr := conn.RemoteAddr().(*net.TCPAddr); !addr.IP.Equal(net.IPv4(127, 0, 0, 2)) {
+		t.Errorf("Connection was not made from the source address: %s", addr)
+	}
+	conn.Close()
+
+	transport.Shutdown()
+	waitStatus(t, eventChan, transports.Finished)
+}
+
+// listenStalled starts a server that accepts connections but never reads from
+// them, as if the receiver had hung
+func listenStalled(t *testing.T) (net.Listener, chan net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	connChan := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(connChan)
+				return
+			}
+			connChan <- conn
+		}
+	}()
+
+	return listener, connChan
+}
+
+func closeStalled(listener net.Listener, connChan chan net.Conn) {
+	listener.Close()
+	for conn := range connChan {
+		conn.Close()
+	}
+}
+
+func TestTransportWriteTimeout(t *testing.T) {
+	listener, connChan := listenStalled(t)
+	defer closeStalled(listener, connChan)
+
+	server := listener.Addr().String()
+	netConfig := &config.Network{Servers: []string{server}, Timeout: time.Second, WriteTimeout: 200 * time.Millisecond, MaxPendingPayloads: 10}
+	factory, err := NewTransportTCPFactory(&config.Config{}, netConfig, "/network/", map[string]interface{}{
+		"compression level":     0,
+		"reconnect backoff":     "50ms",
+		"reconnect backoff max": "100ms",
+	}, TransportTCPTCP)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	eventChan := make(chan transports.Event)
+	observer := &testObserver{pool: addresspool.NewPool(server), eventChan: eventChan}
+	transport := factory.(*TransportTCPFactory).NewTransport(observer, false)
+
+	waitStatus(t, eventChan, transports.Started)
+
+	// Send far more than the socket buffers can hold so the write blocks
+	message := bytes.Repeat([]byte("x"), 1048576)
+	for i := 0; i < 8; i++ {
+		events := make([]*core.EventDescriptor, 4)
+		for j := range events {
+			events[j] = &core.EventDescriptor{Offset: int64(j), Event: message}
+		}
+		if err := transport.Write(fmt.Sprintf("%016d", i), events); err != nil {
+			t.Fatalf("Failed to write events: %s", err)
+		}
+	}
+
+	waitStatus(t, eventChan, transports.Failed)
+
+	transport.Shutdown()
+	waitStatus(t, eventChan, transports.Finished)
+}
+
+func TestTransportConnectTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcouriertest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := createTestCertificate(t, dir, "ca", nil, true)
+
+	listener, connChan := listenStalled(t)
+	defer closeStalled(listener, connChan)
+
+	server := listener.Addr().String()
+	netConfig := &config.Network{Servers: []string{server}, Timeout: time.Second, ConnectTimeout: 200 * time.Millisecond, MaxPendingPayloads: 10}
+	factory, err := NewTransportTCPFactory(&config.Config{}, netConfig, "/network/", map[string]interface{}{
+		"ssl ca":                ca.certFile,
+		"reconnect backoff":     "50ms",
+		"reconnect backoff max": "100ms",
+	}, TransportTCPTLS)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	eventChan := make(chan transports.Event)
+	observer := &testObserver{pool: addresspool.NewPool(server), eventChan: eventChan}
+	transport := factory.(*TransportTCPFactory).NewTransport(observer, false)
+
+	// The handshake never completes as the server never replies
+	waitStatus(t, eventChan, transports.Failed)
+
+	transport.Shutdown()
+	waitStatus(t, eventChan, transports.Finished)
+}
+
+func TestTransportReloadSourceAddress(t *testing.T) {
+	netConfig := &config.Network{}
+	factory, err := NewTransportTCPFactory(&config.Config{}, netConfig, "/network/", map[string]interface{}{"source address": "127.0.0.1"}, TransportTCPTCP)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	transport := &TransportTCP{config: factory.(*TransportTCPFactory)}
+
+	if transport.ReloadConfig(factory, false) {
+		t.Error("Transport restarted with an unchanged source address")
+	}
+
+	changed, err := NewTransportTCPFactory(&config.Config{}, netConfig, "/network/", map[string]interface{}{"source address": "127.0.0.2"}, TransportTCPTCP)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !transport.ReloadConfig(changed, false) {
+		t.Error("Transport did not restart with a changed source address")
+	}
+}
+
+func TestTransportReloadCompressionAndBackoff(t *testing.T) {
+	netConfig := &config.Network{}
+	factory, err := NewTransportTCPFactory(&config.Config{}, netConfig, "/network/", map[string]interface{}{}, TransportTCPTCP)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	transport := &TransportTCP{config: factory.(*TransportTCPFactory)}
+
+	for _, option := range []map[string]interface{}{
+		{"compression level": 9},
+		{"reconnect backoff jitter": 0.5},
+	} {
+		changed, err := NewTransportTCPFactory(&config.Config{}, netConfig, "/network/", option, TransportTCPTCP)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if !transport.ReloadConfig(changed, false) {
+			t.Errorf("Transport did not restart when changing %v", option)
+		}
+	}
+}
@@ -25,9 +25,14 @@ import (
 // SetWriteDeadline with it directly. So we wrap the given tcpsocket and handle
 // the SetWriteDeadline there and check shutdown signal and loop. Inside
 // tls.Conn the Write blocks until it finishes and everyone is happy
+//
+// The deadline given to SetWriteDeadline is remembered rather than applied,
+// and only once it passes is the timeout returned, by which point the
+// connection is to be abandoned anyway
 type transportTCPWrap struct {
-	transport *TransportTCP
-	tcpsocket net.Conn
+	transport     *TransportTCP
+	tcpsocket     net.Conn
+	writeDeadline time.Time
 
 	net.Conn
 }
@@ -42,7 +47,11 @@ func (w *transportTCPWrap) Write(b []byte) (n int, err error) {
 RetrySend:
 	for {
 		// Timeout after socket_interval_seconds, check for shutdown, and try again
-		w.tcpsocket.SetWriteDeadline(time.Now().Add(socketIntervalSeconds * time.Second))
+		deadline := time.Now().Add(socketIntervalSeconds * time.Second)
+		if !w.writeDeadline.IsZero() && w.writeDeadline.Before(deadline) {
+			deadline = w.writeDeadline
+		}
+		w.tcpsocket.SetWriteDeadline(deadline)
 
 		n, err = w.tcpsocket.Write(b[length:])
 		length += n
@@ -57,6 +66,11 @@ RetrySend:
 		}
 
 		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			if !w.writeDeadline.IsZero() && !time.Now().Before(w.writeDeadline) {
+				// The requested deadline has passed
+				return length, err
+			}
+
 			// Check for shutdown, then try again
 			select {
 			case <-w.transport.sendControl:
@@ -84,7 +98,8 @@ func (w *transportTCPWrap) RemoteAddr() net.Addr {
 }
 
 func (w *transportTCPWrap) SetDeadline(t time.Time) error {
-	return w.tcpsocket.SetDeadline(t)
+	w.writeDeadline = t
+	return w.tcpsocket.SetReadDeadline(t)
 }
 
 func (w *transportTCPWrap) SetReadDeadline(t time.Time) error {
@@ -92,5 +107,6 @@ func (w *transportTCPWrap) SetReadDeadline(t time.Time) error {
 }
 
 func (w *transportTCPWrap) SetWriteDeadline(t time.Time) error {
-	return w.tcpsocket.SetWriteDeadline(t)
+	w.writeDeadline = t
+	return nil
 }
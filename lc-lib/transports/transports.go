@@ -18,6 +18,7 @@ package transports
 
 import (
 	"errors"
+	"time"
 
 	"github.com/driskell/log-courier/lc-lib/addresspool"
 	"github.com/driskell/log-courier/lc-lib/core"
@@ -44,6 +45,12 @@ type Transport interface {
 	Write(string, []*core.EventDescriptor) error
 }
 
+// ReconnectReporter is implemented by transports that back off between
+// reconnection attempts and can report the delay currently in effect
+type ReconnectReporter interface {
+	ReconnectBackoff() time.Duration
+}
+
 // transportFactory is the interface that all transport factories implement. The
 // transport factory should store the transport's configuration and, when
 // NewTransport is called, return an instance of the transport that obeys that
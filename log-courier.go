@@ -26,22 +26,16 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
-	"github.com/driskell/log-courier/lc-lib/harvester"
-	"github.com/driskell/log-courier/lc-lib/prospector"
-	"github.com/driskell/log-courier/lc-lib/publisher"
-	"github.com/driskell/log-courier/lc-lib/registrar"
-	"github.com/driskell/log-courier/lc-lib/spooler"
+	"github.com/driskell/log-courier/lc-lib/courier"
 	"gopkg.in/op/go-logging.v1"
 )
 
-import _ "github.com/driskell/log-courier/lc-lib/codecs"
-import _ "github.com/driskell/log-courier/lc-lib/transports/tcp"
-
 // Generate platform-specific default configuration values
 //go:generate go run lc-lib/config/generate/platform.go platform main config.DefaultConfigurationFile config.DefaultGeneralPersistDir admin.DefaultAdminBind
 // TODO: This should be in lc-admin but we can't due to vendor failure on go generate in subpackages
@@ -53,104 +47,63 @@ func main() {
 
 // logCourier is the root structure for the log-courier binary
 type logCourier struct {
-	pipeline      *core.Pipeline
+	courier       *courier.Courier
 	config        *config.Config
 	shutdownChan  chan os.Signal
 	reloadChan    chan os.Signal
 	configFile    string
 	stdin         bool
 	fromBeginning bool
-	harvester     *harvester.Harvester
 	logFile       *DefaultLogBackend
-	lastSnapshot  time.Time
-	snapshot      *core.Snapshot
+
+	// reloadMutex serialises reloads, which can be requested by signal or
+	// through the admin interface
+	reloadMutex sync.Mutex
 }
 
 // newLogCourier creates a new LogCourier structure for the log-courier binary
 func newLogCourier() *logCourier {
-	ret := &logCourier{
-		pipeline: core.NewPipeline(),
-	}
-	return ret
+	return &logCourier{}
 }
 
 // Run starts the log-courier binary
 func (lc *logCourier) Run() {
-	var harvesterWait <-chan *harvester.FinishStatus
-	var registrarImp registrar.Registrator
+	var err error
 
 	lc.startUp()
 
-	log.Info("Log Courier version %s pipeline starting", core.LogCourierVersion)
-
-	// If reading from stdin, skip admin, and set up a null registrar
-	if lc.stdin {
-		registrarImp = newStdinRegistrar(lc.pipeline)
-	} else {
-		if lc.config.Get("admin").(*admin.Config).Enabled {
-			var err error
-
-			// TODO: Reload config and load config should be in core along with
-			// logging implementation
-			_, err = admin.NewServer(lc.pipeline, lc.config, func() error {
-				return lc.reloadConfig()
-			})
-			if err != nil {
-				log.Fatalf("Failed to initialise: %s", err)
-			}
-		}
-
-		registrarImp = registrar.NewRegistrar(lc.pipeline, lc.config.General.PersistDir)
+	if lc.courier, err = courier.New(lc.config); err != nil {
+		log.Fatalf("Failed to initialise: %s", err)
 	}
 
-	publisherImp := publisher.NewPublisher(lc.pipeline, lc.config, registrarImp)
-
-	spoolerImp := spooler.NewSpooler(lc.pipeline, &lc.config.General, publisherImp)
+	lc.courier.FromBeginning = lc.fromBeginning
+	lc.courier.ReloadFunc = lc.reloadConfig
 
-	// If reading from stdin, don't start prospector, directly start a harvester
-	if lc.stdin {
-		lc.harvester = harvester.NewHarvester(nil, lc.config, &lc.config.Stdin, 0)
-		lc.harvester.Start(spoolerImp.Connect())
-		harvesterWait = lc.harvester.OnFinish()
-	} else {
-		if _, err := prospector.NewProspector(lc.pipeline, lc.config, lc.fromBeginning, registrarImp, spoolerImp); err != nil {
-			log.Fatalf("Failed to initialise: %s", err)
-		}
+	if err = lc.courier.Start(); err != nil {
+		log.Fatalf("Failed to initialise: %s", err)
 	}
 
-	// Start the pipeline
-	lc.pipeline.Start()
-
-	log.Notice("Pipeline ready")
-
 	lc.shutdownChan = make(chan os.Signal, 1)
 	lc.reloadChan = make(chan os.Signal, 1)
 	lc.registerSignals()
 
+	eventChan := lc.courier.Events()
+
 SignalLoop:
 	for {
 		select {
 		case <-lc.shutdownChan:
-			lc.cleanShutdown()
+			lc.courier.Stop()
 			break SignalLoop
 		case <-lc.reloadChan:
-			lc.reloadConfig()
-		case finished := <-harvesterWait:
-			if finished.Error != nil {
-				log.Notice("An error occurred reading from stdin at offset %d: %s", finished.LastReadOffset, finished.Error)
-			} else {
-				log.Notice("Finished reading from stdin at offset %d", finished.LastReadOffset)
+			if err := lc.reloadConfig(); err != nil {
+				log.Warning("Configuration reload failed, the previous configuration remains in use: %s", err)
+			}
+		case _, ok := <-eventChan:
+			// The courier stops by itself once it finishes reading from stdin
+			if !ok {
+				break SignalLoop
 			}
-			lc.harvester = nil
-
-			// Flush the spooler
-			spoolerImp.Flush()
-
-			// Wait for StdinRegistrar to receive ACK for the last event we sent
-			registrarImp.(*StdinRegistrar).Wait(finished.LastEventOffset)
-
-			lc.cleanShutdown()
-			break SignalLoop
 		}
 	}
 
@@ -208,6 +161,7 @@ func (lc *logCourier) startUp() {
 	if configTest {
 		if err == nil {
 			fmt.Printf("Configuration OK\n")
+			lc.printConfigSummary()
 			os.Exit(0)
 		}
 		fmt.Printf("Configuration test failed: %s\n", err)
@@ -241,18 +195,56 @@ func (lc *logCourier) startUp() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 }
 
+// printConfigSummary prints a summary of the loaded configuration for the
+// -config-test flag
+func (lc *logCourier) printConfigSummary() {
+	for n, network := range lc.config.Networks {
+		fmt.Printf("Network %d: %s transport with %d server(s)\n", n, network.Transport, len(network.Servers))
+		for _, server := range network.Servers {
+			fmt.Printf("  %s\n", server)
+		}
+	}
+
+	if lc.config.ReadStdin {
+		fmt.Printf("Reading from stdin with codecs: %s\n", codecNames(lc.config.Stdin.Codecs))
+		return
+	}
+
+	for k, files := range lc.config.Files {
+		fmt.Printf("File group %d: %d path(s) with codecs: %s\n", k, len(files.Paths), codecNames(files.Codecs))
+		for _, path := range files.Paths {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+}
+
+// codecNames returns a comma separated list of the names of the given codecs
+func codecNames(codecs []config.CodecStub) string {
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		names[i] = codec.Name
+	}
+	return strings.Join(names, ", ")
+}
+
 // configureLogging enables the available logging backends
 func (lc *logCourier) configureLogging() (err error) {
 	backends := make([]logging.Backend, 0, 1)
 
+	// JSON log lines carry their own timestamp
+	flags := stdlog.LstdFlags | stdlog.Lmicroseconds
+	if lc.config.General.LogFormat == "json" {
+		flags = 0
+	}
+
 	// First, the stdout backend
 	if lc.config.General.LogStdout {
-		backends = append(backends, logging.NewLogBackend(os.Stdout, "", stdlog.LstdFlags|stdlog.Lmicroseconds))
+		backends = append(backends, logging.NewLogBackend(os.Stdout, "", flags))
 	}
 
 	// Log file?
 	if lc.config.General.LogFile != "" {
-		lc.logFile, err = NewDefaultLogBackend(lc.config.General.LogFile, "", stdlog.LstdFlags|stdlog.Lmicroseconds)
+		lc.logFile, err = NewDefaultLogBackend(lc.config.General.LogFile, "", flags)
 		if err != nil {
 			return
 		}
@@ -264,6 +256,12 @@ func (lc *logCourier) configureLogging() (err error) {
 		return
 	}
 
+	if lc.config.General.LogFormat == "json" {
+		for i, backend := range backends {
+			backends[i] = logging.NewBackendFormatter(backend, &JSONLogFormatter{})
+		}
+	}
+
 	// Set backends BEFORE log level (or we reset log level)
 	logging.SetBackend(backends...)
 
@@ -273,14 +271,23 @@ func (lc *logCourier) configureLogging() (err error) {
 	return nil
 }
 
-// loadConfig loads the configuration data
+// loadConfig loads the configuration data, leaving the current configuration
+// in place if it fails to load
 func (lc *logCourier) loadConfig() error {
-	lc.config = config.NewConfig()
-	if err := lc.config.Load(lc.configFile, true); err != nil {
+	newConfig := config.NewConfig()
+	if err := newConfig.Load(lc.configFile, true); err != nil {
 		return err
 	}
 
+	// The -stdin flag reads from stdin in the same way as a file group with the
+	// path "-"
 	if lc.stdin {
+		newConfig.ReadStdin = true
+	}
+
+	lc.config = newConfig
+
+	if lc.config.ReadStdin {
 		// TODO: Where to find stdin config for codec and fields?
 	} else if len(lc.config.Files) == 0 {
 		log.Warning("No file groups were found in the configuration.")
@@ -289,15 +296,23 @@ func (lc *logCourier) loadConfig() error {
 	return nil
 }
 
-// reloadConfig reloads the configuration data and submits to all running
-// routines in the pipeline that are subscribed to it, so they may update their
-// runtime configuration
+// reloadConfig reloads the configuration data and submits it to the courier,
+// so that all running routines in the pipeline may update their runtime
+// configuration
 func (lc *logCourier) reloadConfig() error {
+	lc.reloadMutex.Lock()
+	defer lc.reloadMutex.Unlock()
+
+	oldConfig := lc.config
+
 	if err := lc.loadConfig(); err != nil {
 		return err
 	}
 
-	log.Notice("Configuration reload successful")
+	if err := lc.courier.Reload(lc.config); err != nil {
+		lc.config = oldConfig
+		return err
+	}
 
 	// Update the log level
 	logging.SetLevel(lc.config.General.LogLevel, "")
@@ -308,22 +323,5 @@ func (lc *logCourier) reloadConfig() error {
 		log.Notice("Log file reopened")
 	}
 
-	// Pass the new config to the pipeline workers
-	lc.pipeline.SendConfig(lc.config)
-
 	return nil
 }
-
-// cleanShutdown initiates a clean shutdown of log-courier
-func (lc *logCourier) cleanShutdown() {
-	log.Notice("Initiating shutdown")
-
-	if lc.harvester != nil {
-		lc.harvester.Stop()
-		finished := <-lc.harvester.OnFinish()
-		log.Notice("Aborted reading from stdin at offset %d", finished.LastReadOffset)
-	}
-
-	lc.pipeline.Shutdown()
-	lc.pipeline.Wait()
-}
@@ -43,7 +43,7 @@ func (lc *logCourier) registerSignals() {
 func (lc *logCourier) configureLoggingPlatform(backends *[]logging.Backend) error {
 	// Make it color if it's a TTY
 	// TODO: This could be prone to problems when updating logging in future
-	if lc.isatty(os.Stdout) && lc.config.General.LogStdout {
+	if lc.isatty(os.Stdout) && lc.config.General.LogStdout && lc.config.General.LogFormat == "text" {
 		(*backends)[0].(*logging.LogBackend).Color = true
 	}
 
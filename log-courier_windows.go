@@ -21,17 +21,126 @@ package main
 import (
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"unsafe"
 
 	"gopkg.in/op/go-logging.v1"
 )
 
+const (
+	// reloadPipeName is the named pipe that accepts a "reload" command to
+	// trigger a configuration reload, as there is no SIGHUP on Windows
+	reloadPipeName = `\\.\pipe\log-courier`
+
+	pipeAccessInbound      = 0x00000001
+	pipeTypeByte           = 0x00000000
+	pipeReadmodeByte       = 0x00000000
+	pipeWait               = 0x00000000
+	pipeUnlimitedInstances = 255
+	pipeBufferSize         = 512
+
+	errorPipeConnected syscall.Errno = 535
+)
+
+var (
+	modkernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW    = modkernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = modkernel32.NewProc("ConnectNamedPipe")
+	procDisconnectNamedPipe = modkernel32.NewProc("DisconnectNamedPipe")
+)
+
 // registerSignals registers platform specific shutdown signals with the shutdown
 // channel and reload signals with the reload channel
 func (lc *logCourier) registerSignals() {
 	// Windows only supports os.Interrupt
 	signal.Notify(lc.shutdownChan, os.Interrupt)
 
-	// No reload signal for Windows - implementation will have to wait
+	// No reload signal for Windows, so listen on a named pipe for a reload
+	// command instead
+	pipe, err := lc.createReloadPipe()
+	if err != nil {
+		log.Warning("Failed to create reload pipe %s, configuration reload will be unavailable: %s", reloadPipeName, err)
+		return
+	}
+
+	go lc.reloadPipeRoutine(pipe)
+}
+
+// createReloadPipe creates the named pipe used to receive reload commands
+func (lc *logCourier) createReloadPipe() (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(reloadPipeName)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+
+	r1, _, err := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(name)),
+		pipeAccessInbound,
+		pipeTypeByte|pipeReadmodeByte|pipeWait,
+		pipeUnlimitedInstances,
+		pipeBufferSize,
+		pipeBufferSize,
+		0,
+		0,
+	)
+	if syscall.Handle(r1) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, err
+	}
+
+	return syscall.Handle(r1), nil
+}
+
+// reloadPipeRoutine accepts connections to the reload pipe one at a time and
+// triggers a configuration reload whenever a client writes "reload"
+func (lc *logCourier) reloadPipeRoutine(pipe syscall.Handle) {
+	defer syscall.CloseHandle(pipe)
+
+	for {
+		r1, _, err := procConnectNamedPipe.Call(uintptr(pipe), 0)
+		if r1 == 0 && err != errorPipeConnected {
+			log.Warning("Failed to accept connection on reload pipe, configuration reload will be unavailable: %s", err)
+			return
+		}
+
+		command := lc.readReloadPipe(pipe)
+
+		procDisconnectNamedPipe.Call(uintptr(pipe))
+
+		if command != "reload" {
+			log.Warning("Ignoring unknown command received on reload pipe: %s", command)
+			continue
+		}
+
+		log.Notice("Configuration reload requested via %s", reloadPipeName)
+
+		// Same as receiving SIGHUP on *nix, don't block if a reload is already
+		// pending
+		select {
+		case lc.reloadChan <- syscall.SIGHUP:
+		default:
+		}
+	}
+}
+
+// readReloadPipe reads the command written by a client until it closes its
+// end of the pipe, returning it without surrounding whitespace
+func (lc *logCourier) readReloadPipe(pipe syscall.Handle) string {
+	var command []byte
+	buffer := make([]byte, pipeBufferSize)
+
+	for len(command) < pipeBufferSize {
+		var read uint32
+		if err := syscall.ReadFile(pipe, buffer, &read, nil); err != nil {
+			// ERROR_BROKEN_PIPE is the client closing, any other error also ends
+			// the command
+			break
+		}
+
+		command = append(command, buffer[:read]...)
+	}
+
+	return strings.TrimSpace(string(command))
 }
 
 // configureLoggingPlatform enables platform specific logging backends in the
@@ -17,10 +17,13 @@
 package main
 
 import (
+	"encoding/json"
 	"gopkg.in/op/go-logging.v1"
+	"io"
 	"io/ioutil"
 	golog "log"
 	"os"
+	"time"
 )
 
 var log *logging.Logger
@@ -85,3 +88,23 @@ func (f *DefaultLogBackend) Close() {
 
 	f.file = nil
 }
+
+// JSONLogFormatter formats each log record as a single line JSON object
+// containing the timestamp, level, module and message
+type JSONLogFormatter struct{}
+
+// Format writes the record to output as JSON
+func (f *JSONLogFormatter) Format(calldepth int, rec *logging.Record, output io.Writer) error {
+	encoded, err := json.Marshal(map[string]interface{}{
+		"timestamp": rec.Time.Format(time.RFC3339Nano),
+		"level":     rec.Level.String(),
+		"module":    rec.Module,
+		"message":   rec.Message(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = output.Write(encoded)
+	return err
+}
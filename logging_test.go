@@ -0,0 +1,57 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"gopkg.in/op/go-logging.v1"
+	"testing"
+	"time"
+)
+
+func TestJSONLogFormatter(t *testing.T) {
+	var buffer bytes.Buffer
+
+	logger := logging.MustGetLogger("json-test")
+	logger.SetBackend(logging.AddModuleLevel(logging.NewBackendFormatter(logging.NewLogBackend(&buffer, "", 0), &JSONLogFormatter{})))
+
+	logger.Warning("Test message %d: %s", 1, "\"quoted\"")
+
+	lines := bytes.Split(bytes.TrimRight(buffer.Bytes(), "\n"), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("Expected a single log line, got %d: %s", len(lines), buffer.String())
+	}
+
+	var entry map[string]string
+	if err := json.Unmarshal(lines[0], &entry); err != nil {
+		t.Fatalf("Log line is not valid JSON: %s: %s", err, lines[0])
+	}
+
+	if entry["level"] != "WARNING" {
+		t.Errorf("Unexpected level: %s", entry["level"])
+	}
+	if entry["module"] != "json-test" {
+		t.Errorf("Unexpected module: %s", entry["module"])
+	}
+	if entry["message"] != "Test message 1: \"quoted\"" {
+		t.Errorf("Unexpected message: %s", entry["message"])
+	}
+	if _, err := time.Parse(time.RFC3339Nano, entry["timestamp"]); err != nil {
+		t.Errorf("Unexpected timestamp: %s", entry["timestamp"])
+	}
+}